@@ -1,30 +1,80 @@
 package main
 
 import (
-	"github.com/joho/godotenv"
+	"errors"
+	"os"
+
 	"github.com/spf13/cobra"
 
+	"github.com/rollwave-dev/rollwave/internal/cmd/applycmd"
+	"github.com/rollwave-dev/rollwave/internal/cmd/buildcmd"
 	"github.com/rollwave-dev/rollwave/internal/cmd/deploycmd"
+	"github.com/rollwave-dev/rollwave/internal/cmd/historycmd"
 	"github.com/rollwave-dev/rollwave/internal/cmd/initcmd"
+	"github.com/rollwave-dev/rollwave/internal/cmd/lockcmd"
+	"github.com/rollwave-dev/rollwave/internal/cmd/plancmd"
 	"github.com/rollwave-dev/rollwave/internal/cmd/prunecmd"
+	"github.com/rollwave-dev/rollwave/internal/cmd/rendercmd"
+	"github.com/rollwave-dev/rollwave/internal/cmd/rollbackcmd"
+	"github.com/rollwave-dev/rollwave/internal/cmd/runcmd"
 	"github.com/rollwave-dev/rollwave/internal/cmd/secretcmd"
 	"github.com/rollwave-dev/rollwave/internal/cmd/statuscmd"
+	"github.com/rollwave-dev/rollwave/internal/envfile"
+	"github.com/rollwave-dev/rollwave/internal/job"
+	"github.com/rollwave-dev/rollwave/internal/output"
 )
 
 func main() {
-	// Load .env if present
-	_ = godotenv.Load()
-
 	root := &cobra.Command{
 		Use:   "rollwave",
 		Short: "Rollwave",
+		// Every command shares the output format and env files, so set them up before any of them runs
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			format, _ := cmd.Flags().GetString(output.Flag)
+			if err := output.Setup(cmd, format); err != nil {
+				return err
+			}
+			return loadEnvFiles(cmd)
+		},
 	}
+	root.PersistentFlags().String(output.Flag, output.FormatText, "Output format: text, json or yaml")
+	root.PersistentFlags().StringSlice("env-file", nil, "Extra dotenv files to load, on top of the env_files of rollwave.yml")
 
 	root.AddCommand(initcmd.New())
 	root.AddCommand(deploycmd.New())
+	root.AddCommand(buildcmd.New())
 	root.AddCommand(secretcmd.New())
 	root.AddCommand(prunecmd.New())
 	root.AddCommand(statuscmd.New())
+	root.AddCommand(rollbackcmd.New())
+	root.AddCommand(historycmd.New())
+	root.AddCommand(plancmd.New())
+	root.AddCommand(applycmd.New())
+	root.AddCommand(rendercmd.New())
+	root.AddCommand(runcmd.New())
+	root.AddCommand(lockcmd.New())
+
+	if err := root.Execute(); err != nil {
+		// 'rollwave run' exits with the code of the command it ran
+		var exitErr *job.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
+		os.Exit(1)
+	}
+}
+
+// loadEnvFiles exports the dotenv files of the environment selected with the
+// command's --config and --env flags, then those given with --env-file.
+func loadEnvFiles(cmd *cobra.Command) error {
+	extra, _ := cmd.Flags().GetStringSlice("env-file")
 
-	root.Execute()
+	cfgPath, env := "rollwave.yml", ""
+	if f := cmd.Flags().Lookup("config"); f != nil && f.Value.String() != "" {
+		cfgPath = f.Value.String()
+	}
+	if f := cmd.Flags().Lookup("env"); f != nil {
+		env = f.Value.String()
+	}
+	return envfile.Load(envfile.Files(cfgPath, env, extra))
 }
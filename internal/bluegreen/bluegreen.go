@@ -0,0 +1,381 @@
+package bluegreen
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+
+	"github.com/rollwave-dev/rollwave/internal/compose"
+	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/pipeline"
+	"github.com/rollwave-dev/rollwave/internal/prune"
+	"github.com/rollwave-dev/rollwave/internal/rollout"
+	"github.com/rollwave-dev/rollwave/internal/stack"
+	"github.com/rollwave-dev/rollwave/internal/swarm"
+)
+
+// Colours of the two parallel stacks.
+const (
+	Blue  = "blue"
+	Green = "green"
+)
+
+// PortTimeout bounds how long the new colour waits for the ports released by
+// the old stack.
+const PortTimeout = 30 * time.Second
+
+// Labels stamped onto the services of each colour.
+const (
+	LabelColor  = "io.rollwave.color"
+	LabelActive = "io.rollwave.active"
+)
+
+// StackName returns the name of the stack running the given colour.
+func StackName(stackName, color string) string {
+	return stackName + "_" + color
+}
+
+// ActiveColor returns the colour currently receiving traffic, or "" if neither colour is deployed.
+func ActiveColor(ctx context.Context, cli engine.Swarm, stackName string) (string, error) {
+	var deployed []string
+	for _, color := range []string{Blue, Green} {
+		services, err := listServices(ctx, cli, StackName(stackName, color))
+		if err != nil {
+			return "", err
+		}
+		if len(services) == 0 {
+			continue
+		}
+		for _, svc := range services {
+			if svc.Spec.Labels[LabelActive] == "true" {
+				return color, nil
+			}
+		}
+		deployed = append(deployed, color)
+	}
+
+	if len(deployed) == 1 {
+		return deployed[0], nil
+	}
+	return "", nil
+}
+
+// LiveStack returns the Swarm stack currently serving the configured stack. With the
+// blue/green strategy the services and networks belong to the active colour stack.
+func LiveStack(ctx context.Context, cli engine.Swarm, cfg *config.Config) (string, error) {
+	if cfg.Deploy.Strategy != config.StrategyBlueGreen {
+		return cfg.Stack.Name, nil
+	}
+	color, err := ActiveColor(ctx, cli, cfg.Stack.Name)
+	if err != nil {
+		return "", err
+	}
+	if color == "" {
+		return cfg.Stack.Name, nil
+	}
+	return StackName(cfg.Stack.Name, color), nil
+}
+
+// Deploy runs the new version as a parallel stack in the inactive colour, waits for it
+// to converge, switches traffic to it and removes the old colour, or the plain stack
+// deployed before blue/green was enabled. If the new colour fails, it is removed and
+// the old stack keeps serving traffic.
+func Deploy(ctx context.Context, cli engine.Engine, bg config.BlueGreenConfig, opt pipeline.Options) error {
+	if opt.Stdout == nil {
+		opt.Stdout = os.Stdout
+	}
+	if opt.Stderr == nil {
+		opt.Stderr = os.Stderr
+	}
+	mode := bg.Switch
+	if mode == "" {
+		mode = config.SwitchLabels
+	}
+
+	active, err := ActiveColor(ctx, cli, opt.Stack)
+	if err != nil {
+		return err
+	}
+	target := Blue
+	if active == Blue {
+		target = Green
+	}
+	targetStack := StackName(opt.Stack, target)
+
+	if active == "" {
+		fmt.Fprintf(opt.Stdout, "🔵 Blue/green: no active colour, deploying '%s'\n", targetStack)
+	} else {
+		fmt.Fprintf(opt.Stdout, "🔵 Blue/green: '%s' is live, deploying '%s'\n", StackName(opt.Stack, active), targetStack)
+	}
+
+	// The stack that must make way for the new colour: the active colour, or the
+	// plain stack deployed before switching to blue/green
+	oldStack := ""
+	if active != "" {
+		oldStack = StackName(opt.Stack, active)
+	} else {
+		plain, err := listServices(ctx, cli, opt.Stack)
+		if err != nil {
+			return err
+		}
+		if len(plain) > 0 {
+			oldStack = opt.Stack
+			fmt.Fprintf(opt.Stdout, "🔵 Blue/green: '%s' was deployed without blue/green, it will be replaced\n", opt.Stack)
+		}
+	}
+
+	// 1. Prepare the compose file for the idle colour. Both colours share the
+	// volumes of the logical stack, so data survives the switch.
+	composeYaml, err := compose.NameVolumes(opt.Compose, opt.Stack)
+	if err != nil {
+		return err
+	}
+	composeYaml, err = compose.SetServiceLabels(composeYaml, withLabels(bg.InactiveLabels, map[string]string{
+		LabelColor:  target,
+		LabelActive: "false",
+	}))
+	if err != nil {
+		return err
+	}
+
+	// Both colours cannot publish the same ports: the new colour starts without
+	// them and takes them over at the switch
+	composeYaml, raw, err := compose.StripPorts(composeYaml)
+	if err != nil {
+		return err
+	}
+	ports, err := convertPorts(raw)
+	if err != nil {
+		return err
+	}
+
+	// 2. Deploy the new colour. Once it converged, traffic is switched to it and
+	// the deploy is verified before the release is recorded as succeeded.
+	activeLabels := withLabels(bg.ActiveLabels, map[string]string{LabelActive: "true"})
+	inactiveLabels := withLabels(bg.InactiveLabels, map[string]string{LabelActive: "false"})
+
+	p := opt
+	p.Stack = targetStack
+	p.ReleaseStack = opt.Stack
+	p.LiveStack = oldStack
+	p.Compose = composeYaml
+	p.AutoRollback = false // the old colour is untouched, removing the new one is enough
+	p.Detach = false
+	p.Prune = false
+	p.Verify = func(ctx context.Context) error {
+		released, err := switchTraffic(ctx, cli, oldStack, targetStack, ports, activeLabels, inactiveLabels, mode, opt)
+		if err == nil && opt.Verify != nil {
+			err = opt.Verify(ctx)
+		}
+		if err != nil {
+			fmt.Fprintf(opt.Stderr, "❌ Switching back to '%s'.\n", orNone(oldStack))
+			if rbErr := switchBack(context.Background(), cli, oldStack, targetStack, released, activeLabels, inactiveLabels); rbErr != nil {
+				fmt.Fprintf(opt.Stderr, "⚠️  Failed to switch back: %v\n", rbErr)
+			}
+		}
+		return err
+	}
+
+	if _, err := pipeline.Deploy(ctx, cli, p); err != nil {
+		fmt.Fprintf(opt.Stderr, "❌ New colour failed, removing '%s'. '%s' keeps serving traffic.\n", targetStack, orNone(oldStack))
+		if rmErr := stack.Remove(context.Background(), cli, targetStack, opt.Stdout, opt.Stderr); rmErr != nil {
+			fmt.Fprintf(opt.Stderr, "⚠️  Failed to remove '%s': %v\n", targetStack, rmErr)
+		}
+		return err
+	}
+
+	// 3. Remove the old stack
+	if oldStack != "" {
+		if err := stack.Remove(ctx, cli, oldStack, opt.Stdout, opt.Stderr); err != nil {
+			fmt.Fprintf(opt.Stderr, "⚠️  Failed to remove '%s': %v\n", oldStack, err)
+		}
+	}
+
+	fmt.Fprintf(opt.Stdout, "✅ '%s' is live.\n", targetStack)
+
+	// --- AUTO PRUNE ---
+	if opt.Prune {
+		fmt.Fprintln(opt.Stdout, "")
+		if _, err := prune.Run(ctx, cli, opt.Stack, opt.SecretPrefix, opt.Stdout, opt.Stderr); err != nil {
+			fmt.Fprintf(opt.Stderr, "⚠️  Auto-prune failed: %v\n", err)
+		}
+	}
+
+	return nil
+}
+
+// --- Helpers ---
+
+// switchTraffic activates the new colour, then moves the published ports. The
+// old stack releases them with a service update and the new colour publishes
+// them right after, so they are only unbound for a moment. It returns the
+// ports the old stack released, also on failure, so they can be handed back.
+func switchTraffic(ctx context.Context, cli engine.Swarm, oldStack, targetStack string, ports map[string][]dockerswarm.PortConfig, activeLabels, inactiveLabels map[string]string, mode string, opt pipeline.Options) (map[string][]dockerswarm.PortConfig, error) {
+	fmt.Fprintf(opt.Stdout, "🔀 Switching traffic to '%s' (%s)...\n", targetStack, mode)
+
+	if err := updateServices(ctx, cli, targetStack, func(spec *dockerswarm.ServiceSpec) {
+		setLabels(spec, activeLabels)
+	}); err != nil {
+		return nil, fmt.Errorf("activate %s: %w", targetStack, err)
+	}
+
+	var released map[string][]dockerswarm.PortConfig
+	if oldStack != "" {
+		var err error
+		if released, err = unpublish(ctx, cli, oldStack, inactiveLabels); err != nil {
+			return released, fmt.Errorf("deactivate %s: %w", oldStack, err)
+		}
+	}
+	if len(ports) == 0 {
+		return released, nil
+	}
+
+	if err := publish(ctx, cli, targetStack, ports); err != nil {
+		return released, fmt.Errorf("publish ports on %s: %w", targetStack, err)
+	}
+	if err := rollout.Wait(ctx, cli, rollout.Options{
+		Stack:   targetStack,
+		Timeout: opt.Timeout,
+		Stdout:  opt.Stdout,
+	}); err != nil {
+		return released, fmt.Errorf("after switching ports: %w", err)
+	}
+	return released, nil
+}
+
+func listServices(ctx context.Context, cli engine.Swarm, stackName string) ([]dockerswarm.Service, error) {
+	f := filters.NewArgs()
+	f.Add("label", swarm.StackLabel+"="+stackName)
+
+	services, err := cli.ServiceList(ctx, types.ServiceListOptions{Filters: f})
+	if err != nil {
+		return nil, fmt.Errorf("list services: %w", err)
+	}
+	return services, nil
+}
+
+// updateServices applies mutate to the spec of every service in the stack.
+func updateServices(ctx context.Context, cli engine.Swarm, stackName string, mutate func(*dockerswarm.ServiceSpec)) error {
+	services, err := listServices(ctx, cli, stackName)
+	if err != nil {
+		return err
+	}
+	for _, svc := range services {
+		spec := svc.Spec
+		mutate(&spec)
+		if _, err := cli.ServiceUpdate(ctx, svc.ID, svc.Version, spec, types.ServiceUpdateOptions{}); err != nil {
+			return fmt.Errorf("update %s: %w", svc.Spec.Name, err)
+		}
+	}
+	return nil
+}
+
+// unpublish stamps labels onto the services of a stack and clears their
+// published ports. It returns the ports it released by service name, without
+// the stack prefix.
+func unpublish(ctx context.Context, cli engine.Swarm, stackName string, labels map[string]string) (map[string][]dockerswarm.PortConfig, error) {
+	released := make(map[string][]dockerswarm.PortConfig)
+	err := updateServices(ctx, cli, stackName, func(spec *dockerswarm.ServiceSpec) {
+		setLabels(spec, labels)
+		if spec.EndpointSpec == nil || len(spec.EndpointSpec.Ports) == 0 {
+			return
+		}
+		released[strings.TrimPrefix(spec.Name, stackName+"_")] = spec.EndpointSpec.Ports
+		endpoint := *spec.EndpointSpec
+		endpoint.Ports = nil
+		spec.EndpointSpec = &endpoint
+	})
+	return released, err
+}
+
+// publish publishes ports on the services of a stack, keyed by service name
+// without the stack prefix. Swarm may need a moment to free ports another
+// service just released, so failed updates are retried for PortTimeout.
+func publish(ctx context.Context, cli engine.Swarm, stackName string, ports map[string][]dockerswarm.PortConfig) error {
+	deadline := time.Now().Add(PortTimeout)
+	for {
+		err := updateServices(ctx, cli, stackName, func(spec *dockerswarm.ServiceSpec) {
+			short := strings.TrimPrefix(spec.Name, stackName+"_")
+			if len(ports[short]) == 0 {
+				return
+			}
+			endpoint := dockerswarm.EndpointSpec{Mode: dockerswarm.ResolutionModeVIP}
+			if spec.EndpointSpec != nil {
+				endpoint = *spec.EndpointSpec
+			}
+			endpoint.Ports = ports[short]
+			spec.EndpointSpec = &endpoint
+		})
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// switchBack hands traffic back to the old stack after a failed switch.
+func switchBack(ctx context.Context, cli engine.Swarm, oldStack, targetStack string, released map[string][]dockerswarm.PortConfig, activeLabels, inactiveLabels map[string]string) error {
+	if _, err := unpublish(ctx, cli, targetStack, inactiveLabels); err != nil {
+		return err
+	}
+	if oldStack == "" {
+		return nil
+	}
+	if err := updateServices(ctx, cli, oldStack, func(spec *dockerswarm.ServiceSpec) {
+		setLabels(spec, activeLabels)
+	}); err != nil {
+		return err
+	}
+	return publish(ctx, cli, oldStack, released)
+}
+
+func setLabels(spec *dockerswarm.ServiceSpec, labels map[string]string) {
+	if spec.Labels == nil {
+		spec.Labels = make(map[string]string)
+	}
+	for k, v := range labels {
+		spec.Labels[k] = v
+	}
+}
+
+func withLabels(base, extra map[string]string) map[string]string {
+	out := make(map[string]string, len(base)+len(extra))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range extra {
+		out[k] = v
+	}
+	return out
+}
+
+// convertPorts turns the compose port entries of each service into Swarm port configs.
+func convertPorts(raw map[string][]interface{}) (map[string][]dockerswarm.PortConfig, error) {
+	out := make(map[string][]dockerswarm.PortConfig)
+	for svc, entries := range raw {
+		ports, err := stack.ConvertPorts(entries)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", svc, err)
+		}
+		out[svc] = ports
+	}
+	return out, nil
+}
+
+func orNone(stackName string) string {
+	if stackName == "" {
+		return "(nothing)"
+	}
+	return stackName
+}
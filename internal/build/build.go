@@ -8,6 +8,10 @@ import (
 	"os/exec"
 	"strings"
 	"time"
+
+	"github.com/docker/docker/api/types/registry"
+
+	"github.com/rollwave-dev/rollwave/internal/engine"
 )
 
 // Options defines the parameters for the build process.
@@ -15,13 +19,15 @@ type Options struct {
 	ImageName  string // e.g. ttl.sh/my-app
 	ContextDir string // e.g. .
 	Dockerfile string // e.g. Dockerfile
+	Tag        string // optional, defaults to Tag()
+	DryRun     bool   // print the build and push steps without running them
 	Stdout     io.Writer
 	Stderr     io.Writer
 }
 
 // Login checks for ROLLWAVE_REGISTRY_USER and ROLLWAVE_REGISTRY_PASSWORD.
-// If present, it extracts the registry server from the imageName and performs 'docker login'.
-func Login(ctx context.Context, imageName string, stdout, stderr io.Writer) error {
+// If present, it extracts the registry server from the imageName and logs in through the engine.
+func Login(ctx context.Context, images engine.Images, imageName string, stdout, stderr io.Writer) error {
 	user := os.Getenv("ROLLWAVE_REGISTRY_USER")
 	pass := os.Getenv("ROLLWAVE_REGISTRY_PASSWORD")
 
@@ -38,26 +44,36 @@ func Login(ctx context.Context, imageName string, stdout, stderr io.Writer) erro
 
 	fmt.Fprintf(stdout, "🔑 Authenticating to %s as user '%s'...\n", targetDesc, user)
 
-	args := []string{"login", "-u", user, "--password-stdin"}
-	if registry != "" {
-		args = append(args, registry)
-	}
+	return images.Login(ctx, engine.LoginOptions{
+		Registry: registry,
+		Username: user,
+		Password: pass,
+		Stdout:   stdout,
+		Stderr:   stderr,
+	})
+}
 
-	cmd := exec.CommandContext(ctx, "docker", args...)
-	cmd.Stdin = strings.NewReader(pass)
-	cmd.Stdout = stdout
-	cmd.Stderr = stderr
+// RegistryAuth returns the encoded registry credentials for imageName, suitable for
+// Docker SDK calls that pull images (e.g. ServiceUpdate). It returns an empty string
+// when ROLLWAVE_REGISTRY_USER or ROLLWAVE_REGISTRY_PASSWORD is not set.
+func RegistryAuth(imageName string) (string, error) {
+	user := os.Getenv("ROLLWAVE_REGISTRY_USER")
+	pass := os.Getenv("ROLLWAVE_REGISTRY_PASSWORD")
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("docker login failed: %w", err)
+	if user == "" || pass == "" {
+		return "", nil
 	}
 
-	return nil
+	return registry.EncodeAuthConfig(registry.AuthConfig{
+		Username:      user,
+		Password:      pass,
+		ServerAddress: extractRegistry(imageName),
+	})
 }
 
-// Run executes the docker build, tag, and push commands.
+// Run builds, tags and pushes the image. images may be nil for dry runs.
 // It returns the full image name including the generated tag.
-func Run(ctx context.Context, opt Options) (string, error) {
+func Run(ctx context.Context, images engine.Images, opt Options) (string, error) {
 	if opt.Stdout == nil {
 		opt.Stdout = os.Stdout
 	}
@@ -66,50 +82,51 @@ func Run(ctx context.Context, opt Options) (string, error) {
 	}
 
 	// 1. Generate Tag (Git hash or Timestamp)
-	tag := getGitTag()
+	tag := opt.Tag
+	if tag == "" {
+		tag = Tag()
+	}
 	fullImage := fmt.Sprintf("%s:%s", opt.ImageName, tag)
 	latestImage := fmt.Sprintf("%s:latest", opt.ImageName)
 
+	if opt.DryRun {
+		fmt.Fprintf(opt.Stdout, "[dry-run] docker build -t %s -t %s -f %s %s\n", fullImage, latestImage, opt.Dockerfile, opt.ContextDir)
+		fmt.Fprintf(opt.Stdout, "[dry-run] docker push %s\n", fullImage)
+		fmt.Fprintf(opt.Stdout, "[dry-run] docker push %s\n", latestImage)
+		return fullImage, nil
+	}
+
 	fmt.Fprintf(opt.Stdout, "📦 Building image: %s\n", fullImage)
 
 	// 2. Docker Build
-	buildCmd := exec.CommandContext(ctx, "docker", "build",
-		"-t", fullImage,
-		"-t", latestImage,
-		"-f", opt.Dockerfile,
-		opt.ContextDir,
-	)
-	buildCmd.Stdout = opt.Stdout
-	buildCmd.Stderr = opt.Stderr
-
-	if err := buildCmd.Run(); err != nil {
-		return "", fmt.Errorf("docker build failed: %w", err)
+	if err := images.Build(ctx, engine.BuildOptions{
+		Tags:       []string{fullImage, latestImage},
+		Dockerfile: opt.Dockerfile,
+		ContextDir: opt.ContextDir,
+		Stdout:     opt.Stdout,
+		Stderr:     opt.Stderr,
+	}); err != nil {
+		return "", err
 	}
 
 	// 3. Docker Push (Versioned)
 	fmt.Fprintf(opt.Stdout, "⬆️  Pushing image %s ...\n", fullImage)
-	if err := pushImage(ctx, fullImage, opt.Stdout, opt.Stderr); err != nil {
+	if err := images.Push(ctx, fullImage, opt.Stdout, opt.Stderr); err != nil {
 		return "", err
 	}
 
 	// 4. Docker Push (Latest) - allows deploy without build
 	fmt.Fprintf(opt.Stdout, "⬆️  Pushing image %s ...\n", latestImage)
-	if err := pushImage(ctx, latestImage, opt.Stdout, opt.Stderr); err != nil {
+	if err := images.Push(ctx, latestImage, opt.Stdout, opt.Stderr); err != nil {
 		return "", err
 	}
 
 	return fullImage, nil
 }
 
-func pushImage(ctx context.Context, image string, stdout, stderr io.Writer) error {
-	cmd := exec.CommandContext(ctx, "docker", "push", image)
-	cmd.Stdout = stdout
-	cmd.Stderr = stderr
-	return cmd.Run()
-}
-
-// getGitTag returns the short git hash or a timestamp if git is unavailable.
-func getGitTag() string {
+// Tag returns the tag for newly built images: the short git hash,
+// or a timestamp if git is unavailable.
+func Tag() string {
 	cmd := exec.Command("git", "rev-parse", "--short", "HEAD")
 	out, err := cmd.Output()
 	if err == nil {
@@ -0,0 +1,383 @@
+package canary
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+
+	"github.com/rollwave-dev/rollwave/internal/build"
+	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/probe"
+	"github.com/rollwave-dev/rollwave/internal/rollout"
+	"github.com/rollwave-dev/rollwave/internal/stack"
+	"github.com/rollwave-dev/rollwave/internal/swarm"
+)
+
+// LabelCanary marks canary services with the name of the service they shadow.
+const LabelCanary = swarm.CanaryLabel
+
+// Suffix is appended to the Swarm service name of a canary.
+const Suffix = "_canary"
+
+// DefaultProbeInterval is used when a probe does not set an interval.
+const DefaultProbeInterval = 5 * time.Second
+
+// Options defines the parameters for a canary rollout.
+type Options struct {
+	Stack   string
+	Compose []byte        // rendered compose file (interpolated, images replaced, secrets rewritten)
+	Timeout time.Duration // how long a step may take to converge
+
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Run rolls the new image of every configured service out through its canary steps.
+//
+// A canary runs the new version of the service as converted from the compose file:
+// image, environment, command, secrets, configs and resources. It joins the same
+// networks under the same alias, so it receives a share of the internal traffic,
+// while the live service is scaled down by the same number of replicas. Each step
+// is held for the configured period while task failures and the optional probe are
+// watched. When all steps pass the canary is removed and the caller deploys the
+// stack, which promotes the new image to every replica. On failure the canary is
+// removed, the live service is scaled back and an error is returned.
+func Run(ctx context.Context, cli engine.Swarm, services map[string]config.CanaryConfig, opt Options) error {
+	if opt.Stdout == nil {
+		opt.Stdout = os.Stdout
+	}
+	if opt.Stderr == nil {
+		opt.Stderr = os.Stderr
+	}
+	if opt.Timeout <= 0 {
+		opt.Timeout = rollout.DefaultTimeout
+	}
+
+	// Relative paths are resolved like they are for the stack deploy
+	workDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	specs, err := stack.Convert(opt.Stack, opt.Compose, workDir)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, ok := specs.Services[name]; !ok {
+			return fmt.Errorf("canary service '%s' is not defined in the compose file", name)
+		}
+	}
+
+	// The canaries use the secrets and configs of the new version, which the
+	// stack deploy would otherwise create later
+	if err := stack.Prepare(ctx, cli, specs, opt.Stdout); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err := runService(ctx, cli, name, specs.Services[name], services[name], opt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runService(ctx context.Context, cli engine.Swarm, name string, next dockerswarm.ServiceSpec, c config.CanaryConfig, opt Options) error {
+	image := next.TaskTemplate.ContainerSpec.Image
+	serviceName := opt.Stack + "_" + name
+	canaryName := serviceName + Suffix
+
+	live, _, err := cli.ServiceInspectWithRaw(ctx, serviceName, types.ServiceInspectOptions{})
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			fmt.Fprintf(opt.Stdout, "🐤 %s: not deployed yet, skipping canary.\n", name)
+			return nil
+		}
+		return fmt.Errorf("inspect %s: %w", serviceName, err)
+	}
+
+	spec := live.Spec.TaskTemplate.ContainerSpec
+	if spec == nil {
+		return fmt.Errorf("canary %s: service has no container spec", name)
+	}
+	if normalizeImage(trimDigest(spec.Image)) == normalizeImage(image) {
+		fmt.Fprintf(opt.Stdout, "🐤 %s: image unchanged, skipping canary.\n", name)
+		return nil
+	}
+	if live.Spec.Mode.Replicated == nil || live.Spec.Mode.Replicated.Replicas == nil {
+		fmt.Fprintf(opt.Stderr, "⚠️  %s: canary rollouts need a replicated service, skipping.\n", name)
+		return nil
+	}
+	total := *live.Spec.Mode.Replicated.Replicas
+	if total < 2 {
+		fmt.Fprintf(opt.Stderr, "⚠️  %s: canary rollouts need at least 2 replicas, skipping.\n", name)
+		return nil
+	}
+
+	hold, err := parseDuration(c.Hold, 0)
+	if err != nil {
+		return err
+	}
+
+	// A canary left over from an interrupted run would skew the replica counts
+	if err := removeService(ctx, cli, canaryName); err != nil {
+		return err
+	}
+
+	canarySpec := newSpec(next, name)
+
+	abort := func(stepErr error) error {
+		// Use a fresh context: the deploy context may already be cancelled
+		cleanup := context.Background()
+		fmt.Fprintf(opt.Stderr, "❌ Canary for %s failed: %v\n", name, stepErr)
+		if err := removeService(cleanup, cli, canaryName); err != nil {
+			fmt.Fprintf(opt.Stderr, "⚠️  Failed to remove canary %s: %v\n", canaryName, err)
+		}
+		if err := scale(cleanup, cli, serviceName, total); err != nil {
+			fmt.Fprintf(opt.Stderr, "⚠️  Failed to restore %s to %d replicas: %v\n", serviceName, total, err)
+		} else {
+			fmt.Fprintf(opt.Stdout, "↩️  Restored %s to %d replicas of %s\n", name, total, trimDigest(spec.Image))
+		}
+		return fmt.Errorf("canary for %s aborted: %w", name, stepErr)
+	}
+
+	fmt.Fprintf(opt.Stdout, "🐤 Starting canary for %s: %s -> %s\n", name, trimDigest(spec.Image), image)
+
+	created := false
+	for _, step := range c.Steps {
+		n := canaryReplicas(total, step)
+		if n >= total {
+			break // the full deploy promotes the image to every replica
+		}
+		fmt.Fprintf(opt.Stdout, "🐤 %s: step %d%% (%d canary, %d stable)\n", name, step, n, total-n)
+
+		if !created {
+			canarySpec.Mode.Replicated.Replicas = &n
+			auth, err := build.RegistryAuth(image)
+			if err != nil {
+				return abort(fmt.Errorf("registry auth: %w", err))
+			}
+			if _, err := cli.ServiceCreate(ctx, canarySpec, types.ServiceCreateOptions{EncodedRegistryAuth: auth}); err != nil {
+				return abort(fmt.Errorf("create canary: %w", err))
+			}
+			created = true
+		} else if err := scale(ctx, cli, canaryName, n); err != nil {
+			return abort(err)
+		}
+		if err := scale(ctx, cli, serviceName, total-n); err != nil {
+			return abort(err)
+		}
+
+		started := time.Now()
+		if err := waitConverged(ctx, cli, opt.Stack, name+Suffix, opt.Timeout); err != nil {
+			return abort(err)
+		}
+		if err := watch(ctx, cli, canaryName, started, hold, c.Probe, opt); err != nil {
+			return abort(err)
+		}
+	}
+
+	// Promote: bring the stable service back to full size before the canary goes away
+	if err := scale(ctx, cli, serviceName, total); err != nil {
+		return abort(err)
+	}
+	if err := removeService(ctx, cli, canaryName); err != nil {
+		return fmt.Errorf("remove canary %s: %w", canaryName, err)
+	}
+	fmt.Fprintf(opt.Stdout, "✅ Canary for %s passed, promoting %s to %d replicas.\n", name, image, total)
+	return nil
+}
+
+// newSpec derives the canary spec from the converted spec of the new version:
+// everything but the name, the replica count and the published ports.
+func newSpec(next dockerswarm.ServiceSpec, name string) dockerswarm.ServiceSpec {
+	spec := next
+	spec.Name = next.Name + Suffix
+
+	spec.Labels = make(map[string]string, len(next.Labels)+1)
+	for k, v := range next.Labels {
+		spec.Labels[k] = v
+	}
+	spec.Labels[LabelCanary] = name
+
+	spec.Mode = dockerswarm.ServiceMode{Replicated: &dockerswarm.ReplicatedService{}}
+
+	// Published ports stay with the stable service; the canary is reached through
+	// the network alias it shares with it.
+	if next.EndpointSpec != nil {
+		endpoint := *next.EndpointSpec
+		endpoint.Ports = nil
+		spec.EndpointSpec = &endpoint
+	}
+
+	return spec
+}
+
+// watch holds a step, failing if a canary task fails or the probe does not pass.
+func watch(ctx context.Context, cli engine.Swarm, canaryName string, since time.Time, hold time.Duration, p *config.ProbeConfig, opt Options) error {
+	interval := DefaultProbeInterval
+	if p != nil {
+		var err error
+		if interval, err = parseDuration(p.Interval, DefaultProbeInterval); err != nil {
+			return err
+		}
+	}
+	if hold > 0 {
+		fmt.Fprintf(opt.Stdout, "   holding for %s...\n", hold)
+	}
+
+	deadline := time.Now().Add(hold)
+	for {
+		if err := checkTasks(ctx, cli, canaryName, since); err != nil {
+			return err
+		}
+		if p != nil {
+			check := probe.HTTP{URL: p.URL, ExpectStatus: p.ExpectStatus}
+			if err := check.Check(ctx); err != nil {
+				return fmt.Errorf("probe failed: %w", err)
+			}
+		}
+		if !time.Now().Before(deadline) {
+			return nil
+		}
+
+		wait := time.Until(deadline)
+		if wait > interval {
+			wait = interval
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// checkTasks returns an error if a task of the service failed since the given time.
+func checkTasks(ctx context.Context, cli engine.Swarm, serviceName string, since time.Time) error {
+	f := filters.NewArgs()
+	f.Add("service", serviceName)
+	tasks, err := cli.TaskList(ctx, types.TaskListOptions{Filters: f})
+	if err != nil {
+		return fmt.Errorf("list tasks: %w", err)
+	}
+	for _, t := range tasks {
+		if t.Status.Timestamp.Before(since) {
+			continue
+		}
+		switch t.Status.State {
+		case dockerswarm.TaskStateFailed, dockerswarm.TaskStateRejected:
+			msg := t.Status.Err
+			if msg == "" {
+				msg = t.Status.Message
+			}
+			return fmt.Errorf("canary task %s: %s", t.Status.State, msg)
+		}
+	}
+	return nil
+}
+
+func waitConverged(ctx context.Context, cli engine.Swarm, stackName, service string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		progress, err := rollout.Check(ctx, cli, stackName)
+		if err != nil {
+			return err
+		}
+		for _, p := range progress {
+			if p.Name != service {
+				continue
+			}
+			if p.Failed {
+				return fmt.Errorf("%s failed: %s", service, p.Message)
+			}
+			if p.Converged {
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("%s did not converge within %s: %s", service, timeout, p)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// --- Helpers ---
+
+func scale(ctx context.Context, cli engine.Swarm, serviceName string, replicas uint64) error {
+	svc, _, err := cli.ServiceInspectWithRaw(ctx, serviceName, types.ServiceInspectOptions{})
+	if err != nil {
+		return fmt.Errorf("inspect %s: %w", serviceName, err)
+	}
+	spec := svc.Spec
+	spec.Mode.Replicated = &dockerswarm.ReplicatedService{Replicas: &replicas}
+	if _, err := cli.ServiceUpdate(ctx, svc.ID, svc.Version, spec, types.ServiceUpdateOptions{}); err != nil {
+		return fmt.Errorf("scale %s to %d: %w", serviceName, replicas, err)
+	}
+	return nil
+}
+
+func removeService(ctx context.Context, cli engine.Swarm, serviceName string) error {
+	if err := cli.ServiceRemove(ctx, serviceName); err != nil && !client.IsErrNotFound(err) {
+		return fmt.Errorf("remove %s: %w", serviceName, err)
+	}
+	return nil
+}
+
+// canaryReplicas converts a percentage into a replica count of at least one.
+func canaryReplicas(total uint64, percent int) uint64 {
+	n := uint64(math.Ceil(float64(total) * float64(percent) / 100))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+func parseDuration(s string, fallback time.Duration) (time.Duration, error) {
+	if s == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration '%s': %w", s, err)
+	}
+	return d, nil
+}
+
+func trimDigest(image string) string {
+	if idx := strings.Index(image, "@sha256"); idx != -1 {
+		return image[:idx]
+	}
+	return image
+}
+
+// normalizeImage adds the implicit ':latest' tag so 'app' and 'app:latest' compare equal.
+func normalizeImage(image string) string {
+	last := image[strings.LastIndex(image, "/")+1:]
+	if image != "" && !strings.Contains(last, ":") {
+		return image + ":latest"
+	}
+	return image
+}
@@ -0,0 +1,238 @@
+package applycmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rollwave-dev/rollwave/internal/bluegreen"
+	"github.com/rollwave-dev/rollwave/internal/build"
+	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/deployment"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/hooks"
+	"github.com/rollwave-dev/rollwave/internal/lock"
+	"github.com/rollwave-dev/rollwave/internal/output"
+	"github.com/rollwave-dev/rollwave/internal/plan"
+	"github.com/rollwave-dev/rollwave/internal/rollout"
+	"github.com/rollwave-dev/rollwave/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+func New() *cobra.Command {
+	var (
+		flagConfigPath   string
+		flagTimeout      time.Duration
+		flagDetach       bool
+		flagAutoRollback bool
+		flagSkipVerify   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "apply <plan-file>",
+		Short: "Execute a plan saved with 'rollwave plan --out'",
+		Long: `Executes a saved plan verbatim: builds the planned images with the planned
+tags, creates the planned secret versions and deploys the rendered compose file
+exactly like 'deploy' would: with its hooks, migrations, deploy strategy,
+verification and notifications.
+
+Apply fails if the stack or rollwave.yml changed since the plan was made, or if
+the secret values no longer match the planned secret versions.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			p, err := plan.Read(args[0])
+			if err != nil {
+				return err
+			}
+
+			// 1. The configuration must be the one the plan was made with
+			cfgPath := flagConfigPath
+			if cfgPath == "" {
+				cfgPath = p.ConfigPath
+			}
+			baseCfg, err := config.Load(cfgPath)
+			if err != nil {
+				return fmt.Errorf("load config: %w", err)
+			}
+			cfg, err := baseCfg.MergeWithEnv(p.Environment)
+			if err != nil {
+				return err
+			}
+			configHash, err := plan.ConfigHash(cfg)
+			if err != nil {
+				return err
+			}
+			if configHash != p.ConfigHash {
+				return fmt.Errorf("%s changed since the plan was created at %s; run 'rollwave plan' again",
+					cfgPath, p.CreatedAt.Local().Format(time.DateTime))
+			}
+			if err := deployment.Validate(cfg); err != nil {
+				return err
+			}
+
+			if p.Environment != "" {
+				fmt.Fprintf(cmd.OutOrStdout(), "🌍 Using environment: %s\n", p.Environment)
+			}
+
+			timeout := rollout.DefaultTimeout
+			if p.Timeout != "" {
+				timeout, err = time.ParseDuration(p.Timeout)
+				if err != nil {
+					return fmt.Errorf("invalid timeout in plan: %w", err)
+				}
+			}
+			if cmd.Flags().Changed("timeout") {
+				timeout = flagTimeout
+			}
+
+			autoRollback := p.AutoRollback
+			if cmd.Flags().Changed("auto-rollback") {
+				autoRollback = flagAutoRollback
+			}
+
+			d := deployment.Begin(deployment.Options{
+				Config:       cfg,
+				Environment:  p.Environment,
+				Detach:       flagDetach,
+				AutoRollback: autoRollback,
+				SkipVerify:   flagSkipVerify,
+				Timeout:      timeout,
+				Output:       output.From(cmd),
+				Stdout:       cmd.OutOrStdout(),
+				Stderr:       cmd.ErrOrStderr(),
+			})
+			defer func() {
+				d.End(err)
+			}()
+
+			cli, err := engine.NewFor(cfg, autoRollback)
+			if err != nil {
+				return err
+			}
+			defer cli.Close()
+
+			l, err := lock.Acquire(cmd.Context(), cli, p.Stack, "apply", cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+			defer l.Release()
+			cmd.SetContext(l.Context())
+
+			// 2. Refuse stale plans
+			liveStack, err := bluegreen.LiveStack(cmd.Context(), cli, cfg)
+			if err != nil {
+				return err
+			}
+			fingerprint, err := plan.Fingerprint(cmd.Context(), cli, liveStack)
+			if err != nil {
+				return err
+			}
+			if fingerprint != p.Fingerprint {
+				return fmt.Errorf("stack '%s' changed since the plan was created at %s; run 'rollwave plan' again",
+					p.Stack, p.CreatedAt.Local().Format(time.DateTime))
+			}
+
+			// 3. Secrets must hash to the planned versions
+			secretsCfg := config.SecretsConfig{
+				File:    p.SecretFile,
+				Dirs:    p.SecretDirs,
+				Sources: p.SecretSources,
+			}
+			if p.SecretVault != nil {
+				secretsCfg.Vault = *p.SecretVault
+			}
+			providers, err := secrets.Providers(secretsCfg, p.Environment)
+			if err != nil {
+				return err
+			}
+			if len(p.Secrets) > 0 {
+				opt := secrets.SyncOptions{
+					Stack:     p.Stack,
+					Prefix:    p.SecretPrefix,
+					DryRun:    true,
+					Stdout:    io.Discard,
+					Providers: providers,
+				}
+				current, err := secrets.EnsureSecrets(cmd.Context(), cli, opt)
+				if err != nil {
+					return err
+				}
+				for key, name := range p.Secrets {
+					if current[key] != name {
+						return fmt.Errorf("secret %s changed since the plan was created (planned %s)", key, name)
+					}
+				}
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "📋 Applying plan for stack '%s':\n", p.Stack)
+			p.Print(cmd.OutOrStdout())
+			fmt.Fprintln(cmd.OutOrStdout(), "")
+
+			// 4. Build and push the planned images
+			if len(p.Builds) > 0 {
+				d.Start(deployment.StageBuild)
+				if err := d.Hooks.Run(cmd.Context(), hooks.PreBuild); err != nil {
+					return err
+				}
+			}
+			images := make(map[string]string)
+			for i, b := range p.Builds {
+				if i == 0 {
+					if err := build.Login(cmd.Context(), cli, b.ImageName, cmd.OutOrStdout(), cmd.ErrOrStderr()); err != nil {
+						return fmt.Errorf("registry login: %w", err)
+					}
+				}
+				builtTag, err := build.Run(cmd.Context(), cli, build.Options{
+					ImageName:  b.ImageName,
+					ContextDir: b.Context,
+					Dockerfile: b.Dockerfile,
+					Tag:        b.Tag,
+					Stdout:     cmd.OutOrStdout(),
+					Stderr:     cmd.ErrOrStderr(),
+				})
+				if err != nil {
+					return fmt.Errorf("build service %s: %w", b.Service, err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "✅ Service '%s' built & pushed: %s\n", b.Service, builtTag)
+				d.Emit(deployment.EventImageBuilt, deployment.EventData{Service: b.Service, Image: builtTag})
+				images[b.Service] = builtTag
+			}
+			if len(p.Builds) > 0 {
+				d.Hooks.SetImages(images)
+				if err := d.Hooks.Run(cmd.Context(), hooks.PostBuild); err != nil {
+					return err
+				}
+				d.Finish()
+			}
+
+			// 5. Create the planned secret versions
+			if len(p.Secrets) > 0 {
+				d.Start(deployment.StageSecrets)
+				fmt.Fprintln(cmd.OutOrStdout(), "🔒 Ensuring secrets...")
+				if _, err := secrets.EnsureSecrets(cmd.Context(), cli, secrets.SyncOptions{
+					Stack:     p.Stack,
+					Prefix:    p.SecretPrefix,
+					Stdout:    cmd.OutOrStdout(),
+					Providers: providers,
+					OnCreate: func(key, name string) {
+						d.Emit(deployment.EventSecretCreated, deployment.EventData{Secret: key, Name: name})
+					},
+				}); err != nil {
+					return err
+				}
+				d.Finish()
+			}
+
+			// 6. Deploy the planned compose file
+			return d.Rollout(cmd.Context(), cli, []byte(p.Compose), p.Secrets)
+		},
+	}
+
+	cmd.Flags().StringVarP(&flagConfigPath, "config", "c", "", "Path to rollwave.yml (default: the one the plan was made with)")
+	cmd.Flags().DurationVar(&flagTimeout, "timeout", rollout.DefaultTimeout, "How long to wait for services to converge")
+	cmd.Flags().BoolVarP(&flagDetach, "detach", "d", false, "Do not wait for services to converge")
+	cmd.Flags().BoolVar(&flagAutoRollback, "auto-rollback", false, "Restore the previous service specs if the deploy fails")
+	cmd.Flags().BoolVar(&flagSkipVerify, "skip-verify", false, "Do not run the verify checks after deploying")
+
+	return cmd
+}
@@ -0,0 +1,130 @@
+package buildcmd
+
+import (
+	"fmt"
+
+	"github.com/rollwave-dev/rollwave/internal/build"
+	"github.com/rollwave-dev/rollwave/internal/compose"
+	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/hooks"
+	"github.com/spf13/cobra"
+)
+
+func New() *cobra.Command {
+	var (
+		flagConfigPath string
+		flagEnv        string
+		flagServices   []string
+		flagDryRun     bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "build",
+		Short: "Build and push the images of services with a 'build' section",
+		Long: `Builds and pushes images like 'deploy --build' does, without deploying.
+
+Example:
+  rollwave build --env production --service web,worker`,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			// 1. Load Config
+			cfgPath := flagConfigPath
+			if cfgPath == "" {
+				cfgPath = "rollwave.yml"
+			}
+			baseCfg, err := config.Load(cfgPath)
+			if err != nil {
+				return err
+			}
+
+			// 2. Apply Environment Overrides
+			cfg, err := baseCfg.MergeWithEnv(flagEnv)
+			if err != nil {
+				return err
+			}
+
+			if flagEnv != "" {
+				fmt.Fprintf(cmd.OutOrStdout(), "🌍 Using environment: %s\n", flagEnv)
+			}
+
+			runner := hooks.NewRunner(cfg, flagEnv, flagDryRun, cmd.OutOrStdout(), cmd.ErrOrStderr())
+			defer func() {
+				if err != nil {
+					runner.Fail(err)
+				}
+			}()
+
+			// 3. Read, merge and interpolate the compose files
+			composeYaml, err := compose.Load(cfg.Stack.ComposeFiles())
+			if err != nil {
+				return err
+			}
+			composeYaml, err = compose.InterpolateYAML(composeYaml, compose.LookupVariables(cfg.Variables))
+			if err != nil {
+				return err
+			}
+			if err := compose.CheckServices(composeYaml, flagServices); err != nil {
+				return err
+			}
+
+			buildConfigs, err := compose.ExtractBuildConfigs(composeYaml)
+			if err != nil {
+				return err
+			}
+			buildConfigs = compose.SelectBuildConfigs(buildConfigs, flagServices)
+			if len(buildConfigs) == 0 {
+				fmt.Fprintln(cmd.OutOrStdout(), "⚠️  No services to build: none of them has a 'build' section.")
+				return nil
+			}
+
+			// 4. Login, build and push
+			var cli engine.Engine
+			if flagDryRun {
+				fmt.Fprintf(cmd.OutOrStdout(), "[dry-run] registry login for %s\n", buildConfigs[0].ImageName)
+			} else {
+				cli, err = engine.New()
+				if err != nil {
+					return err
+				}
+				defer cli.Close()
+
+				if err := build.Login(cmd.Context(), cli, buildConfigs[0].ImageName, cmd.OutOrStdout(), cmd.ErrOrStderr()); err != nil {
+					return fmt.Errorf("registry login: %w", err)
+				}
+			}
+
+			if err := runner.Run(cmd.Context(), hooks.PreBuild); err != nil {
+				return err
+			}
+
+			images := make(map[string]string)
+			for _, bConf := range buildConfigs {
+				builtTag, err := build.Run(cmd.Context(), cli, build.Options{
+					ImageName:  bConf.ImageName,
+					ContextDir: bConf.Context,
+					Dockerfile: bConf.Dockerfile,
+					DryRun:     flagDryRun,
+					Stdout:     cmd.OutOrStdout(),
+					Stderr:     cmd.ErrOrStderr(),
+				})
+				if err != nil {
+					return fmt.Errorf("build service %s: %w", bConf.ServiceName, err)
+				}
+				if !flagDryRun {
+					fmt.Fprintf(cmd.OutOrStdout(), "✅ Service '%s' built & pushed: %s\n", bConf.ServiceName, builtTag)
+				}
+				images[bConf.ServiceName] = builtTag
+			}
+
+			runner.SetImages(images)
+			return runner.Run(cmd.Context(), hooks.PostBuild)
+		},
+	}
+
+	cmd.Flags().StringVarP(&flagConfigPath, "config", "c", "", "Path to rollwave.yml")
+	cmd.Flags().StringVarP(&flagEnv, "env", "e", "", "Environment to build for (e.g. staging, production)")
+	cmd.Flags().StringSliceVar(&flagServices, "service", nil, "Only build these services (e.g. web,worker)")
+	cmd.Flags().BoolVar(&flagDryRun, "dry-run", false, "Show what would be built and pushed without doing it")
+
+	return cmd
+}
@@ -1,31 +1,44 @@
 package deploycmd
 
 import (
-	"context"
 	"fmt"
-	"os"
-	"os/exec"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/rollwave-dev/rollwave/internal/bluegreen"
 	"github.com/rollwave-dev/rollwave/internal/build"
 	"github.com/rollwave-dev/rollwave/internal/compose"
 	"github.com/rollwave-dev/rollwave/internal/config"
-	"github.com/rollwave-dev/rollwave/internal/prune"
+	"github.com/rollwave-dev/rollwave/internal/deployment"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/hooks"
+	"github.com/rollwave-dev/rollwave/internal/lock"
+	"github.com/rollwave-dev/rollwave/internal/output"
+	"github.com/rollwave-dev/rollwave/internal/rollout"
 	"github.com/rollwave-dev/rollwave/internal/secrets"
+	"github.com/rollwave-dev/rollwave/internal/swarm"
 	"github.com/spf13/cobra"
 )
 
 func New() *cobra.Command {
 	var (
-		flagConfigPath  string
-		flagWithSecrets bool
-		flagBuild       bool
-		flagEnv         string
+		flagConfigPath   string
+		flagWithSecrets  bool
+		flagBuild        bool
+		flagEnv          string
+		flagTimeout      time.Duration
+		flagDetach       bool
+		flagAutoRollback bool
+		flagDryRun       bool
+		flagSkipVerify   bool
+		flagServices     []string
 	)
 
 	cmd := &cobra.Command{
 		Use:   "deploy",
 		Short: "Build from Compose and deploy",
-		RunE: func(cmd *cobra.Command, args []string) error {
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
 			// 1. Load Base Config
 			cfgPath := flagConfigPath
 			if cfgPath == "" {
@@ -42,18 +55,88 @@ func New() *cobra.Command {
 				return err
 			}
 
+			if err := deployment.Validate(cfg); err != nil {
+				return err
+			}
+
 			if flagEnv != "" {
 				fmt.Fprintf(cmd.OutOrStdout(), "🌍 Using environment: %s\n", flagEnv)
 			}
 
-			// 3. Read Compose File
-			composeFile := cfg.Stack.ComposeFile
-			if composeFile == "" {
-				composeFile = "docker-compose.yml"
+			// A new colour is deployed from the whole compose file, there is no
+			// running spec to keep the other services on
+			if len(flagServices) > 0 && cfg.Deploy.Strategy == config.StrategyBlueGreen {
+				return fmt.Errorf("--service cannot be used with blue/green deploys: the new colour needs every service")
+			}
+
+			autoRollback := flagAutoRollback
+			if !cmd.Flags().Changed("auto-rollback") && cfg.Deploy.AutoRollback {
+				autoRollback = true
+			}
+			timeout, err := cfg.Deploy.WaitTimeout()
+			if err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("timeout") {
+				timeout = flagTimeout
+			}
+
+			// Hooks, notifications and the --output event stream; the outcome is
+			// reported for any error from here on
+			d := deployment.Begin(deployment.Options{
+				Config:       cfg,
+				Environment:  flagEnv,
+				Services:     flagServices,
+				DryRun:       flagDryRun,
+				Detach:       flagDetach,
+				AutoRollback: autoRollback,
+				SkipVerify:   flagSkipVerify,
+				Timeout:      timeout,
+				Output:       output.From(cmd),
+				Stdout:       cmd.OutOrStdout(),
+				Stderr:       cmd.ErrOrStderr(),
+			})
+			defer func() {
+				d.End(err)
+			}()
+
+			// Hold the stack's lock for the whole deploy so concurrent runs cannot
+			// interleave secret creation and stack updates
+			var cli engine.Engine
+			if !flagDryRun {
+				cli, err = engine.NewFor(cfg, autoRollback)
+				if err != nil {
+					return err
+				}
+				defer cli.Close()
+
+				l, err := lock.Acquire(cmd.Context(), cli, cfg.Stack.Name, "deploy", cmd.ErrOrStderr())
+				if err != nil {
+					return err
+				}
+				defer l.Release()
+				cmd.SetContext(l.Context())
+			}
+
+			// 3. Read (and merge) the compose files
+			composeFiles := cfg.Stack.ComposeFiles()
+			if len(composeFiles) > 1 {
+				fmt.Fprintf(cmd.OutOrStdout(), "📄 Merging compose files: %s\n", strings.Join(composeFiles, ", "))
+			}
+			originalYaml, err := compose.Load(composeFiles)
+			if err != nil {
+				return err
 			}
-			originalYaml, err := os.ReadFile(composeFile)
+			// Variables are substituted here, so everything below sees the final values
+			originalYaml, err = compose.InterpolateYAML(originalYaml, compose.LookupVariables(cfg.Variables))
 			if err != nil {
-				return fmt.Errorf("read compose file '%s': %w", composeFile, err)
+				return err
+			}
+			if len(flagServices) > 0 {
+				if err := compose.CheckServices(originalYaml, flagServices); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "🎯 Deploying only: %s\n", strings.Join(flagServices, ", "))
 			}
 
 			currentYaml := originalYaml
@@ -66,12 +149,15 @@ func New() *cobra.Command {
 			if err != nil {
 				return err
 			}
+			buildConfigs = compose.SelectBuildConfigs(buildConfigs, flagServices)
 
 			// If services with images are defined, attempt to login
 			// (So Swarm can pull the image even if build is skipped)
-			if len(buildConfigs) > 0 {
+			if len(buildConfigs) > 0 && flagDryRun {
+				fmt.Fprintf(cmd.OutOrStdout(), "[dry-run] registry login for %s\n", buildConfigs[0].ImageName)
+			} else if len(buildConfigs) > 0 {
 				firstImage := buildConfigs[0].ImageName
-				if err := build.Login(cmd.Context(), firstImage, cmd.OutOrStdout(), cmd.ErrOrStderr()); err != nil {
+				if err := build.Login(cmd.Context(), cli, firstImage, cmd.OutOrStdout(), cmd.ErrOrStderr()); err != nil {
 					return fmt.Errorf("registry login: %w", err)
 				}
 			}
@@ -80,6 +166,11 @@ func New() *cobra.Command {
 			// STEP A: BUILD (Based on Compose)
 			// ---------------------------------------------------------
 			if flagBuild {
+				d.Start(deployment.StageBuild)
+				if err := d.Hooks.Run(cmd.Context(), hooks.PreBuild); err != nil {
+					return err
+				}
+
 				fmt.Fprintln(cmd.OutOrStdout(), "🏗️  Analyzing Compose file for builds...")
 
 				if len(buildConfigs) == 0 {
@@ -90,10 +181,11 @@ func New() *cobra.Command {
 
 				// Build and push each service
 				for _, bConf := range buildConfigs {
-					builtTag, err := build.Run(cmd.Context(), build.Options{
+					builtTag, err := build.Run(cmd.Context(), cli, build.Options{
 						ImageName:  bConf.ImageName,
 						ContextDir: bConf.Context,
 						Dockerfile: bConf.Dockerfile,
+						DryRun:     flagDryRun,
 						Stdout:     cmd.OutOrStdout(),
 						Stderr:     cmd.ErrOrStderr(),
 					})
@@ -101,7 +193,10 @@ func New() *cobra.Command {
 						return fmt.Errorf("build service %s: %w", bConf.ServiceName, err)
 					}
 
-					fmt.Printf("✅ Service '%s' built & pushed: %s\n", bConf.ServiceName, builtTag)
+					if !flagDryRun {
+						fmt.Fprintf(cmd.OutOrStdout(), "✅ Service '%s' built & pushed: %s\n", bConf.ServiceName, builtTag)
+						d.Emit(deployment.EventImageBuilt, deployment.EventData{Service: bConf.ServiceName, Image: builtTag})
+					}
 					imageReplacements[bConf.ServiceName] = builtTag
 				}
 
@@ -110,6 +205,22 @@ func New() *cobra.Command {
 				if err != nil {
 					return fmt.Errorf("replace images: %w", err)
 				}
+
+				d.Hooks.SetImages(imageReplacements)
+				if err := d.Hooks.Run(cmd.Context(), hooks.PostBuild); err != nil {
+					return err
+				}
+				d.Finish()
+			}
+
+			// Only the selected services are updated. The others are pinned to the
+			// images they are running in the compose file recorded for the release,
+			// so rolling back to it does not change them either.
+			if len(flagServices) > 0 {
+				currentYaml, err = keepRunningImages(cmd, cli, cfg, currentYaml, flagServices)
+				if err != nil {
+					return err
+				}
 			}
 
 			// ---------------------------------------------------------
@@ -121,73 +232,48 @@ func New() *cobra.Command {
 				withSecrets = true
 			}
 
+			var secretMap secrets.SecretMap
 			if withSecrets {
+				d.Start(deployment.StageSecrets)
 				fmt.Fprintln(cmd.OutOrStdout(), "🔒 Ensuring secrets...")
-				secretMap, err := secrets.EnsureSecrets(context.Background(), secrets.SyncOptions{
-					Stack:  cfg.Stack.Name,
-					Prefix: cfg.Secrets.StackPrefix,
-					Stdout: cmd.OutOrStdout(),
+				providers, err := secrets.Providers(cfg.Secrets, flagEnv)
+				if err != nil {
+					return err
+				}
+				secretMap, err = secrets.EnsureSecrets(cmd.Context(), cli, secrets.SyncOptions{
+					Stack:     cfg.Stack.Name,
+					Prefix:    cfg.Secrets.StackPrefix,
+					DryRun:    flagDryRun,
+					Stdout:    cmd.OutOrStdout(),
+					Providers: providers,
+					OnCreate: func(key, name string) {
+						d.Emit(deployment.EventSecretCreated, deployment.EventData{Secret: key, Name: name})
+					},
 				})
 				if err != nil {
 					return err
 				}
 
 				if len(secretMap) == 0 {
-					fmt.Fprintln(cmd.OutOrStdout(), "⚠️  WARNING: No secrets found beginning with ROLLWAVE_SECRET_")
+					fmt.Fprintln(cmd.OutOrStdout(), "⚠️  WARNING: No secrets found (ROLLWAVE_SECRET_* or secrets sources in rollwave.yml)")
 				}
 
 				currentYaml, err = compose.RewriteSecrets(currentYaml, secretMap)
 				if err != nil {
 					return err
 				}
+				d.Finish()
 			}
 
 			// ---------------------------------------------------------
 			// STEP C: DEPLOY
 			// ---------------------------------------------------------
-			tempPath := "docker-compose.rollwave.generated.yml"
-			if err := os.WriteFile(tempPath, currentYaml, 0644); err != nil {
+			if err := d.Rollout(cmd.Context(), cli, currentYaml, secretMap); err != nil {
 				return err
 			}
-			defer os.Remove(tempPath)
-
-			deployArgs := []string{
-				"stack", "deploy",
-				"--compose-file", tempPath,
-				"--with-registry-auth",
-				"--prune",
-				cfg.Stack.Name,
-			}
-
-			fmt.Fprintf(cmd.OutOrStdout(), "🚀 Deploying stack '%s'...\n", cfg.Stack.Name)
-
-			c := exec.CommandContext(cmd.Context(), "docker", deployArgs...)
-			c.Stdout = cmd.OutOrStdout()
-			c.Stderr = cmd.ErrOrStderr()
-
-			// --- Inject variables into process environment ---
-			// Docker CLI uses environment variables to substitute ${VAR} in compose files.
-			c.Env = os.Environ() // Start with current environment (PATH, DOCKER_HOST, etc.)
-			for k, v := range cfg.Variables {
-				c.Env = append(c.Env, fmt.Sprintf("%s=%s", k, v))
-				fmt.Fprintf(cmd.OutOrStdout(), "   Exporting var: %s=%s\n", k, v)
-			}
-
-			if err := c.Run(); err != nil {
-				return fmt.Errorf("deploy failed: %w", err)
-			}
-
-			fmt.Fprintln(cmd.OutOrStdout(), "✅ Deployment successful.")
-
-			// --- AUTO PRUNE ---
-			if cfg.Deploy.Prune {
-				fmt.Fprintln(cmd.OutOrStdout(), "") // New line for separation
-				if err := prune.Run(cmd.Context(), cfg.Stack.Name, cmd.OutOrStdout(), cmd.ErrOrStderr()); err != nil {
-					// We don't fail the deployment if prune fails, just warn
-					fmt.Fprintf(cmd.ErrOrStderr(), "⚠️  Auto-prune failed: %v\n", err)
-				}
+			if flagDryRun {
+				fmt.Fprintln(cmd.OutOrStdout(), "✅ Dry run complete. Nothing was built, pushed, created or deployed.")
 			}
-
 			return nil
 		},
 	}
@@ -196,6 +282,47 @@ func New() *cobra.Command {
 	cmd.Flags().BoolVar(&flagWithSecrets, "with-secrets", false, "Enable secret rotation")
 	cmd.Flags().BoolVar(&flagBuild, "build", false, "Build services defined in docker-compose.yml")
 	cmd.Flags().StringVarP(&flagEnv, "env", "e", "", "Environment to deploy to (e.g. staging, production)")
+	cmd.Flags().DurationVar(&flagTimeout, "timeout", rollout.DefaultTimeout, "How long to wait for services to converge")
+	cmd.Flags().BoolVarP(&flagDetach, "detach", "d", false, "Do not wait for services to converge")
+	cmd.Flags().BoolVar(&flagAutoRollback, "auto-rollback", false, "Restore the previous service specs if the deploy fails")
+	cmd.Flags().BoolVar(&flagDryRun, "dry-run", false, "Show what would be built, pushed, created and deployed without doing it")
+	cmd.Flags().BoolVar(&flagSkipVerify, "skip-verify", false, "Do not run the verify checks after deploying")
+	cmd.Flags().StringSliceVar(&flagServices, "service", nil, "Only build and update these services (e.g. web,worker); the others keep their running images")
 
 	return cmd
 }
+
+// keepRunningImages pins the services that are not in selected to the images
+// they are running, so the release records what a partial deploy leaves running.
+func keepRunningImages(cmd *cobra.Command, cli engine.Swarm, cfg *config.Config, composeYaml []byte, selected []string) ([]byte, error) {
+	if cli == nil {
+		fmt.Fprintf(cmd.OutOrStdout(), "[dry-run] services other than %s keep their running images\n", strings.Join(selected, ", "))
+		return composeYaml, nil
+	}
+
+	liveStack, err := bluegreen.LiveStack(cmd.Context(), cli, cfg)
+	if err != nil {
+		return nil, err
+	}
+	running, err := swarm.ServiceImages(cmd.Context(), cli, liveStack)
+	if err != nil {
+		return nil, err
+	}
+	declared, err := compose.ServiceImages(composeYaml)
+	if err != nil {
+		return nil, err
+	}
+
+	pinned := make(map[string]string)
+	for name := range declared {
+		if slices.Contains(selected, name) {
+			continue
+		}
+		if image, ok := running[name]; ok {
+			pinned[name] = image
+		} else {
+			fmt.Fprintf(cmd.ErrOrStderr(), "⚠️  Service '%s' is not running yet and is not deployed; add it to --service to create it.\n", name)
+		}
+	}
+	return compose.ReplaceImages(composeYaml, pinned)
+}
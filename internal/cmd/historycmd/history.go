@@ -0,0 +1,195 @@
+package historycmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/output"
+	"github.com/rollwave-dev/rollwave/internal/release"
+	"github.com/spf13/cobra"
+)
+
+func New() *cobra.Command {
+	var (
+		flagConfigPath string
+		flagEnv        string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "history [release]",
+		Short: "Show the release history of the stack",
+		Long: `Lists the releases recorded in the Swarm for the stack, or shows the
+details of a single release.
+
+Example:
+  rollwave history --env production
+  rollwave history --env production 12`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// 1. Load Config
+			cfgPath := flagConfigPath
+			if cfgPath == "" {
+				cfgPath = "rollwave.yml"
+			}
+			baseCfg, err := config.Load(cfgPath)
+			if err != nil {
+				return fmt.Errorf("load config: %w", err)
+			}
+
+			// 2. Apply Environment Overrides
+			cfg, err := baseCfg.MergeWithEnv(flagEnv)
+			if err != nil {
+				return err
+			}
+
+			stackName := cfg.Stack.Name
+			if stackName == "" {
+				return fmt.Errorf("stack name is missing in configuration")
+			}
+
+			cli, err := engine.New()
+			if err != nil {
+				return err
+			}
+			defer cli.Close()
+
+			records, err := release.List(cmd.Context(), cli, stackName)
+			if err != nil {
+				return err
+			}
+			out := output.From(cmd)
+
+			if len(args) == 1 {
+				id, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+				if err != nil {
+					return fmt.Errorf("invalid release '%s'", args[0])
+				}
+				for _, r := range records {
+					if r.ID == id {
+						if out.Structured() {
+							return out.Print(r)
+						}
+						printDetails(cmd.OutOrStdout(), r)
+						return nil
+					}
+				}
+				return fmt.Errorf("release #%d not found for stack '%s'", id, stackName)
+			}
+
+			if out.Structured() {
+				if records == nil {
+					records = []release.Release{}
+				}
+				return out.Print(records)
+			}
+			if len(records) == 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "⚠️  No releases recorded for stack '%s'.\n", stackName)
+				return nil
+			}
+
+			printList(cmd.OutOrStdout(), records)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&flagConfigPath, "config", "c", "", "Path to rollwave.yml")
+	cmd.Flags().StringVarP(&flagEnv, "env", "e", "", "Environment to inspect (e.g. staging)")
+
+	return cmd
+}
+
+func printList(out io.Writer, records []release.Release) {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "RELEASE\tDEPLOYED\tBY\tGIT\tSTATUS")
+
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+
+		status := r.Status
+		if r.RollbackOf != 0 {
+			status = fmt.Sprintf("%s (rollback to #%d)", status, r.RollbackOf)
+		}
+
+		fmt.Fprintf(w, "#%d\t%s\t%s\t%s\t%s\n",
+			r.ID, r.DeployedAt.Local().Format(time.DateTime), orDash(r.DeployedBy), orDash(shortSHA(r.GitSHA)), orDash(status))
+	}
+
+	w.Flush()
+}
+
+func printDetails(out io.Writer, r release.Release) {
+	fmt.Fprintf(out, "Release:      #%d\n", r.ID)
+	fmt.Fprintf(out, "Stack:        %s\n", r.Stack)
+	fmt.Fprintf(out, "Environment:  %s\n", orDash(r.Environment))
+	fmt.Fprintf(out, "Status:       %s\n", orDash(r.Status))
+	if r.RollbackOf != 0 {
+		fmt.Fprintf(out, "Rollback of:  #%d\n", r.RollbackOf)
+	}
+	if r.Error != "" {
+		fmt.Fprintf(out, "Error:        %s\n", r.Error)
+	}
+	fmt.Fprintf(out, "Deployed at:  %s\n", r.DeployedAt.Local().Format(time.RFC3339))
+	fmt.Fprintf(out, "Deployed by:  %s\n", orDash(r.DeployedBy))
+	fmt.Fprintf(out, "Git SHA:      %s\n", orDash(r.GitSHA))
+	fmt.Fprintf(out, "Compose hash: %s\n", orDash(r.ComposeHash))
+	fmt.Fprintf(out, "Vars hash:    %s\n", orDash(r.VariablesHash))
+
+	printMap(out, "Images", r.Images)
+	printMap(out, "Secrets", r.Secrets)
+	printMap(out, "Variables (hashed)", shortHashes(r.VariableHashes))
+
+	if len(r.Changes) > 0 {
+		fmt.Fprintf(out, "\nServices:\n")
+		for _, c := range r.Changes {
+			fmt.Fprintf(out, "   %s: %s\n", c.Service, c.Action)
+		}
+	}
+}
+
+func printMap(out io.Writer, title string, m map[string]string) {
+	if len(m) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(out, "\n%s:\n", title)
+	for _, k := range keys {
+		fmt.Fprintf(out, "   %s = %s\n", k, m[k])
+	}
+}
+
+func shortHashes(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		if len(v) > 12 {
+			v = v[:12]
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -33,6 +33,8 @@ secrets:
 deploy:
   # If true, ROLLWAVE_SECRET_* vars are synced to Swarm before deploy
   with_secrets: true
+  # Restore the previous service specs if the new version fails to start
+  auto_rollback: true
 `
 
 			if _, err := os.Stat(path); err == nil {
@@ -0,0 +1,142 @@
+package lockcmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/lock"
+	"github.com/rollwave-dev/rollwave/internal/release"
+	"github.com/spf13/cobra"
+)
+
+func New() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lock",
+		Short: "Inspect or release the deploy lock of the stack",
+		Long: `Deploys, rollbacks, secret syncs and prunes hold a lock in the Swarm so that
+two of them cannot run against the same stack at the same time.`,
+	}
+
+	cmd.AddCommand(newStatusCmd())
+	cmd.AddCommand(newReleaseCmd())
+
+	return cmd
+}
+
+func newStatusCmd() *cobra.Command {
+	var (
+		flagConfigPath string
+		flagEnv        string
+	)
+
+	c := &cobra.Command{
+		Use:   "status",
+		Short: "Show who holds the lock",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			stackName, err := loadStack(flagConfigPath, flagEnv)
+			if err != nil {
+				return err
+			}
+
+			cli, err := engine.New()
+			if err != nil {
+				return err
+			}
+			defer cli.Close()
+
+			info, err := lock.Get(cmd.Context(), cli, stackName)
+			if err != nil {
+				return err
+			}
+			if info == nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "🔓 Stack '%s' is not locked.\n", stackName)
+				return nil
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "🔒 Stack '%s' is locked by %s\n", stackName, info)
+			if info.Stale() {
+				fmt.Fprintf(cmd.OutOrStdout(), "   The lock is stale (no heartbeat for %s) and will be taken over by the next operation.\n", lock.StaleAfter)
+			}
+			return nil
+		},
+	}
+
+	c.Flags().StringVarP(&flagConfigPath, "config", "c", "", "Path to rollwave.yml")
+	c.Flags().StringVarP(&flagEnv, "env", "e", "", "Environment to inspect (e.g. staging)")
+	return c
+}
+
+func newReleaseCmd() *cobra.Command {
+	var (
+		flagConfigPath string
+		flagEnv        string
+		flagForce      bool
+	)
+
+	c := &cobra.Command{
+		Use:   "release",
+		Short: "Remove the lock",
+		Long: `Removes the lock of the stack. Without --force, only a lock held by you on
+this host is removed.
+
+Example:
+  rollwave lock release --env production --force`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			stackName, err := loadStack(flagConfigPath, flagEnv)
+			if err != nil {
+				return err
+			}
+
+			cli, err := engine.New()
+			if err != nil {
+				return err
+			}
+			defer cli.Close()
+
+			info, err := lock.Get(cmd.Context(), cli, stackName)
+			if err != nil {
+				return err
+			}
+			if info == nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "🔓 Stack '%s' is not locked.\n", stackName)
+				return nil
+			}
+
+			host, _ := os.Hostname()
+			if !flagForce && (info.Owner != release.Deployer() || info.Host != host) {
+				return fmt.Errorf("stack '%s' is locked by %s; use --force to release it anyway", stackName, info)
+			}
+
+			if _, err := lock.ForceRelease(cmd.Context(), cli, stackName); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "🔓 Released lock held by %s\n", info)
+			return nil
+		},
+	}
+
+	c.Flags().StringVarP(&flagConfigPath, "config", "c", "", "Path to rollwave.yml")
+	c.Flags().StringVarP(&flagEnv, "env", "e", "", "Environment to unlock (e.g. staging)")
+	c.Flags().BoolVar(&flagForce, "force", false, "Release the lock even if it is held by someone else")
+	return c
+}
+
+func loadStack(cfgPath, env string) (string, error) {
+	if cfgPath == "" {
+		cfgPath = "rollwave.yml"
+	}
+	baseCfg, err := config.Load(cfgPath)
+	if err != nil {
+		return "", fmt.Errorf("load config: %w", err)
+	}
+	cfg, err := baseCfg.MergeWithEnv(env)
+	if err != nil {
+		return "", err
+	}
+	if cfg.Stack.Name == "" {
+		return "", fmt.Errorf("stack name is missing in configuration")
+	}
+	return cfg.Stack.Name, nil
+}
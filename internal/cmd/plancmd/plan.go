@@ -0,0 +1,204 @@
+package plancmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rollwave-dev/rollwave/internal/bluegreen"
+	"github.com/rollwave-dev/rollwave/internal/build"
+	"github.com/rollwave-dev/rollwave/internal/compose"
+	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/deployment"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/output"
+	"github.com/rollwave-dev/rollwave/internal/plan"
+	"github.com/rollwave-dev/rollwave/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+func New() *cobra.Command {
+	var (
+		flagConfigPath  string
+		flagEnv         string
+		flagWithSecrets bool
+		flagBuild       bool
+		flagOut         string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "plan",
+		Short: "Show what a deploy would change",
+		Long: `Renders the stack like 'deploy' does (without building or creating secrets)
+and compares the result with the services running in the Swarm.
+
+With --out the plan is saved and can be executed verbatim with 'rollwave apply'.
+
+Example:
+  rollwave plan --env production --build --out production.plan
+  rollwave apply production.plan`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// 1. Load Base Config
+			cfgPath := flagConfigPath
+			if cfgPath == "" {
+				cfgPath = "rollwave.yml"
+			}
+			baseCfg, err := config.Load(cfgPath)
+			if err != nil {
+				return err
+			}
+
+			// 2. Apply Environment Overrides
+			cfg, err := baseCfg.MergeWithEnv(flagEnv)
+			if err != nil {
+				return err
+			}
+
+			if cfg.Stack.Name == "" {
+				return fmt.Errorf("stack name is missing in configuration")
+			}
+			if err := deployment.Validate(cfg); err != nil {
+				return err
+			}
+
+			if flagEnv != "" {
+				fmt.Fprintf(cmd.OutOrStdout(), "🌍 Using environment: %s\n", flagEnv)
+			}
+
+			// 3. Read (and merge) the compose files
+			currentYaml, err := compose.Load(cfg.Stack.ComposeFiles())
+			if err != nil {
+				return err
+			}
+			currentYaml, err = compose.InterpolateYAML(currentYaml, compose.LookupVariables(cfg.Variables))
+			if err != nil {
+				return err
+			}
+
+			configHash, err := plan.ConfigHash(cfg)
+			if err != nil {
+				return err
+			}
+
+			p := &plan.Plan{
+				Version:      plan.FormatVersion,
+				CreatedAt:    time.Now().UTC(),
+				Stack:        cfg.Stack.Name,
+				Environment:  flagEnv,
+				ConfigPath:   cfgPath,
+				ConfigHash:   configHash,
+				Variables:    cfg.Variables,
+				Prune:        cfg.Deploy.Prune,
+				AutoRollback: cfg.Deploy.AutoRollback,
+				Driver:       cfg.Deploy.Driver,
+				Timeout:      cfg.Deploy.Timeout,
+			}
+
+			// 4. Builds (tags are predicted, nothing is built)
+			if flagBuild {
+				buildConfigs, err := compose.ExtractBuildConfigs(currentYaml)
+				if err != nil {
+					return err
+				}
+
+				tag := build.Tag()
+				imageReplacements := make(map[string]string)
+				for _, bConf := range buildConfigs {
+					b := plan.Build{
+						Service:    bConf.ServiceName,
+						ImageName:  bConf.ImageName,
+						Tag:        tag,
+						Context:    bConf.Context,
+						Dockerfile: bConf.Dockerfile,
+					}
+					p.Builds = append(p.Builds, b)
+					imageReplacements[b.Service] = b.Image()
+				}
+
+				currentYaml, err = compose.ReplaceImages(currentYaml, imageReplacements)
+				if err != nil {
+					return fmt.Errorf("replace images: %w", err)
+				}
+			}
+
+			// 5. Secrets (names are computed, nothing is created)
+			withSecrets := flagWithSecrets
+			if !cmd.Flags().Changed("with-secrets") && cfg.Deploy.WithSecrets {
+				withSecrets = true
+			}
+			if withSecrets {
+				providers, err := secrets.Providers(cfg.Secrets, flagEnv)
+				if err != nil {
+					return err
+				}
+				secretMap, err := secrets.EnsureSecrets(cmd.Context(), nil, secrets.SyncOptions{
+					Stack:     cfg.Stack.Name,
+					Prefix:    cfg.Secrets.StackPrefix,
+					DryRun:    true,
+					Stdout:    io.Discard,
+					Providers: providers,
+				})
+				if err != nil {
+					return err
+				}
+				p.Secrets = secretMap
+				p.SecretPrefix = cfg.Secrets.StackPrefix
+				p.SecretFile = cfg.Secrets.File
+				p.SecretDirs = cfg.Secrets.Dirs
+				p.SecretSources = cfg.Secrets.Sources
+				if cfg.Secrets.Vault != (config.VaultConfig{}) {
+					p.SecretVault = &cfg.Secrets.Vault
+				}
+
+				currentYaml, err = compose.RewriteSecrets(currentYaml, secretMap)
+				if err != nil {
+					return err
+				}
+			}
+			p.Compose = string(currentYaml)
+
+			// 6. Compare with the live stack
+			cli, err := engine.New()
+			if err != nil {
+				return err
+			}
+			defer cli.Close()
+
+			liveStack, err := bluegreen.LiveStack(cmd.Context(), cli, cfg)
+			if err != nil {
+				return err
+			}
+			p.Fingerprint, err = plan.Fingerprint(cmd.Context(), cli, liveStack)
+			if err != nil {
+				return err
+			}
+			p.Changes, err = plan.Diff(cmd.Context(), cli, p, liveStack)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "📋 Plan for stack '%s':\n", p.Stack)
+			p.Print(cmd.OutOrStdout())
+			if err := output.From(cmd).Print(p); err != nil {
+				return err
+			}
+
+			if flagOut != "" {
+				if err := p.Write(flagOut); err != nil {
+					return fmt.Errorf("write plan: %w", err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "💾 Plan saved to %s. Run 'rollwave apply %s' to execute it.\n", flagOut, flagOut)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&flagConfigPath, "config", "c", "", "Path to rollwave.yml")
+	cmd.Flags().StringVarP(&flagEnv, "env", "e", "", "Environment to plan for (e.g. staging, production)")
+	cmd.Flags().BoolVar(&flagWithSecrets, "with-secrets", false, "Include secret rotation")
+	cmd.Flags().BoolVar(&flagBuild, "build", false, "Include builds of services defined in docker-compose.yml")
+	cmd.Flags().StringVarP(&flagOut, "out", "o", "", "Save the plan to a file for 'rollwave apply'")
+
+	return cmd
+}
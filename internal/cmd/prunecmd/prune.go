@@ -4,6 +4,9 @@ import (
 	"fmt"
 
 	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/lock"
+	"github.com/rollwave-dev/rollwave/internal/output"
 	"github.com/rollwave-dev/rollwave/internal/prune"
 	"github.com/spf13/cobra"
 )
@@ -12,6 +15,7 @@ func New() *cobra.Command {
 	var (
 		flagConfigPath string
 		flagEnv        string
+		flagLegacy     bool
 	)
 
 	cmd := &cobra.Command{
@@ -43,12 +47,37 @@ func New() *cobra.Command {
 				fmt.Fprintf(cmd.OutOrStdout(), "🌍 Using environment: %s\n", flagEnv)
 			}
 
+			cli, err := engine.New()
+			if err != nil {
+				return err
+			}
+			defer cli.Close()
+
+			l, err := lock.Acquire(cmd.Context(), cli, stackName, "prune", cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+			defer l.Release()
+			cmd.SetContext(l.Context())
+
 			// 3. Delegate to prune package
-			return prune.Run(cmd.Context(), stackName, cmd.OutOrStdout(), cmd.ErrOrStderr())
+			run := prune.Run
+			if flagLegacy {
+				run = prune.RunLegacy
+			}
+			deleted, err := run(cmd.Context(), cli, stackName, cfg.Secrets.StackPrefix, cmd.OutOrStdout(), cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+			return output.From(cmd).Print(map[string]any{
+				"stack":   stackName,
+				"deleted": deleted,
+			})
 		},
 	}
 
 	cmd.Flags().StringVarP(&flagConfigPath, "config", "c", "", "Path to rollwave.yml")
 	cmd.Flags().StringVarP(&flagEnv, "env", "e", "", "Environment to prune (e.g. staging, production)")
+	cmd.Flags().BoolVar(&flagLegacy, "legacy", false, "Also remove unlabelled secrets named like those of earlier Rollwave versions")
 	return cmd
 }
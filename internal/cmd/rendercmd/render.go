@@ -0,0 +1,129 @@
+package rendercmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rollwave-dev/rollwave/internal/build"
+	"github.com/rollwave-dev/rollwave/internal/compose"
+	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/output"
+	"github.com/rollwave-dev/rollwave/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+func New() *cobra.Command {
+	var (
+		flagConfigPath  string
+		flagEnv         string
+		flagWithSecrets bool
+		flagBuild       bool
+		flagOut         string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "render",
+		Short: "Print the compose file that would be deployed",
+		Long: `Merges the compose files, substitutes variables and rewrites images and
+secrets like 'deploy' does, then prints the result. Nothing is built, created
+or deployed, and the Swarm is not contacted.
+
+Variables are resolved from the 'variables' of rollwave.yml (including the
+environment's), then the shell environment, then the .env file.
+
+Example:
+  rollwave render --env production --build > rendered.yml`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// 1. Load Config
+			cfgPath := flagConfigPath
+			if cfgPath == "" {
+				cfgPath = "rollwave.yml"
+			}
+			baseCfg, err := config.Load(cfgPath)
+			if err != nil {
+				return err
+			}
+
+			// 2. Apply Environment Overrides
+			cfg, err := baseCfg.MergeWithEnv(flagEnv)
+			if err != nil {
+				return err
+			}
+			if cfg.Stack.Name == "" {
+				return fmt.Errorf("stack name is missing in configuration")
+			}
+
+			// 3. Read, merge and interpolate the compose files
+			currentYaml, err := compose.Load(cfg.Stack.ComposeFiles())
+			if err != nil {
+				return err
+			}
+			currentYaml, err = compose.InterpolateYAML(currentYaml, compose.LookupVariables(cfg.Variables))
+			if err != nil {
+				return err
+			}
+
+			// 4. Images of the services that would be built
+			if flagBuild {
+				buildConfigs, err := compose.ExtractBuildConfigs(currentYaml)
+				if err != nil {
+					return err
+				}
+				tag := build.Tag()
+				imageReplacements := make(map[string]string)
+				for _, bConf := range buildConfigs {
+					imageReplacements[bConf.ServiceName] = bConf.ImageName + ":" + tag
+				}
+				currentYaml, err = compose.ReplaceImages(currentYaml, imageReplacements)
+				if err != nil {
+					return fmt.Errorf("replace images: %w", err)
+				}
+			}
+
+			// 5. Secret versions (names are computed, nothing is created)
+			withSecrets := flagWithSecrets
+			if !cmd.Flags().Changed("with-secrets") && cfg.Deploy.WithSecrets {
+				withSecrets = true
+			}
+			if withSecrets {
+				providers, err := secrets.Providers(cfg.Secrets, flagEnv)
+				if err != nil {
+					return err
+				}
+				secretMap, err := secrets.EnsureSecrets(cmd.Context(), nil, secrets.SyncOptions{
+					Stack:     cfg.Stack.Name,
+					Prefix:    cfg.Secrets.StackPrefix,
+					DryRun:    true,
+					Stdout:    io.Discard,
+					Providers: providers,
+				})
+				if err != nil {
+					return err
+				}
+				currentYaml, err = compose.RewriteSecrets(currentYaml, secretMap)
+				if err != nil {
+					return err
+				}
+			}
+
+			if flagOut != "" {
+				if err := os.WriteFile(flagOut, currentYaml, 0644); err != nil {
+					return fmt.Errorf("write %s: %w", flagOut, err)
+				}
+				fmt.Fprintf(cmd.ErrOrStderr(), "💾 Rendered compose saved to %s\n", flagOut)
+				return nil
+			}
+			_, err = output.From(cmd).Writer().Write(currentYaml)
+			return err
+		},
+	}
+
+	cmd.Flags().StringVarP(&flagConfigPath, "config", "c", "", "Path to rollwave.yml")
+	cmd.Flags().StringVarP(&flagEnv, "env", "e", "", "Environment to render (e.g. staging, production)")
+	cmd.Flags().BoolVar(&flagWithSecrets, "with-secrets", false, "Rewrite secrets to their current versions")
+	cmd.Flags().BoolVar(&flagBuild, "build", false, "Replace images of services with a 'build' section by their predicted tags")
+	cmd.Flags().StringVarP(&flagOut, "out", "o", "", "Write the rendered compose file instead of printing it")
+
+	return cmd
+}
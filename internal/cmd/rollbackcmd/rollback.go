@@ -0,0 +1,307 @@
+package rollbackcmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/rollwave-dev/rollwave/internal/bluegreen"
+	"github.com/rollwave-dev/rollwave/internal/build"
+	"github.com/rollwave-dev/rollwave/internal/compose"
+	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/lock"
+	"github.com/rollwave-dev/rollwave/internal/output"
+	"github.com/rollwave-dev/rollwave/internal/pipeline"
+	"github.com/rollwave-dev/rollwave/internal/release"
+	"github.com/rollwave-dev/rollwave/internal/rollout"
+	"github.com/spf13/cobra"
+)
+
+func New() *cobra.Command {
+	var (
+		flagConfigPath string
+		flagEnv        string
+		flagList       bool
+		flagTimeout    time.Duration
+		flagDetach     bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "rollback [release]",
+		Short: "Redeploy a previous release without rebuilding",
+		Long: `Redeploys a previous release of the stack using the exact image tags and
+secret versions recorded when it was deployed. Without an argument, the release
+before the currently deployed one is used.
+
+Only images and secrets are restored. Everything else (services, ports,
+environment, variables and the files the compose file refers to) comes from the
+current compose files and configuration.
+
+Example:
+  rollwave rollback --env production --list
+  rollwave rollback --env production      # previous release
+  rollwave rollback --env production 12   # specific release`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// 1. Load Config
+			cfgPath := flagConfigPath
+			if cfgPath == "" {
+				cfgPath = "rollwave.yml"
+			}
+			baseCfg, err := config.Load(cfgPath)
+			if err != nil {
+				return fmt.Errorf("load config: %w", err)
+			}
+
+			// 2. Apply Environment Overrides
+			cfg, err := baseCfg.MergeWithEnv(flagEnv)
+			if err != nil {
+				return err
+			}
+
+			stackName := cfg.Stack.Name
+			if stackName == "" {
+				return fmt.Errorf("stack name is missing in configuration")
+			}
+
+			if flagEnv != "" {
+				fmt.Fprintf(cmd.OutOrStdout(), "🌍 Using environment: %s\n", flagEnv)
+			}
+
+			timeout, err := cfg.Deploy.WaitTimeout()
+			if err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("timeout") {
+				timeout = flagTimeout
+			}
+
+			cli, err := engine.NewFor(cfg, cfg.Deploy.AutoRollback)
+			if err != nil {
+				return err
+			}
+			defer cli.Close()
+
+			// 3. Discover releases from service labels (of the active colour under blue/green)
+			liveStack, err := bluegreen.LiveStack(cmd.Context(), cli, cfg)
+			if err != nil {
+				return err
+			}
+			history, err := release.Discover(cmd.Context(), cli, stackName, liveStack)
+			if err != nil {
+				return err
+			}
+			if len(history.Releases) == 0 {
+				return fmt.Errorf("no release metadata found on stack '%s' (deploy with a newer rollwave first)", stackName)
+			}
+
+			if flagList {
+				if out := output.From(cmd); out.Structured() {
+					return out.Print(map[string]any{
+						"stack":    stackName,
+						"current":  history.Current,
+						"releases": history.Releases,
+					})
+				}
+				printReleases(cmd.OutOrStdout(), history)
+				return nil
+			}
+
+			l, err := lock.Acquire(cmd.Context(), cli, stackName, "rollback", cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+			defer l.Release()
+			cmd.SetContext(l.Context())
+
+			// 4. Choose target release
+			var target release.Release
+			if len(args) == 1 {
+				id, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+				if err != nil {
+					return fmt.Errorf("invalid release '%s'", args[0])
+				}
+				var ok bool
+				target, ok = history.Get(id)
+				if !ok {
+					return fmt.Errorf("release #%d not found (run with --list to see available releases)", id)
+				}
+			} else {
+				var ok bool
+				target, ok = history.Previous()
+				if !ok {
+					return fmt.Errorf("no release older than #%d found", history.Current)
+				}
+			}
+			if target.ID == history.Current {
+				return fmt.Errorf("release #%d is already deployed", target.ID)
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "⏪ Rolling back stack '%s' from release #%d to #%d\n", stackName, history.Current, target.ID)
+
+			// 5. Refuse if the secret versions were already pruned
+			missing, err := missingSecrets(cmd.Context(), cli, target.Secrets)
+			if err != nil {
+				return err
+			}
+			if len(missing) > 0 {
+				return fmt.Errorf("cannot roll back to release #%d: secret versions were removed (pruned): %s",
+					target.ID, strings.Join(missing, ", "))
+			}
+
+			// 6. Render the current compose files with the release's images and secrets
+			if changed := target.ChangedVariables(cfg.Variables); len(changed) > 0 {
+				fmt.Fprintf(cmd.ErrOrStderr(), "⚠️  Variables changed since release #%d: %s. Their current values are used; only images and secrets are restored.\n",
+					target.ID, strings.Join(changed, ", "))
+			}
+			currentYaml, err := compose.Load(cfg.Stack.ComposeFiles())
+			if err != nil {
+				return err
+			}
+			currentYaml, err = compose.InterpolateYAML(currentYaml, compose.LookupVariables(cfg.Variables))
+			if err != nil {
+				return err
+			}
+
+			currentYaml, err = compose.ReplaceImages(currentYaml, target.Images)
+			if err != nil {
+				return fmt.Errorf("replace images: %w", err)
+			}
+			if len(target.Secrets) > 0 {
+				currentYaml, err = compose.RewriteSecrets(currentYaml, target.Secrets)
+				if err != nil {
+					return err
+				}
+			}
+
+			services := sortedKeys(target.Images)
+			for _, svc := range services {
+				fmt.Fprintf(cmd.OutOrStdout(), "   %s -> %s\n", svc, target.Images[svc])
+			}
+
+			// Ensure Swarm can pull from a private registry
+			if len(services) > 0 {
+				if err := build.Login(cmd.Context(), cli, target.Images[services[0]], cmd.OutOrStdout(), cmd.ErrOrStderr()); err != nil {
+					return fmt.Errorf("registry login: %w", err)
+				}
+			}
+
+			// 7. Deploy (recorded as a new release pointing at the target)
+			p := pipeline.Options{
+				Stack:        stackName,
+				Environment:  flagEnv,
+				Compose:      currentYaml,
+				Secrets:      target.Secrets,
+				Variables:    cfg.Variables,
+				RollbackOf:   target.ID,
+				AutoRollback: cfg.Deploy.AutoRollback,
+				Detach:       flagDetach,
+				Timeout:      timeout,
+				Driver:       cfg.Deploy.Driver,
+				Stdout:       cmd.OutOrStdout(),
+				Stderr:       cmd.ErrOrStderr(),
+			}
+			if cfg.Deploy.Strategy == config.StrategyBlueGreen {
+				// The release is redeployed as the idle colour, like any other deploy
+				if flagDetach {
+					fmt.Fprintln(cmd.ErrOrStderr(), "⚠️  --detach is ignored for blue/green deploys: traffic is only switched after the new colour converges.")
+				}
+				err = bluegreen.Deploy(cmd.Context(), cli, cfg.Deploy.BlueGreen, p)
+			} else {
+				_, err = pipeline.Deploy(cmd.Context(), cli, p)
+			}
+			if err != nil {
+				return fmt.Errorf("rollback failed: %w", err)
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "✅ Rolled back to release #%d.\n", target.ID)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&flagConfigPath, "config", "c", "", "Path to rollwave.yml")
+	cmd.Flags().StringVarP(&flagEnv, "env", "e", "", "Environment to roll back (e.g. staging, production)")
+	cmd.Flags().BoolVarP(&flagList, "list", "l", false, "List available releases")
+	cmd.Flags().DurationVar(&flagTimeout, "timeout", rollout.DefaultTimeout, "How long to wait for services to converge")
+	cmd.Flags().BoolVarP(&flagDetach, "detach", "d", false, "Do not wait for services to converge")
+
+	return cmd
+}
+
+func printReleases(out io.Writer, h *release.History) {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "RELEASE\tDEPLOYED\tGIT\tIMAGES")
+
+	for i := len(h.Releases) - 1; i >= 0; i-- {
+		r := h.Releases[i]
+		id := fmt.Sprintf("#%d", r.ID)
+		if r.ID == h.Current {
+			id += " (current)"
+		}
+
+		sha := r.GitSHA
+		if len(sha) > 7 {
+			sha = sha[:7]
+		}
+		if sha == "" {
+			sha = "-"
+		}
+
+		var images []string
+		for _, svc := range sortedKeys(r.Images) {
+			images = append(images, fmt.Sprintf("%s=%s", svc, r.Images[svc]))
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", id, r.DeployedAt.Local().Format(time.DateTime), sha, strings.Join(images, ", "))
+	}
+
+	w.Flush()
+}
+
+// missingSecrets returns the physical secret names that no longer exist in the Swarm.
+func missingSecrets(ctx context.Context, cli engine.Swarm, secretMap map[string]string) ([]string, error) {
+	if len(secretMap) == 0 {
+		return nil, nil
+	}
+
+	f := filters.NewArgs()
+	for _, name := range secretMap {
+		f.Add("name", name)
+	}
+
+	existing, err := cli.SecretList(ctx, types.SecretListOptions{Filters: f})
+	if err != nil {
+		return nil, fmt.Errorf("list secrets: %w", err)
+	}
+
+	found := make(map[string]bool)
+	for _, s := range existing {
+		found[s.Spec.Name] = true
+	}
+
+	var missing []string
+	for _, name := range secretMap {
+		if !found[name] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
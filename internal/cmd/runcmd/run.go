@@ -0,0 +1,148 @@
+package runcmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/rollwave-dev/rollwave/internal/bluegreen"
+	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/job"
+	"github.com/rollwave-dev/rollwave/internal/swarm"
+	"github.com/spf13/cobra"
+)
+
+func New() *cobra.Command {
+	var (
+		flagConfigPath string
+		flagEnv        string
+		flagTimeout    time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "run <service> -- <command> [args...]",
+		Short: "Run a one-off command with a service's image and configuration",
+		Long: `Runs a command as a one-off Swarm job using the live spec of a service:
+its current image, environment, networks, secrets and mounts. Logs are streamed
+until the command finishes, the job is removed and its exit code is returned.
+
+The command is not interactive: stdin is not attached.
+
+Example:
+  rollwave run web --env production -- ./manage.py clearsessions
+  rollwave run worker -- python scripts/backfill.py --since 2024-01-01`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			dash := cmd.ArgsLenAtDash()
+			if dash != 1 || len(args) < 2 {
+				return fmt.Errorf("usage: rollwave run <service> -- <command> [args...]")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			service, command := args[0], args[1:]
+
+			// 1. Load Config
+			cfgPath := flagConfigPath
+			if cfgPath == "" {
+				cfgPath = "rollwave.yml"
+			}
+			baseCfg, err := config.Load(cfgPath)
+			if err != nil {
+				return fmt.Errorf("load config: %w", err)
+			}
+
+			// 2. Apply Environment Overrides
+			cfg, err := baseCfg.MergeWithEnv(flagEnv)
+			if err != nil {
+				return err
+			}
+			if cfg.Stack.Name == "" {
+				return fmt.Errorf("stack name is missing in configuration")
+			}
+
+			cli, err := engine.New()
+			if err != nil {
+				return err
+			}
+			defer cli.Close()
+
+			stackName, err := bluegreen.LiveStack(cmd.Context(), cli, cfg)
+			if err != nil {
+				return err
+			}
+
+			serviceName := stackName + "_" + service
+			svc, _, err := cli.ServiceInspectWithRaw(cmd.Context(), serviceName, types.ServiceInspectOptions{})
+			if err != nil {
+				if client.IsErrNotFound(err) {
+					return fmt.Errorf("service '%s' is not deployed in stack '%s'", service, stackName)
+				}
+				return fmt.Errorf("inspect %s: %w", serviceName, err)
+			}
+			if svc.Spec.TaskTemplate.ContainerSpec == nil {
+				return fmt.Errorf("service '%s' has no container spec", service)
+			}
+
+			spec := oneOffSpec(svc.Spec, fmt.Sprintf("%s_run_%d", serviceName, time.Now().Unix()), command)
+
+			fmt.Fprintf(cmd.ErrOrStderr(), "🏃 Running '%s' in %s (%s)\n",
+				strings.Join(command, " "), service, spec.TaskTemplate.ContainerSpec.Image)
+
+			code, err := job.Run(cmd.Context(), cli, job.Options{
+				Spec:    spec,
+				Kind:    "run",
+				Timeout: flagTimeout,
+				Stdout:  cmd.OutOrStdout(),
+				Stderr:  cmd.ErrOrStderr(),
+			})
+			if err != nil {
+				return err
+			}
+			if code != 0 {
+				return &job.ExitError{Name: spec.Name, Code: code}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&flagConfigPath, "config", "c", "", "Path to rollwave.yml")
+	cmd.Flags().StringVarP(&flagEnv, "env", "e", "", "Environment to run in (e.g. staging)")
+	cmd.Flags().DurationVar(&flagTimeout, "timeout", job.DefaultTimeout, "How long the command may run")
+
+	return cmd
+}
+
+// oneOffSpec clones a live service spec for a single run of command. The clone keeps
+// the image, environment, networks, secrets and mounts but leaves the stack, so it is
+// not touched by 'docker stack deploy' or counted as part of the stack.
+func oneOffSpec(live dockerswarm.ServiceSpec, name string, command []string) dockerswarm.ServiceSpec {
+	spec := live
+	spec.Name = name
+	spec.Labels = withoutStackLabel(live.Labels)
+
+	cs := *live.TaskTemplate.ContainerSpec
+	cs.Labels = withoutStackLabel(cs.Labels)
+	// Like 'docker compose run': replace the command, keep the entrypoint
+	cs.Args = command
+	// Health checks are meant for long-running services
+	cs.Healthcheck = &container.HealthConfig{Test: []string{"NONE"}}
+	spec.TaskTemplate.ContainerSpec = &cs
+
+	return spec
+}
+
+func withoutStackLabel(labels map[string]string) map[string]string {
+	out := make(map[string]string, len(labels))
+	for k, v := range labels {
+		if k != swarm.StackLabel {
+			out[k] = v
+		}
+	}
+	return out
+}
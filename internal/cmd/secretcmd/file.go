@@ -0,0 +1,412 @@
+package secretcmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	"filippo.io/age"
+	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/output"
+	"github.com/rollwave-dev/rollwave/internal/secrets"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// fileFlags select the encrypted secrets file: --file, or the file configured
+// for --env in rollwave.yml.
+type fileFlags struct {
+	configPath string
+	env        string
+	file       string
+}
+
+func (f *fileFlags) register(c *cobra.Command) {
+	c.Flags().StringVarP(&f.configPath, "config", "c", "", "Path to rollwave.yml")
+	c.Flags().StringVarP(&f.env, "env", "e", "", "Environment whose secrets file to use (e.g. staging)")
+	c.Flags().StringVar(&f.file, "file", "", "Path to the encrypted secrets file (overrides config)")
+}
+
+func (f *fileFlags) path() (string, error) {
+	if f.file != "" {
+		return f.file, nil
+	}
+	cfgPath := f.configPath
+	if cfgPath == "" {
+		cfgPath = "rollwave.yml"
+	}
+
+	// The config is optional: without it the default file names are used
+	var secretsCfg config.SecretsConfig
+	baseCfg, err := config.Load(cfgPath)
+	if err == nil {
+		cfg, err := baseCfg.MergeWithEnv(f.env)
+		if err != nil {
+			return "", err
+		}
+		secretsCfg = cfg.Secrets
+	} else if f.configPath != "" {
+		return "", fmt.Errorf("load config: %w", err)
+	}
+	path, _ := secretsCfg.SecretsFile(f.env)
+	return path, nil
+}
+
+// open decrypts the secrets file. A file that does not exist yet is created
+// for the recipients of the local age key on save.
+func (f *fileFlags) open() (*secrets.EncryptedFile, error) {
+	path, err := f.path()
+	if err != nil {
+		return nil, err
+	}
+	file, err := secrets.OpenEncrypted(path)
+	if errors.Is(err, secrets.ErrNoSecretsFile) {
+		file.Recipients, err = secrets.OwnRecipients()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func newSetCmd() *cobra.Command {
+	var flags fileFlags
+
+	c := &cobra.Command{
+		Use:   "set KEY [VALUE]",
+		Short: "Set a secret in the encrypted secrets file",
+		Long: `Stores a secret in the encrypted secrets file. Without VALUE the value is
+read from stdin, verbatim, so multiline and binary values work.
+
+Example:
+  rollwave secrets set DB_PASSWORD --env production
+  rollwave secrets set TLS_KEY --env production < tls.key`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, err := flags.open()
+			if err != nil {
+				return err
+			}
+
+			var value string
+			if len(args) == 2 {
+				value = args[1]
+			} else {
+				data, err := io.ReadAll(cmd.InOrStdin())
+				if err != nil {
+					return fmt.Errorf("read value: %w", err)
+				}
+				value = string(data)
+			}
+
+			file.Values[args[0]] = value
+			if err := file.Save(); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "🔐 Set %s in %s\n", args[0], file.Path)
+			return nil
+		},
+	}
+	flags.register(c)
+	return c
+}
+
+func newGetCmd() *cobra.Command {
+	var flags fileFlags
+
+	c := &cobra.Command{
+		Use:   "get KEY",
+		Short: "Print a secret from the encrypted secrets file",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, err := flags.open()
+			if err != nil {
+				return err
+			}
+			value, ok := file.Values[args[0]]
+			if !ok {
+				return fmt.Errorf("secret %s not found in %s", args[0], file.Path)
+			}
+
+			out := output.From(cmd)
+			if out.Structured() {
+				return out.Print(map[string]string{"key": args[0], "value": value})
+			}
+			_, err = io.WriteString(out.Writer(), value)
+			return err
+		},
+	}
+	flags.register(c)
+	return c
+}
+
+func newRmCmd() *cobra.Command {
+	var flags fileFlags
+
+	c := &cobra.Command{
+		Use:   "rm KEY...",
+		Short: "Remove secrets from the encrypted secrets file",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, err := flags.open()
+			if err != nil {
+				return err
+			}
+			for _, key := range args {
+				if _, ok := file.Values[key]; !ok {
+					return fmt.Errorf("secret %s not found in %s", key, file.Path)
+				}
+				delete(file.Values, key)
+			}
+			if err := file.Save(); err != nil {
+				return err
+			}
+			for _, key := range args {
+				fmt.Fprintf(cmd.OutOrStdout(), "🗑️  Removed %s from %s\n", key, file.Path)
+			}
+			return nil
+		},
+	}
+	flags.register(c)
+	return c
+}
+
+func newEditCmd() *cobra.Command {
+	var flags fileFlags
+
+	c := &cobra.Command{
+		Use:   "edit",
+		Short: "Edit the encrypted secrets file in $EDITOR",
+		Long: `Decrypts the secrets file to a temporary file readable only by you, opens
+it in $VISUAL or $EDITOR (default vi) and encrypts the result again. The
+temporary file is removed afterwards.
+
+Example:
+  rollwave secrets edit --env production`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, err := flags.open()
+			if err != nil {
+				return err
+			}
+
+			before, err := yaml.Marshal(file.Values)
+			if err != nil {
+				return err
+			}
+			if len(file.Values) == 0 {
+				before = []byte("# KEY: value\n")
+			}
+
+			dir, err := os.MkdirTemp("", "rollwave-secrets-")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(dir)
+			tmp := filepath.Join(dir, "secrets.yaml")
+			if err := os.WriteFile(tmp, before, 0600); err != nil {
+				return err
+			}
+
+			if err := runEditor(cmd, tmp); err != nil {
+				return err
+			}
+
+			after, err := os.ReadFile(tmp)
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(before, after) {
+				fmt.Fprintln(cmd.OutOrStdout(), "✨ No changes.")
+				return nil
+			}
+			values := make(map[string]string)
+			if err := yaml.Unmarshal(after, &values); err != nil {
+				return fmt.Errorf("invalid secrets (expected KEY: value lines): %w", err)
+			}
+
+			file.Values = values
+			if err := file.Save(); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "🔐 Saved %d secrets to %s\n", len(values), file.Path)
+			return nil
+		},
+	}
+	flags.register(c)
+	return c
+}
+
+func runEditor(cmd *cobra.Command, path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	// The editor may contain arguments, e.g. "code --wait"
+	c := exec.CommandContext(cmd.Context(), "sh", "-c", editor+` "$1"`, "sh", path)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("editor '%s': %w", editor, err)
+	}
+	return nil
+}
+
+func newRecipientsCmd() *cobra.Command {
+	var flags fileFlags
+
+	c := &cobra.Command{
+		Use:   "recipients",
+		Short: "List the age public keys the secrets file is encrypted for",
+		Long: `Lists, adds or removes the age public keys ("age1...") that can decrypt the
+secrets file. Adding or removing a recipient re-encrypts the file, which
+requires your own key to be able to decrypt it.
+
+Removing a recipient does not revoke secrets they have already seen or older
+versions of the file in git: rotate those secrets as well.
+
+Example:
+  rollwave secrets recipients --env production
+  rollwave secrets recipients add age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p --env production`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := flags.path()
+			if err != nil {
+				return err
+			}
+			// Listing only needs the clear-text part of the file
+			raw, err := os.ReadFile(path)
+			if errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("%s: %w", path, secrets.ErrNoSecretsFile)
+			}
+			if err != nil {
+				return err
+			}
+			var file struct {
+				Recipients []string `yaml:"recipients"`
+			}
+			if err := yaml.Unmarshal(raw, &file); err != nil {
+				return fmt.Errorf("parse %s: %w", path, err)
+			}
+
+			out := output.From(cmd)
+			if out.Structured() {
+				if file.Recipients == nil {
+					file.Recipients = []string{}
+				}
+				return out.Print(file.Recipients)
+			}
+			for _, r := range file.Recipients {
+				fmt.Fprintln(cmd.OutOrStdout(), r)
+			}
+			return nil
+		},
+	}
+	flags.register(c)
+
+	c.AddCommand(newRecipientsChangeCmd("add", "Encrypt the secrets file for more age public keys"))
+	c.AddCommand(newRecipientsChangeCmd("rm", "Stop encrypting the secrets file for age public keys"))
+	return c
+}
+
+func newRecipientsChangeCmd(action, short string) *cobra.Command {
+	var flags fileFlags
+
+	c := &cobra.Command{
+		Use:   action + " PUBLIC_KEY...",
+		Short: short,
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, err := flags.open()
+			if err != nil {
+				return err
+			}
+
+			set := make(map[string]bool)
+			for _, r := range file.Recipients {
+				set[r] = true
+			}
+			for _, r := range args {
+				if _, err := age.ParseX25519Recipient(r); err != nil {
+					return fmt.Errorf("recipient '%s': %w", r, err)
+				}
+				if action == "add" {
+					set[r] = true
+				} else if !set[r] {
+					return fmt.Errorf("'%s' is not a recipient of %s", r, file.Path)
+				} else {
+					delete(set, r)
+				}
+			}
+			if len(set) == 0 {
+				return fmt.Errorf("cannot remove the last recipient of %s", file.Path)
+			}
+
+			file.Recipients = file.Recipients[:0]
+			for r := range set {
+				file.Recipients = append(file.Recipients, r)
+			}
+			sort.Strings(file.Recipients)
+			if err := file.Save(); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "🔐 Re-encrypted %s for %d recipient(s)\n", file.Path, len(file.Recipients))
+			return nil
+		},
+	}
+	flags.register(c)
+	return c
+}
+
+func newKeygenCmd() *cobra.Command {
+	var flagOut string
+
+	c := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate an age key for decrypting secrets files",
+		Long: `Generates an age key pair, stores the private key (by default in
+~/.config/rollwave/age.key) and prints the public key to share with whoever
+manages the secrets files.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := flagOut
+			if path == "" {
+				var err error
+				if path, err = secrets.DefaultKeyFile(); err != nil {
+					return err
+				}
+			}
+			if _, err := os.Stat(path); err == nil {
+				return fmt.Errorf("%s already exists", path)
+			}
+
+			id, err := age.GenerateX25519Identity()
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+				return err
+			}
+			key := fmt.Sprintf("# public key: %s\n%s\n", id.Recipient(), id)
+			if err := os.WriteFile(path, []byte(key), 0600); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.ErrOrStderr(), "🔑 Key saved to %s\n", path)
+			fmt.Fprintln(output.From(cmd).Writer(), id.Recipient().String())
+			return nil
+		},
+	}
+	c.Flags().StringVarP(&flagOut, "out", "o", "", "Where to store the private key")
+	return c
+}
@@ -3,6 +3,8 @@ package secretcmd
 import (
 	"fmt"
 
+	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/output"
 	"github.com/rollwave-dev/rollwave/internal/secrets"
 	"github.com/spf13/cobra"
 )
@@ -13,19 +15,69 @@ func New() *cobra.Command {
 		Short: "Manage Rollwave secrets",
 	}
 
+	var (
+		flagConfigPath string
+		flagEnv        string
+	)
+
 	// Default behavior: List secrets
 	cmd.RunE = func(cmd *cobra.Command, args []string) error {
-		secs, err := secrets.Load()
+		// The config is optional: without it only ROLLWAVE_SECRET_* is read
+		cfgPath := flagConfigPath
+		if cfgPath == "" {
+			cfgPath = "rollwave.yml"
+		}
+		var providers []secrets.Provider
+		baseCfg, err := config.Load(cfgPath)
+		if err == nil {
+			cfg, err := baseCfg.MergeWithEnv(flagEnv)
+			if err != nil {
+				return err
+			}
+			providers, err = secrets.Providers(cfg.Secrets, flagEnv)
+			if err != nil {
+				return err
+			}
+		} else if flagConfigPath != "" || flagEnv != "" {
+			return fmt.Errorf("load config: %w", err)
+		}
+
+		secs, err := secrets.Load(cmd.Context(), providers)
 		if err != nil {
 			return err
 		}
+		out := output.From(cmd)
+		if out.Structured() {
+			// Values are never printed, only their length
+			keys := []secretKey{}
+			for _, s := range secs {
+				keys = append(keys, secretKey{Key: s.Key, Source: s.Source, Length: len(s.Value)})
+			}
+			return out.Print(keys)
+		}
 		for _, s := range secs {
-			fmt.Printf("%s=**** (len=%d)\n", s.Key, len(s.Value))
+			fmt.Fprintf(cmd.OutOrStdout(), "%s=**** (len=%d, %s)\n", s.Key, len(s.Value), s.Source)
 		}
 		return nil
 	}
 
+	cmd.Flags().StringVarP(&flagConfigPath, "config", "c", "", "Path to rollwave.yml")
+	cmd.Flags().StringVarP(&flagEnv, "env", "e", "", "Environment whose secret sources to read (e.g. staging)")
+
 	cmd.AddCommand(newSwarmCmd())
+	cmd.AddCommand(newEditCmd())
+	cmd.AddCommand(newSetCmd())
+	cmd.AddCommand(newGetCmd())
+	cmd.AddCommand(newRmCmd())
+	cmd.AddCommand(newRecipientsCmd())
+	cmd.AddCommand(newKeygenCmd())
 
 	return cmd
 }
+
+// secretKey is a secret as listed in the json and yaml output formats.
+type secretKey struct {
+	Key    string `json:"key"`
+	Source string `json:"source"`
+	Length int    `json:"length"`
+}
@@ -4,6 +4,9 @@ import (
 	"fmt"
 
 	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/lock"
+	"github.com/rollwave-dev/rollwave/internal/output"
 	"github.com/rollwave-dev/rollwave/internal/secrets"
 	"github.com/spf13/cobra"
 )
@@ -20,8 +23,8 @@ func newSwarmCmd() *cobra.Command {
 	c := &cobra.Command{
 		Use:   "swarm",
 		Short: "Sync Rollwave secrets into Docker Swarm",
-		Long: `Reads ROLLWAVE_SECRET_* from environment (and .env if loaded)
-and creates/updates Docker Swarm secrets for a given stack.
+		Long: `Reads ROLLWAVE_SECRET_* from environment (and .env if loaded) and the
+sources configured under 'secrets' in rollwave.yml, and creates/updates Docker Swarm secrets for a given stack.
 
 Example:
   # Using config (recommended)
@@ -36,7 +39,10 @@ Example:
 				cfgPath = "rollwave.yml"
 			}
 
-			var stackName, stackPrefix string
+			var (
+				stackName, stackPrefix string
+				providers              []secrets.Provider
+			)
 
 			// We attempt to load config, but don't fail if it's missing
 			// UNLESS the user didn't provide --stack flag.
@@ -49,6 +55,10 @@ Example:
 				}
 				stackName = cfg.Stack.Name
 				stackPrefix = cfg.Secrets.StackPrefix
+				providers, err = secrets.Providers(cfg.Secrets, flagEnv)
+				if err != nil {
+					return err
+				}
 
 				if flagEnv != "" {
 					fmt.Fprintf(cmd.OutOrStdout(), "🌍 Using environment: %s\n", flagEnv)
@@ -68,14 +78,42 @@ Example:
 				return fmt.Errorf("stack name is required (provide via --stack or rollwave.yml)")
 			}
 
-			_, err = secrets.EnsureSecrets(cmd.Context(), secrets.SyncOptions{
-				Stack:  stackName,
-				Prefix: stackPrefix,
-				DryRun: flagDryRun,
-				Stdout: cmd.OutOrStdout(),
-				Stderr: cmd.ErrOrStderr(),
+			var cli engine.Engine
+			if !flagDryRun {
+				cli, err = engine.New()
+				if err != nil {
+					return err
+				}
+				defer cli.Close()
+
+				l, err := lock.Acquire(cmd.Context(), cli, stackName, "secrets swarm", cmd.ErrOrStderr())
+				if err != nil {
+					return err
+				}
+				defer l.Release()
+				cmd.SetContext(l.Context())
+			}
+
+			created := []string{}
+			secretMap, err := secrets.EnsureSecrets(cmd.Context(), cli, secrets.SyncOptions{
+				Stack:     stackName,
+				Prefix:    stackPrefix,
+				DryRun:    flagDryRun,
+				Stdout:    cmd.OutOrStdout(),
+				Stderr:    cmd.ErrOrStderr(),
+				Providers: providers,
+				OnCreate: func(key, name string) {
+					created = append(created, name)
+				},
+			})
+			if err != nil {
+				return err
+			}
+			return output.From(cmd).Print(map[string]any{
+				"stack":   stackName,
+				"secrets": secretMap,
+				"created": created,
 			})
-			return err
 		},
 	}
 
@@ -1,17 +1,17 @@
 package statuscmd
 
 import (
-	"context"
 	"fmt"
-	"os"
 	"strings"
 	"text/tabwriter"
 
-	"github.com/docker/cli/cli/connhelper"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/client"
+	"github.com/rollwave-dev/rollwave/internal/bluegreen"
 	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/output"
+	"github.com/rollwave-dev/rollwave/internal/swarm"
 	"github.com/spf13/cobra"
 )
 
@@ -50,7 +50,8 @@ func New() *cobra.Command {
 			fmt.Fprintf(cmd.OutOrStdout(), "🌍 Environment: %s\n", defaultEnvName(flagEnv))
 			fmt.Fprintf(cmd.OutOrStdout(), "📦 Stack:       %s\n\n", stackName)
 
-			return runStatus(cmd.Context(), stackName)
+			status := &Status{Environment: flagEnv, Stack: stackName}
+			return runStatus(cmd, status, cfg.Deploy.Strategy == config.StrategyBlueGreen)
 		},
 	}
 
@@ -67,47 +68,67 @@ func defaultEnvName(env string) string {
 	return env
 }
 
-func runStatus(ctx context.Context, stackName string) error {
-	opts := []client.Opt{
-		client.FromEnv,
-		client.WithAPIVersionNegotiation(),
+// ServiceStatus is a row of the status table.
+type ServiceStatus struct {
+	Service string   `json:"service"`
+	Mode    string   `json:"mode"` // "replicated" or "global"
+	Running int      `json:"running"`
+	Desired uint64   `json:"desired,omitempty"`
+	Image   string   `json:"image"`
+	Ports   []string `json:"ports,omitempty"`
+}
+
+// Status is the structured form of the command's output.
+type Status struct {
+	Environment string          `json:"environment,omitempty"`
+	Stack       string          `json:"stack"`
+	ActiveColor string          `json:"active_color,omitempty"`
+	Services    []ServiceStatus `json:"services"`
+}
+
+func runStatus(cmd *cobra.Command, status *Status, blueGreen bool) error {
+	ctx := cmd.Context()
+	out := output.From(cmd)
+
+	cli, err := engine.New()
+	if err != nil {
+		return err
 	}
+	defer cli.Close()
 
-	host := os.Getenv("DOCKER_HOST")
-	if host != "" {
-		helper, err := connhelper.GetConnectionHelper(host)
+	// Blue/green stacks run as '<stack>_blue' or '<stack>_green'
+	stackName := status.Stack
+	if blueGreen {
+		color, err := bluegreen.ActiveColor(ctx, cli, stackName)
 		if err != nil {
-			return fmt.Errorf("ssh connection helper: %w", err)
+			return err
 		}
-		if helper != nil {
-			opts = append(opts, client.WithDialContext(helper.Dialer))
+		if color != "" {
+			stackName = bluegreen.StackName(stackName, color)
+			status.ActiveColor = color
+			fmt.Fprintf(cmd.OutOrStdout(), "🔵 Active colour: %s (%s)\n\n", color, stackName)
 		}
 	}
 
-	cli, err := client.NewClientWithOpts(opts...)
-
-	if err != nil {
-		return fmt.Errorf("docker client: %w", err)
-	}
-
 	// 1. List Services for the Stack
 	serviceFilter := filters.NewArgs()
-	serviceFilter.Add("label", "com.docker.stack.namespace="+stackName)
+	serviceFilter.Add("label", swarm.StackLabel+"="+stackName)
 
 	services, err := cli.ServiceList(ctx, types.ServiceListOptions{Filters: serviceFilter})
 	if err != nil {
 		return fmt.Errorf("list services: %w", err)
 	}
 
+	status.Services = []ServiceStatus{}
 	if len(services) == 0 {
-		fmt.Println("⚠️  No services found for this stack.")
-		return nil
+		fmt.Fprintln(cmd.OutOrStdout(), "⚠️  No services found for this stack.")
+		return out.Print(status)
 	}
 
 	// 2. List Tasks (to count running replicas)
 	// We get all tasks for the stack to minimize API calls
 	taskFilter := filters.NewArgs()
-	taskFilter.Add("label", "com.docker.stack.namespace="+stackName)
+	taskFilter.Add("label", swarm.StackLabel+"="+stackName)
 	taskFilter.Add("desired-state", "running") // We care about tasks that should be running
 
 	tasks, err := cli.TaskList(ctx, types.TaskListOptions{Filters: taskFilter})
@@ -123,47 +144,61 @@ func runStatus(ctx context.Context, stackName string) error {
 		}
 	}
 
-	// 3. Print Table
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
-	fmt.Fprintln(w, "SERVICE\tREPLICAS\tIMAGE\tPORTS")
-
+	// 3. Collect the rows
 	for _, svc := range services {
-		// Clean service name (remove stack prefix)
-		shortName := strings.TrimPrefix(svc.Spec.Name, stackName+"_")
+		row := ServiceStatus{
+			// Clean service name (remove stack prefix)
+			Service: strings.TrimPrefix(svc.Spec.Name, stackName+"_"),
+			Mode:    "replicated",
+			Running: runningCounts[svc.ID],
+		}
 
-		// Replicas (Running / Desired)
-		desired := uint64(0)
+		// Note: Global mode doesn't have a fixed desired count in spec, handled simply here
 		if svc.Spec.Mode.Replicated != nil && svc.Spec.Mode.Replicated.Replicas != nil {
-			desired = *svc.Spec.Mode.Replicated.Replicas
+			row.Desired = *svc.Spec.Mode.Replicated.Replicas
 		}
-		// Note: Global mode doesn't have a fixed desired count in spec, handled simply here
-		running := runningCounts[svc.ID]
-
-		replicaStr := fmt.Sprintf("%d/%d", running, desired)
 		if svc.Spec.Mode.Global != nil {
-			replicaStr = fmt.Sprintf("%d (global)", running)
+			row.Mode = "global"
 		}
 
 		// Image
-		image := svc.Spec.TaskTemplate.ContainerSpec.Image
-		// Simplify image string (remove sha256 if too long)
-		if idx := strings.Index(image, "@sha256"); idx != -1 {
-			image = image[:idx]
+		if cs := svc.Spec.TaskTemplate.ContainerSpec; cs != nil {
+			row.Image = cs.Image
+			// Simplify image string (remove sha256 if too long)
+			if idx := strings.Index(row.Image, "@sha256"); idx != -1 {
+				row.Image = row.Image[:idx]
+			}
 		}
 
 		// Ports
-		var ports []string
 		for _, p := range svc.Endpoint.Ports {
-			ports = append(ports, fmt.Sprintf("%d->%d/%s", p.PublishedPort, p.TargetPort, p.Protocol))
+			row.Ports = append(row.Ports, fmt.Sprintf("%d->%d/%s", p.PublishedPort, p.TargetPort, p.Protocol))
 		}
-		portStr := strings.Join(ports, ", ")
+
+		status.Services = append(status.Services, row)
+	}
+
+	if out.Structured() {
+		return out.Print(status)
+	}
+
+	// 4. Print Table
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tREPLICAS\tIMAGE\tPORTS")
+
+	for _, row := range status.Services {
+		replicaStr := fmt.Sprintf("%d/%d", row.Running, row.Desired)
+		if row.Mode == "global" {
+			replicaStr = fmt.Sprintf("%d (global)", row.Running)
+		}
+
+		portStr := strings.Join(row.Ports, ", ")
 		if portStr == "" {
 			portStr = "-"
 		}
 
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", shortName, replicaStr, image, portStr)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", row.Service, replicaStr, row.Image, portStr)
 	}
 
-	w.Flush()
-	return nil
+	return w.Flush()
 }
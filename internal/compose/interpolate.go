@@ -0,0 +1,252 @@
+package compose
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LookupFunc resolves a variable name to its value.
+type LookupFunc func(name string) (string, bool)
+
+// LookupVariables resolves names from vars first and the process environment second,
+// matching how variables are exported for 'docker stack deploy'.
+func LookupVariables(vars map[string]string) LookupFunc {
+	return func(name string) (string, bool) {
+		if v, ok := vars[name]; ok {
+			return v, true
+		}
+		return os.LookupEnv(name)
+	}
+}
+
+// Interpolate substitutes $VAR, ${VAR}, ${VAR:-default}, ${VAR-default},
+// ${VAR:+alternative} and ${VAR+alternative} in s. ${VAR:?message} and
+// ${VAR?message} fail if the variable is empty or unset, respectively.
+// Defaults, alternatives and messages may themselves contain variables, as in
+// ${VAR:-${FALLBACK}}. "$$" is an escaped dollar sign.
+func Interpolate(s string, lookup LookupFunc) (string, error) {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '$' || i+1 >= len(s) {
+			b.WriteByte(c)
+			continue
+		}
+
+		next := s[i+1]
+		switch {
+		case next == '$':
+			b.WriteByte('$')
+			i++
+
+		case next == '{':
+			end := closingBrace(s[i+2:])
+			if end == -1 {
+				return "", fmt.Errorf("unterminated variable in %q", s)
+			}
+			expr := s[i+2 : i+2+end]
+			val, err := expand(expr, lookup)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(val)
+			i += 2 + end
+
+		case isNameChar(next, true):
+			j := i + 1
+			for j < len(s) && isNameChar(s[j], j == i+1) {
+				j++
+			}
+			val, _ := lookup(s[i+1 : j])
+			b.WriteString(val)
+			i = j - 1
+
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String(), nil
+}
+
+// closingBrace returns the index of the "}" that closes a "${" whose body
+// starts s, skipping over nested "${...}" expressions, or -1.
+func closingBrace(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '$':
+			i++
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '{':
+			depth++
+			i++
+		case s[i] == '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+func expand(expr string, lookup LookupFunc) (string, error) {
+	name := expr
+	for i := 0; i < len(expr); i++ {
+		if !isNameChar(expr[i], i == 0) {
+			name = expr[:i]
+			break
+		}
+	}
+	if name == "" {
+		return "", fmt.Errorf("invalid variable expression ${%s}", expr)
+	}
+
+	val, ok := lookup(name)
+	rest := expr[len(name):]
+
+	// The word after the operator is only interpolated when it is used, so a
+	// nested ${OTHER:?...} fails only if it is actually needed
+	word := func(n int) (string, error) {
+		return Interpolate(rest[n:], lookup)
+	}
+
+	switch {
+	case rest == "":
+		return val, nil
+	case strings.HasPrefix(rest, ":-"):
+		if !ok || val == "" {
+			return word(2)
+		}
+		return val, nil
+	case strings.HasPrefix(rest, "-"):
+		if !ok {
+			return word(1)
+		}
+		return val, nil
+	case strings.HasPrefix(rest, ":+"):
+		if ok && val != "" {
+			return word(2)
+		}
+		return "", nil
+	case strings.HasPrefix(rest, "+"):
+		if ok {
+			return word(1)
+		}
+		return "", nil
+	case strings.HasPrefix(rest, ":?"):
+		if !ok || val == "" {
+			msg, err := word(2)
+			if err != nil {
+				return "", err
+			}
+			return "", requiredError(name, msg)
+		}
+		return val, nil
+	case strings.HasPrefix(rest, "?"):
+		if !ok {
+			msg, err := word(1)
+			if err != nil {
+				return "", err
+			}
+			return "", requiredError(name, msg)
+		}
+		return val, nil
+	}
+
+	return "", fmt.Errorf("unsupported variable expression ${%s}", expr)
+}
+
+func requiredError(name, message string) error {
+	if message == "" {
+		return fmt.Errorf("required variable %s is missing a value", name)
+	}
+	return fmt.Errorf("required variable %s is missing a value: %s", name, message)
+}
+
+func isNameChar(c byte, first bool) bool {
+	switch {
+	case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	}
+	return false
+}
+
+// InterpolateYAML substitutes variables in every scalar value of a YAML document.
+// Mapping keys are left untouched, like 'docker stack deploy' does.
+func InterpolateYAML(data []byte, lookup LookupFunc) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse compose: %w", err)
+	}
+	if err := interpolateNode(&doc, lookup); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(&doc)
+}
+
+func interpolateNode(n *yaml.Node, lookup LookupFunc) error {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		val, err := Interpolate(n.Value, lookup)
+		if err != nil {
+			return err
+		}
+		if val != n.Value {
+			n.Value = val
+			// Let unquoted values be re-resolved, so "${REPLICAS}" becomes an int,
+			// but keep an empty result a string rather than null
+			if val == "" {
+				n.Tag = "!!str"
+			} else if n.Style == 0 {
+				n.Tag = ""
+			}
+		}
+	case yaml.MappingNode:
+		// Content alternates keys and values; only values are interpolated
+		for i := 1; i < len(n.Content); i += 2 {
+			if err := interpolateNode(n.Content[i], lookup); err != nil {
+				return err
+			}
+		}
+	default:
+		for _, c := range n.Content {
+			if err := interpolateNode(c, lookup); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// EscapeYAML doubles every "$" in the scalar values of a rendered document, so
+// a tool that interpolates again ('docker stack deploy') sees the values as is.
+func EscapeYAML(data []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse compose: %w", err)
+	}
+	escapeNode(&doc)
+	return yaml.Marshal(&doc)
+}
+
+func escapeNode(n *yaml.Node) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		n.Value = strings.ReplaceAll(n.Value, "$", "$$")
+	case yaml.MappingNode:
+		for i := 1; i < len(n.Content); i += 2 {
+			escapeNode(n.Content[i])
+		}
+	default:
+		for _, c := range n.Content {
+			escapeNode(c)
+		}
+	}
+}
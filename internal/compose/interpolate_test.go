@@ -0,0 +1,140 @@
+package compose
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		v, ok := map[string]string{
+			"TAG":      "1.4.2",
+			"REGISTRY": "ghcr.io/shop",
+			"EMPTY":    "",
+		}[name]
+		return v, ok
+	}
+
+	tests := []struct {
+		in      string
+		want    string
+		wantErr string
+	}{
+		{in: "no variables", want: "no variables"},
+		{in: "$TAG", want: "1.4.2"},
+		{in: "${REGISTRY}/web:${TAG}", want: "ghcr.io/shop/web:1.4.2"},
+		{in: "$REGISTRY/web", want: "ghcr.io/shop/web"},
+		{in: "web:$UNSET", want: "web:"},
+		{in: "price: $$5 and $$TAG", want: "price: $5 and $TAG"},
+		{in: "trailing $", want: "trailing $"},
+		{in: "$ 5", want: "$ 5"},
+
+		// Defaults
+		{in: "${UNSET:-latest}", want: "latest"},
+		{in: "${EMPTY:-latest}", want: "latest"},
+		{in: "${TAG:-latest}", want: "1.4.2"},
+		{in: "${UNSET-latest}", want: "latest"},
+		{in: "${EMPTY-latest}", want: ""},
+		{in: "${UNSET:-}", want: ""},
+
+		// Alternatives
+		{in: "${TAG:+set}", want: "set"},
+		{in: "${EMPTY:+set}", want: ""},
+		{in: "${EMPTY+set}", want: "set"},
+		{in: "${UNSET+set}", want: ""},
+
+		// Required
+		{in: "${TAG:?tag is required}", want: "1.4.2"},
+		{in: "${EMPTY?}", want: ""},
+		{in: "${UNSET:?tag is required}", wantErr: "required variable UNSET is missing a value: tag is required"},
+		{in: "${EMPTY:?}", wantErr: "required variable EMPTY is missing a value"},
+		{in: "${UNSET?}", wantErr: "required variable UNSET is missing a value"},
+
+		// Nesting
+		{in: "${UNSET:-${TAG}}", want: "1.4.2"},
+		{in: "${UNSET:-${EMPTY:-${TAG}}}-x", want: "1.4.2-x"},
+		{in: "${TAG:+v${TAG}}", want: "v1.4.2"},
+		{in: "${UNSET:-$${TAG}}", want: "${TAG}"},
+		{in: "${UNSET:-{}}", want: "{}"},
+		{in: "${TAG:-${UNSET:?not needed}}", want: "1.4.2"},
+		{in: "${UNSET:-${MISSING:?no fallback}}", wantErr: "required variable MISSING is missing a value: no fallback"},
+		{in: "${UNSET:?${TAG} is required}", wantErr: "missing a value: 1.4.2 is required"},
+
+		// Errors
+		{in: "${TAG", wantErr: "unterminated variable"},
+		{in: "${UNSET:-${TAG}", wantErr: "unterminated variable"},
+		{in: "${}", wantErr: "invalid variable expression"},
+		{in: "${1TAG}", wantErr: "invalid variable expression"},
+		{in: "${TAG/1/2}", wantErr: "unsupported variable expression"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := Interpolate(tt.in, lookup)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Interpolate = %q, %v; want error %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Interpolate = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterpolateYAML(t *testing.T) {
+	in := `services:
+  web:
+    image: ${REGISTRY}/web:${TAG}
+    environment:
+      GREETING: "${ROLLWAVE_TEST_UNSET}"
+      PRICE: $$5
+      ${TAG}: key
+    deploy:
+      replicas: ${REPLICAS}
+`
+	want := `services:
+    web:
+        image: ghcr.io/shop/web:1.4.2
+        environment:
+            GREETING: ""
+            PRICE: $5
+            ${TAG}: key
+        deploy:
+            replicas: 3
+`
+	got, err := InterpolateYAML([]byte(in), LookupVariables(map[string]string{
+		"REGISTRY": "ghcr.io/shop",
+		"TAG":      "1.4.2",
+		"REPLICAS": "3",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("InterpolateYAML =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEscapeYAML(t *testing.T) {
+	lookup := LookupVariables(nil)
+	in := "services:\n  web:\n    environment:\n      PRICE: $5\n      HOOK: ${X}\n"
+	escaped, err := EscapeYAML([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Interpolating the escaped file again gives back the original values
+	again, err := InterpolateYAML(escaped, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := decode(t, again), decode(t, []byte(in)); !reflect.DeepEqual(got, want) {
+		t.Errorf("escaped and interpolated again:\n%s\nwant:\n%s", again, in)
+	}
+}
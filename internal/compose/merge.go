@@ -0,0 +1,396 @@
+package compose
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Load reads the given compose files and merges them in order, like
+// 'docker compose -f a.yml -f b.yml'. A single file is returned unchanged.
+//
+// Merging follows the compose rules:
+//   - mappings are merged key by key, scalars are replaced
+//   - command, entrypoint and healthcheck test are replaced
+//   - environment, labels and similar lists are merged by key, in map or list form
+//   - volumes and devices are merged by target, secrets and configs by source
+//   - other lists (ports, dns, ...) are appended without duplicates
+//
+// The !reset tag removes a value and !override replaces it without merging.
+//
+// Relative paths (build contexts, env files, bind mounts, secret and config
+// files) are resolved against the directory of the file that contains them and
+// rewritten relative to the working directory, which the deploy resolves them
+// against. A single file in the working directory is returned unchanged.
+func Load(paths []string) ([]byte, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no compose file given")
+	}
+
+	var merged *yaml.Node
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read compose file '%s': %w", path, err)
+		}
+		if len(paths) == 1 && filepath.Dir(path) == "." {
+			return data, nil
+		}
+
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("parse compose file '%s': %w", path, err)
+		}
+		if len(doc.Content) == 0 {
+			continue // empty file
+		}
+		root := resolve(doc.Content[0])
+		if root.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("compose file '%s' is not a mapping", path)
+		}
+		rebase(root, filepath.Dir(path))
+
+		merged = mergeNode("", merged, root)
+	}
+	if merged == nil {
+		return nil, fmt.Errorf("compose files %s are empty", strings.Join(paths, ", "))
+	}
+	return yaml.Marshal(merged)
+}
+
+const (
+	tagReset    = "!reset"
+	tagOverride = "!override"
+)
+
+// Values that may be written as a list or a mapping; mixed forms are merged as mappings.
+var keyedLists = map[string]bool{
+	"networks":    true,
+	"environment": true,
+	"labels":      true,
+	"annotations": true,
+	"sysctls":     true,
+	"args":        true,
+	"extra_hosts": true,
+}
+
+// Values that are replaced rather than merged.
+var replacedLists = map[string]bool{
+	"command":    true,
+	"entrypoint": true,
+	"test":       true,
+}
+
+// mergeNode returns override merged over base; key is the mapping key both are
+// stored under. It returns nil if the value is reset.
+func mergeNode(key string, base, override *yaml.Node) *yaml.Node {
+	switch override.Tag {
+	case tagReset:
+		return nil
+	case tagOverride:
+		return clearTags(override)
+	}
+	if base == nil {
+		return clearTags(override)
+	}
+
+	if keyedLists[key] && (base.Kind == yaml.MappingNode || override.Kind == yaml.MappingNode) {
+		base, override = toMapping(key, base), toMapping(key, override)
+	}
+
+	switch {
+	case base.Kind == yaml.MappingNode && override.Kind == yaml.MappingNode:
+		out := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		out.Content = append(out.Content, base.Content...)
+		for i := 0; i+1 < len(override.Content); i += 2 {
+			k, v := override.Content[i], override.Content[i+1]
+			idx := mappingIndex(out, k.Value)
+			var current *yaml.Node
+			if idx >= 0 {
+				current = out.Content[idx+1]
+			}
+			mergedValue := mergeNode(k.Value, current, v)
+			switch {
+			case mergedValue == nil && idx >= 0:
+				out.Content = append(out.Content[:idx:idx], out.Content[idx+2:]...)
+			case mergedValue == nil:
+			case idx >= 0:
+				out.Content[idx+1] = mergedValue
+			default:
+				out.Content = append(out.Content, k, mergedValue)
+			}
+		}
+		return out
+
+	case base.Kind == yaml.SequenceNode && override.Kind == yaml.SequenceNode && !replacedLists[key]:
+		keyOf := sequenceKey(key)
+		out := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		out.Content = append(out.Content, base.Content...)
+		for _, item := range override.Content {
+			item = clearTags(item)
+			k := keyOf(item)
+			replaced := false
+			for i, existing := range out.Content {
+				if keyOf(existing) == k {
+					out.Content[i] = item
+					replaced = true
+					break
+				}
+			}
+			if !replaced {
+				out.Content = append(out.Content, item)
+			}
+		}
+		return out
+	}
+
+	return clearTags(override)
+}
+
+// sequenceKey returns how entries of the list stored under key are identified.
+func sequenceKey(key string) func(*yaml.Node) string {
+	switch key {
+	case "environment", "labels", "annotations", "sysctls", "args":
+		return func(n *yaml.Node) string {
+			k, _, _ := strings.Cut(n.Value, "=")
+			return k
+		}
+	case "extra_hosts":
+		return func(n *yaml.Node) string {
+			k, _, _ := strings.Cut(n.Value, "=")
+			k, _, _ = strings.Cut(k, ":")
+			return k
+		}
+	case "volumes", "devices":
+		return func(n *yaml.Node) string {
+			if n.Kind == yaml.MappingNode {
+				return "target:" + mappingValue(n, "target")
+			}
+			parts := strings.Split(n.Value, ":")
+			if len(parts) == 1 {
+				return "target:" + parts[0]
+			}
+			return "target:" + parts[1]
+		}
+	case "secrets", "configs":
+		return func(n *yaml.Node) string {
+			if n.Kind == yaml.MappingNode {
+				return mappingValue(n, "source")
+			}
+			return n.Value
+		}
+	}
+	return func(n *yaml.Node) string {
+		if n.Kind == yaml.ScalarNode {
+			return n.Value
+		}
+		out, _ := yaml.Marshal(n)
+		return string(out)
+	}
+}
+
+// toMapping converts the list form ("KEY=value", or a bare name) of key to a mapping.
+func toMapping(key string, n *yaml.Node) *yaml.Node {
+	if n.Kind != yaml.SequenceNode {
+		return n
+	}
+	out := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, item := range n.Content {
+		s, sep := item.Value, "="
+		if key == "extra_hosts" && !strings.Contains(s, "=") {
+			sep = ":"
+		}
+		k, v, ok := strings.Cut(s, sep)
+		value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}
+		if !ok {
+			value = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+		}
+		out.Content = append(out.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}, value)
+	}
+	return out
+}
+
+func mappingIndex(n *yaml.Node, key string) int {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func mappingValue(n *yaml.Node, key string) string {
+	if i := mappingIndex(n, key); i >= 0 {
+		return n.Content[i+1].Value
+	}
+	return ""
+}
+
+// clearTags drops the merge tags from n and its children so they do not end
+// up in the rendered file.
+func clearTags(n *yaml.Node) *yaml.Node {
+	if n.Tag == tagReset || n.Tag == tagOverride {
+		c := *n
+		c.Tag = ""
+		n = &c
+	}
+	for i, child := range n.Content {
+		n.Content[i] = clearTags(child)
+	}
+	return n
+}
+
+// resolve replaces aliases with the nodes they point to and expands "<<" merge
+// keys, so files can be merged without anchors crossing file boundaries.
+func resolve(n *yaml.Node) *yaml.Node {
+	if n.Kind == yaml.AliasNode {
+		return resolve(n.Alias)
+	}
+
+	out := *n
+	out.Anchor = ""
+	out.Content = nil
+
+	if n.Kind != yaml.MappingNode {
+		for _, c := range n.Content {
+			out.Content = append(out.Content, resolve(c))
+		}
+		return &out
+	}
+
+	var inherited []*yaml.Node
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], resolve(n.Content[i+1])
+		if k.Tag != "!!merge" {
+			out.Content = append(out.Content, k, v)
+			continue
+		}
+		// The value is a mapping or a list of mappings; earlier ones win
+		sources := []*yaml.Node{v}
+		if v.Kind == yaml.SequenceNode {
+			sources = v.Content
+		}
+		for _, src := range sources {
+			inherited = append(inherited, src.Content...)
+		}
+	}
+	for i := 0; i+1 < len(inherited); i += 2 {
+		if mappingIndex(&out, inherited[i].Value) < 0 {
+			out.Content = append(out.Content, inherited[i], inherited[i+1])
+		}
+	}
+	return &out
+}
+
+// ResolvePaths rewrites the relative paths of a compose file (build contexts,
+// env files, bind mounts, secret and config files) to absolute paths in dir, so
+// the file can be used from another directory.
+func ResolvePaths(yamlBytes []byte, dir string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(yamlBytes, &doc); err != nil {
+		return nil, fmt.Errorf("parse compose: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return yamlBytes, nil
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	rebase(doc.Content[0], dir)
+	return yaml.Marshal(&doc)
+}
+
+// rebase rewrites the relative paths of a compose file in directory dir so they
+// point at the same files from the working directory.
+func rebase(root *yaml.Node, dir string) {
+	if dir == "." {
+		return
+	}
+	join := func(p string) string {
+		return filepath.Join(dir, p)
+	}
+	fix := func(n *yaml.Node) {
+		if n != nil && n.Kind == yaml.ScalarNode && isRelativePath(n.Value) {
+			n.Value = join(n.Value)
+		}
+	}
+	// Bind mount sources must keep looking like paths, or they name a volume
+	fixBind := func(source string) string {
+		p := join(source)
+		if filepath.IsAbs(p) || strings.HasPrefix(p, ".") {
+			return p
+		}
+		return "./" + p
+	}
+
+	if services := mappingChild(root, "services"); services != nil {
+		for i := 1; i < len(services.Content); i += 2 {
+			svc := services.Content[i]
+			if build := mappingChild(svc, "build"); build != nil {
+				if build.Kind == yaml.ScalarNode {
+					fix(build)
+				} else {
+					fix(mappingChild(build, "context"))
+				}
+			}
+			switch envFile := mappingChild(svc, "env_file"); {
+			case envFile == nil:
+			case envFile.Kind == yaml.SequenceNode:
+				for _, item := range envFile.Content {
+					if item.Kind == yaml.MappingNode {
+						fix(mappingChild(item, "path"))
+					} else {
+						fix(item)
+					}
+				}
+			default:
+				fix(envFile)
+			}
+			if volumes := mappingChild(svc, "volumes"); volumes != nil && volumes.Kind == yaml.SequenceNode {
+				for _, v := range volumes.Content {
+					switch v.Kind {
+					case yaml.ScalarNode:
+						// source:target[:mode]; only sources starting with '.' are relative paths
+						source, rest, ok := strings.Cut(v.Value, ":")
+						if ok && strings.HasPrefix(source, ".") {
+							v.Value = fixBind(source) + ":" + rest
+						}
+					case yaml.MappingNode:
+						source := mappingChild(v, "source")
+						if mappingValue(v, "type") == "bind" && source != nil && isRelativePath(source.Value) {
+							source.Value = fixBind(source.Value)
+						}
+					}
+				}
+			}
+		}
+	}
+	for _, kind := range []string{"secrets", "configs"} {
+		if objects := mappingChild(root, kind); objects != nil {
+			for i := 1; i < len(objects.Content); i += 2 {
+				fix(mappingChild(objects.Content[i], "file"))
+			}
+		}
+	}
+}
+
+// isRelativePath reports whether s is a local relative path. URLs (remote build
+// contexts) and values starting with a variable or '~' are left alone.
+func isRelativePath(s string) bool {
+	return s != "" && !filepath.IsAbs(s) && !strings.Contains(s, "://") &&
+		!strings.HasPrefix(s, "git@") && !strings.HasPrefix(s, "$") && !strings.HasPrefix(s, "~")
+}
+
+func mappingChild(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	if i := mappingIndex(n, key); i >= 0 {
+		return n.Content[i+1]
+	}
+	return nil
+}
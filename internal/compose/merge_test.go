@@ -0,0 +1,304 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string // path relative to the working directory -> content
+		load  []string
+		want  string
+	}{
+		{
+			name: "mappings are merged and scalars replaced",
+			files: map[string]string{
+				"docker-compose.yml": `
+services:
+  web:
+    image: shop/web:1
+    deploy:
+      replicas: 2
+      resources: {limits: {memory: 256M}}
+`,
+				"docker-compose.prod.yml": `
+services:
+  web:
+    image: shop/web:2
+    deploy:
+      replicas: 4
+  worker:
+    image: shop/worker:2
+`,
+			},
+			load: []string{"docker-compose.yml", "docker-compose.prod.yml"},
+			want: `
+services:
+  web:
+    image: shop/web:2
+    deploy:
+      replicas: 4
+      resources: {limits: {memory: 256M}}
+  worker:
+    image: shop/worker:2
+`,
+		},
+		{
+			name: "lists",
+			files: map[string]string{
+				"docker-compose.yml": `
+services:
+  web:
+    image: shop/web
+    command: [serve, --port, "80"]
+    environment: [LOG_LEVEL=info, DB_HOST=db]
+    ports: ["80:80"]
+    volumes: [uploads:/data, ./conf:/etc/web]
+`,
+				"docker-compose.prod.yml": `
+services:
+  web:
+    command: [serve]
+    environment:
+      LOG_LEVEL: warn
+      REGION: eu
+    ports: ["80:80", "443:443"]
+    volumes: [media:/data]
+`,
+			},
+			load: []string{"docker-compose.yml", "docker-compose.prod.yml"},
+			want: `
+services:
+  web:
+    image: shop/web
+    command: [serve]
+    environment:
+      LOG_LEVEL: warn
+      DB_HOST: db
+      REGION: eu
+    ports: ["80:80", "443:443"]
+    volumes: [media:/data, ./conf:/etc/web]
+`,
+		},
+		{
+			name: "reset and override",
+			files: map[string]string{
+				"docker-compose.yml": `
+services:
+  web:
+    image: shop/web
+    ports: ["80:80"]
+    labels: {tier: front, team: shop}
+`,
+				"docker-compose.prod.yml": `
+services:
+  web:
+    ports: !reset []
+    labels: !override {tier: edge}
+`,
+			},
+			load: []string{"docker-compose.yml", "docker-compose.prod.yml"},
+			want: `
+services:
+  web:
+    image: shop/web
+    labels: {tier: edge}
+`,
+		},
+		{
+			name: "merge keys",
+			files: map[string]string{
+				"docker-compose.yml": `
+x-base: &base
+  image: shop/web
+  restart: always
+services:
+  web:
+    <<: *base
+    restart: "no"
+`,
+				"docker-compose.prod.yml": `
+services:
+  web:
+    labels:
+      "<<": literal
+`,
+			},
+			load: []string{"docker-compose.yml", "docker-compose.prod.yml"},
+			want: `
+x-base:
+  image: shop/web
+  restart: always
+services:
+  web:
+    image: shop/web
+    restart: "no"
+    labels:
+      "<<": literal
+`,
+		},
+		{
+			name: "relative paths of files in another directory",
+			files: map[string]string{
+				"deploy/base.yml": `
+services:
+  web:
+    build: ../app
+    env_file: [web.env]
+    volumes:
+      - ./conf:/etc/web:ro
+      - uploads:/data
+      - type: bind
+        source: ../static
+        target: /srv
+secrets:
+  tls:
+    file: certs/tls.key
+  api_key:
+    external: true
+`,
+				"deploy/prod/overlay.yml": `
+services:
+  web:
+    build:
+      context: ../../app
+      dockerfile: Dockerfile.prod
+    env_file:
+      - prod.env
+      - path: /etc/shop/extra.env
+        required: false
+configs:
+  nginx:
+    file: ./nginx.conf
+`,
+			},
+			load: []string{"deploy/base.yml", "deploy/prod/overlay.yml"},
+			want: `
+services:
+  web:
+    build:
+      context: app
+      dockerfile: Dockerfile.prod
+    env_file:
+      - deploy/web.env
+      - deploy/prod/prod.env
+      - path: /etc/shop/extra.env
+        required: false
+    volumes:
+      - ./deploy/conf:/etc/web:ro
+      - uploads:/data
+      - type: bind
+        source: ./static
+        target: /srv
+secrets:
+  tls:
+    file: deploy/certs/tls.key
+  api_key:
+    external: true
+configs:
+  nginx:
+    file: deploy/prod/nginx.conf
+`,
+		},
+		{
+			name: "single file in another directory",
+			files: map[string]string{
+				"deploy/docker-compose.yml": `
+services:
+  web:
+    build: .
+    volumes: [../data:/data]
+`,
+			},
+			load: []string{"deploy/docker-compose.yml"},
+			want: `
+services:
+  web:
+    build: deploy
+    volumes: [./data:/data]
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			for path, content := range tt.files {
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := Load(tt.load)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decode(t, got), decode(t, []byte(tt.want))) {
+				t.Errorf("merged compose file:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSingleFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+	data := []byte("# unchanged, comments included\nservices:\n  web:\n    build: .\n")
+	if err := os.WriteFile("docker-compose.yml", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Load([]string{"docker-compose.yml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("Load = %q, want the file unchanged", got)
+	}
+}
+
+// decode parses a compose file for comparison, ignoring formatting.
+func decode(t *testing.T, data []byte) any {
+	t.Helper()
+	var v any
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		t.Fatalf("parse %s: %v", data, err)
+	}
+	return v
+}
+
+func TestResolvePaths(t *testing.T) {
+	in := `services:
+  web:
+    build: .
+    env_file: web.env
+    volumes: [./conf:/etc/web, uploads:/data, /var/log:/logs]
+secrets:
+  tls:
+    file: certs/tls.key
+`
+	want := `services:
+  web:
+    build: /srv/shop
+    env_file: /srv/shop/web.env
+    volumes: [/srv/shop/conf:/etc/web, uploads:/data, /var/log:/logs]
+secrets:
+  tls:
+    file: /srv/shop/certs/tls.key
+`
+	got, err := ResolvePaths([]byte(in), "/srv/shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decode(t, got), decode(t, []byte(want))) {
+		t.Errorf("ResolvePaths =\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -2,6 +2,8 @@ package compose
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -69,6 +71,24 @@ func ExtractBuildConfigs(yamlBytes []byte) ([]BuildConfig, error) {
 	return configs, nil
 }
 
+// SelectBuildConfigs returns the configs of the given services, or all configs if
+// services is empty.
+func SelectBuildConfigs(configs []BuildConfig, services []string) []BuildConfig {
+	if len(services) == 0 {
+		return configs
+	}
+	var out []BuildConfig
+	for _, c := range configs {
+		for _, name := range services {
+			if c.ServiceName == name {
+				out = append(out, c)
+				break
+			}
+		}
+	}
+	return out
+}
+
 // RewriteSecrets modifies the Compose YAML to point to specific secret versions.
 func RewriteSecrets(originalYaml []byte, secretMap map[string]string) ([]byte, error) {
 	var data map[string]interface{}
@@ -121,3 +141,281 @@ func ReplaceImages(yamlBytes []byte, newImages map[string]string) ([]byte, error
 	data["services"] = services
 	return yaml.Marshal(data)
 }
+
+// CheckServices returns an error if any of names is not a service of the compose file.
+func CheckServices(yamlBytes []byte, names []string) error {
+	var data struct {
+		Services map[string]interface{} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(yamlBytes, &data); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, ok := data.Services[name]; !ok {
+			return fmt.Errorf("service '%s' is not defined in the compose file", name)
+		}
+	}
+	return nil
+}
+
+// SelectServices removes every service that is not in names from the compose file.
+func SelectServices(yamlBytes []byte, names []string) ([]byte, error) {
+	var data map[string]interface{}
+	if err := yaml.Unmarshal(yamlBytes, &data); err != nil {
+		return nil, fmt.Errorf("parse compose: %w", err)
+	}
+
+	services, ok := data["services"].(map[string]interface{})
+	if !ok {
+		return yamlBytes, nil
+	}
+	for name := range services {
+		if !slices.Contains(names, name) {
+			delete(services, name)
+		}
+	}
+	return yaml.Marshal(data)
+}
+
+// ServiceImages returns the image of every service that defines one, keyed by service name.
+func ServiceImages(yamlBytes []byte) (map[string]string, error) {
+	var data struct {
+		Services map[string]map[string]interface{} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(yamlBytes, &data); err != nil {
+		return nil, err
+	}
+
+	images := make(map[string]string)
+	for name, svc := range data.Services {
+		if image, ok := svc["image"].(string); ok && image != "" {
+			images[name] = image
+		}
+	}
+	return images, nil
+}
+
+// SetServiceLabels adds the given labels to the 'deploy.labels' section of every service.
+// Both the map and the list ("key=value") forms of compose labels are supported.
+func SetServiceLabels(yamlBytes []byte, labels map[string]string) ([]byte, error) {
+	var data map[string]interface{}
+	if err := yaml.Unmarshal(yamlBytes, &data); err != nil {
+		return nil, err
+	}
+
+	services, ok := data["services"].(map[string]interface{})
+	if !ok {
+		return yamlBytes, nil
+	}
+
+	for svcName, svcBody := range services {
+		svc, ok := svcBody.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		deploy, _ := svc["deploy"].(map[string]interface{})
+		if deploy == nil {
+			deploy = make(map[string]interface{})
+		}
+
+		merged := make(map[string]interface{})
+		switch existing := deploy["labels"].(type) {
+		case map[string]interface{}:
+			for k, v := range existing {
+				merged[k] = v
+			}
+		case []interface{}:
+			for _, item := range existing {
+				entry, ok := item.(string)
+				if !ok {
+					continue
+				}
+				k, v, _ := strings.Cut(entry, "=")
+				merged[k] = v
+			}
+		}
+		for k, v := range labels {
+			merged[k] = v
+		}
+
+		deploy["labels"] = merged
+		svc["deploy"] = deploy
+		services[svcName] = svc
+	}
+
+	data["services"] = services
+	return yaml.Marshal(data)
+}
+
+// ServiceSecrets returns the Swarm secret names referenced by every service, keyed by service name.
+// Top-level secrets with a 'name' use it; others follow the 'docker stack deploy' naming (stack_secret).
+func ServiceSecrets(yamlBytes []byte, stack string) (map[string][]string, error) {
+	var data struct {
+		Services map[string]struct {
+			Secrets []interface{} `yaml:"secrets"`
+		} `yaml:"services"`
+		Secrets secretDefinitions `yaml:"secrets"`
+	}
+	if err := yaml.Unmarshal(yamlBytes, &data); err != nil {
+		return nil, err
+	}
+
+	out := make(map[string][]string)
+	for name, svc := range data.Services {
+		names := []string{}
+		for _, ref := range svc.Secrets {
+			switch r := ref.(type) {
+			case string:
+				names = append(names, data.Secrets.physical(stack, r))
+			case map[string]interface{}:
+				if source, ok := r["source"].(string); ok {
+					names = append(names, data.Secrets.physical(stack, source))
+				}
+			}
+		}
+		out[name] = names
+	}
+	return out, nil
+}
+
+// SecretNames returns the Swarm secret name of each top-level secret in sources,
+// keyed by the name used in the compose file.
+func SecretNames(yamlBytes []byte, stack string, sources []string) (map[string]string, error) {
+	var data struct {
+		Secrets secretDefinitions `yaml:"secrets"`
+	}
+	if err := yaml.Unmarshal(yamlBytes, &data); err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]string, len(sources))
+	for _, source := range sources {
+		if _, ok := data.Secrets[source]; !ok {
+			return nil, fmt.Errorf("secret '%s' is not defined in the compose file", source)
+		}
+		out[source] = data.Secrets.physical(stack, source)
+	}
+	return out, nil
+}
+
+type secretDefinitions map[string]struct {
+	Name     string      `yaml:"name"`
+	External interface{} `yaml:"external"`
+}
+
+func (d secretDefinitions) physical(stack, source string) string {
+	def, ok := d[source]
+	switch {
+	case ok && def.Name != "":
+		return def.Name
+	case ok && def.External == true:
+		return source
+	default:
+		return stack + "_" + source
+	}
+}
+
+// ServiceEnvironment returns the 'environment' of a service, accepting both the
+// map and the list ("KEY=value") syntax. Values are not interpolated.
+func ServiceEnvironment(yamlBytes []byte, service string) (map[string]string, error) {
+	var data struct {
+		Services map[string]struct {
+			Environment interface{} `yaml:"environment"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(yamlBytes, &data); err != nil {
+		return nil, err
+	}
+
+	svc, ok := data.Services[service]
+	if !ok {
+		return nil, fmt.Errorf("service '%s' is not defined in the compose file", service)
+	}
+
+	env := make(map[string]string)
+	switch e := svc.Environment.(type) {
+	case map[string]interface{}:
+		for k, v := range e {
+			if v != nil {
+				env[k] = fmt.Sprint(v)
+			}
+		}
+	case []interface{}:
+		for _, item := range e {
+			if k, v, ok := strings.Cut(fmt.Sprint(item), "="); ok {
+				env[k] = v
+			}
+		}
+	}
+	return env, nil
+}
+
+// StripPorts removes the 'ports' section from every service and returns the removed
+// entries (short "8080:80" or long-syntax maps), keyed by service name.
+func StripPorts(yamlBytes []byte) ([]byte, map[string][]interface{}, error) {
+	var data map[string]interface{}
+	if err := yaml.Unmarshal(yamlBytes, &data); err != nil {
+		return nil, nil, err
+	}
+
+	removed := make(map[string][]interface{})
+
+	services, ok := data["services"].(map[string]interface{})
+	if !ok {
+		return yamlBytes, removed, nil
+	}
+
+	for svcName, svcBody := range services {
+		svc, ok := svcBody.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if ports, ok := svc["ports"].([]interface{}); ok && len(ports) > 0 {
+			removed[svcName] = ports
+		}
+		delete(svc, "ports")
+		services[svcName] = svc
+	}
+
+	data["services"] = services
+	out, err := yaml.Marshal(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, removed, nil
+}
+
+// NameVolumes gives every top-level volume that is neither external nor named
+// explicitly the name '<stack>_<volume>', the name 'docker stack deploy' would
+// derive for it. Services deployed under another stack name, like the colours
+// of a blue/green deploy, then keep using the volumes of the logical stack.
+func NameVolumes(yamlBytes []byte, stack string) ([]byte, error) {
+	var data map[string]interface{}
+	if err := yaml.Unmarshal(yamlBytes, &data); err != nil {
+		return nil, err
+	}
+
+	volumes, ok := data["volumes"].(map[string]interface{})
+	if !ok || len(volumes) == 0 {
+		return yamlBytes, nil
+	}
+
+	for name, body := range volumes {
+		def, _ := body.(map[string]interface{})
+		if def == nil {
+			def = make(map[string]interface{})
+		}
+		if ext, ok := def["external"]; ok && ext != false && ext != nil {
+			continue
+		}
+		if n, ok := def["name"].(string); ok && n != "" {
+			continue
+		}
+		def["name"] = stack + "_" + name
+		volumes[name] = def
+	}
+
+	data["volumes"] = volumes
+	return yaml.Marshal(data)
+}
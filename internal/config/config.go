@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -10,17 +12,468 @@ import (
 // --- Shared Structures ---
 
 type StackConfig struct {
-	Name        string `yaml:"name"`
-	ComposeFile string `yaml:"compose_file"`
+	Name string `yaml:"name"`
+	// ComposeFile is one path or a list; later files are merged over earlier ones.
+	ComposeFile FileList `yaml:"compose_file"`
+}
+
+// DefaultComposeFile is used when no compose_file is configured.
+const DefaultComposeFile = "docker-compose.yml"
+
+// ComposeFiles returns the compose files to merge, in order.
+func (s StackConfig) ComposeFiles() []string {
+	if len(s.ComposeFile) == 0 {
+		return []string{DefaultComposeFile}
+	}
+	return s.ComposeFile
+}
+
+// FileList is a list of paths that may also be written as a single string.
+type FileList []string
+
+// UnmarshalYAML accepts both "a.yml" and ["a.yml", "b.yml"].
+func (l *FileList) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		var path string
+		if err := n.Decode(&path); err != nil {
+			return err
+		}
+		*l = nil
+		if path != "" {
+			*l = FileList{path}
+		}
+		return nil
+	}
+	var paths []string
+	if err := n.Decode(&paths); err != nil {
+		return err
+	}
+	*l = paths
+	return nil
 }
 
 type SecretsConfig struct {
 	StackPrefix string `yaml:"stack_prefix"`
+	// File is the age-encrypted secrets file; see SecretsFile for the default.
+	File string `yaml:"file"`
+	// Dirs are directories whose files are secrets named after the file.
+	Dirs []string `yaml:"dirs"`
+	// Sources maps secret keys to where their values come from. They take
+	// precedence over Dirs, then File, then ROLLWAVE_SECRET_* variables.
+	Sources map[string]SecretSource `yaml:"sources"`
+	// Vault is the server the 'vault' sources are read from.
+	Vault VaultConfig `yaml:"vault"`
+}
+
+// VaultConfig locates a HashiCorp Vault server with a KV version 2 engine.
+// Empty values fall back to VAULT_ADDR, VAULT_NAMESPACE and VAULT_CACERT.
+// Rollwave authenticates with VAULT_TOKEN (or ~/.vault-token), or with AppRole
+// when VAULT_ROLE_ID and VAULT_SECRET_ID are set.
+type VaultConfig struct {
+	Address      string `yaml:"address" json:"address,omitempty"`
+	Namespace    string `yaml:"namespace" json:"namespace,omitempty"`
+	Mount        string `yaml:"mount" json:"mount,omitempty"`                 // KV v2 mount, default "secret"
+	AppRoleMount string `yaml:"approle_mount" json:"approle_mount,omitempty"` // default "approle"
+	CACert       string `yaml:"ca_cert" json:"ca_cert,omitempty"`             // PEM file to verify the server with
+}
+
+// SecretsFile returns the encrypted secrets file of env and whether it was
+// configured explicitly. It defaults to secrets.<env>.enc.yaml, or
+// secrets.enc.yaml without an environment.
+func (s SecretsConfig) SecretsFile(env string) (string, bool) {
+	if s.File != "" {
+		return s.File, true
+	}
+	if env == "" {
+		return "secrets.enc.yaml", false
+	}
+	return "secrets." + env + ".enc.yaml", false
+}
+
+// Encodings of secret sources.
+const (
+	EncodingRaw    = "raw"
+	EncodingBase64 = "base64"
+)
+
+// SecretSource is where the value of a single secret comes from; exactly one of
+// File, Exec, Env and Vault is set. In rollwave.yml it may also be written as a
+// string: "file:./certs/tls.key", "exec:pass show db", "env:DATABASE_PASSWORD"
+// or "vault:shop/db#password".
+type SecretSource struct {
+	File  string `yaml:"file" json:"file,omitempty"`   // the whole file is the value, newlines included
+	Exec  string `yaml:"exec" json:"exec,omitempty"`   // shell command printing the value; a trailing newline is dropped
+	Env   string `yaml:"env" json:"env,omitempty"`     // environment variable holding the value
+	Vault string `yaml:"vault" json:"vault,omitempty"` // KV v2 path and field, "<path>#<field>"
+
+	// Encoding is "raw" (default) or "base64" for binary values stored as text.
+	Encoding string `yaml:"encoding" json:"encoding,omitempty"`
+}
+
+// UnmarshalYAML accepts both the mapping and the "kind:value" string form.
+func (s *SecretSource) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		var v string
+		if err := n.Decode(&v); err != nil {
+			return err
+		}
+		kind, value, ok := strings.Cut(v, ":")
+		if !ok {
+			return fmt.Errorf("line %d: invalid secret source '%s' (expected file:, exec:, env: or vault:)", n.Line, v)
+		}
+		*s = SecretSource{}
+		switch kind {
+		case "file":
+			s.File = value
+		case "exec":
+			s.Exec = value
+		case "env":
+			s.Env = value
+		case "vault":
+			s.Vault = value
+		default:
+			return fmt.Errorf("line %d: invalid secret source '%s' (expected file:, exec:, env: or vault:)", n.Line, v)
+		}
+		return nil
+	}
+	type plain SecretSource
+	return n.Decode((*plain)(s))
+}
+
+// Validate checks that every source has exactly one kind and a known encoding.
+func (s SecretsConfig) Validate() error {
+	for key, src := range s.Sources {
+		set := 0
+		for _, v := range []string{src.File, src.Exec, src.Env, src.Vault} {
+			if v != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			return fmt.Errorf("secrets.sources.%s: exactly one of file, exec, env and vault is required", key)
+		}
+		if path, _, _ := strings.Cut(src.Vault, "#"); src.Vault != "" && strings.Trim(path, "/") == "" {
+			return fmt.Errorf("secrets.sources.%s: vault path is missing in '%s'", key, src.Vault)
+		}
+		switch src.Encoding {
+		case "", EncodingRaw, EncodingBase64:
+		default:
+			return fmt.Errorf("secrets.sources.%s: invalid encoding '%s' (expected %s or %s)", key, src.Encoding, EncodingRaw, EncodingBase64)
+		}
+	}
+	return nil
 }
 
 type DeployConfig struct {
 	WithSecrets bool `yaml:"with_secrets"`
 	Prune       bool `yaml:"prune"`
+	// Timeout limits how long deploy waits for services to converge (e.g. "5m").
+	Timeout string `yaml:"timeout"`
+	// AutoRollback restores the previous service specs when a deploy fails to converge.
+	AutoRollback bool `yaml:"auto_rollback"`
+	// Strategy is "rolling" (default, Swarm in-place update) or "blue-green".
+	Strategy  string          `yaml:"strategy"`
+	BlueGreen BlueGreenConfig `yaml:"blue_green"`
+	// Canary maps service names to staged rollout settings.
+	Canary map[string]CanaryConfig `yaml:"canary"`
+	// Driver is "native" (default, Docker API) or "cli" ('docker stack deploy').
+	Driver string `yaml:"driver"`
+}
+
+// CanaryConfig describes a staged rollout of a single service.
+type CanaryConfig struct {
+	// Steps are percentages of the replicas running the new image, e.g. [10, 50, 100].
+	Steps []int `yaml:"steps"`
+	// Hold is how long each step is observed before moving on (e.g. "1m").
+	Hold  string       `yaml:"hold"`
+	Probe *ProbeConfig `yaml:"probe"`
+}
+
+// ProbeConfig is an HTTP check that must pass while a step is held.
+type ProbeConfig struct {
+	URL          string `yaml:"url"`
+	ExpectStatus int    `yaml:"expect_status"`
+	Interval     string `yaml:"interval"`
+}
+
+// Deploy strategies.
+const (
+	StrategyRolling   = "rolling"
+	StrategyBlueGreen = "blue-green"
+)
+
+// BlueGreenConfig controls how traffic is switched between the two colours.
+type BlueGreenConfig struct {
+	// Switch is "labels" (default) or "port".
+	//   labels: both colours run side by side; ActiveLabels/InactiveLabels are applied
+	//           to the service labels (e.g. for Traefik) to move traffic.
+	//   port:   the new colour starts without published ports; they are moved over
+	//           once the old colour is removed.
+	Switch         string            `yaml:"switch"`
+	ActiveLabels   map[string]string `yaml:"active_labels"`
+	InactiveLabels map[string]string `yaml:"inactive_labels"`
+}
+
+// Deploy drivers.
+const (
+	DriverNative = "native"
+	DriverCLI    = "cli"
+)
+
+// Blue/green traffic switch modes.
+const (
+	SwitchLabels = "labels"
+	SwitchPort   = "port"
+)
+
+// Validate checks the strategy settings.
+func (d DeployConfig) Validate() error {
+	switch d.Driver {
+	case "", DriverNative, DriverCLI:
+	default:
+		return fmt.Errorf("invalid deploy.driver '%s' (expected '%s' or '%s')", d.Driver, DriverNative, DriverCLI)
+	}
+
+	switch d.Strategy {
+	case "", StrategyRolling:
+	case StrategyBlueGreen:
+		if len(d.Canary) > 0 {
+			return fmt.Errorf("deploy.canary cannot be combined with the '%s' strategy", StrategyBlueGreen)
+		}
+		switch d.BlueGreen.Switch {
+		case "", SwitchLabels, SwitchPort:
+		default:
+			return fmt.Errorf("invalid deploy.blue_green.switch '%s' (expected '%s' or '%s')", d.BlueGreen.Switch, SwitchLabels, SwitchPort)
+		}
+	default:
+		return fmt.Errorf("invalid deploy.strategy '%s' (expected '%s' or '%s')", d.Strategy, StrategyRolling, StrategyBlueGreen)
+	}
+
+	for svc, c := range d.Canary {
+		if len(c.Steps) == 0 {
+			return fmt.Errorf("deploy.canary.%s: at least one step is required", svc)
+		}
+		prev := 0
+		for _, step := range c.Steps {
+			if step <= prev || step > 100 {
+				return fmt.Errorf("deploy.canary.%s: steps must be increasing percentages between 1 and 100", svc)
+			}
+			prev = step
+		}
+		if c.Hold != "" {
+			if _, err := time.ParseDuration(c.Hold); err != nil {
+				return fmt.Errorf("deploy.canary.%s: invalid hold '%s': %w", svc, c.Hold, err)
+			}
+		}
+		if c.Probe != nil && c.Probe.Interval != "" {
+			if _, err := time.ParseDuration(c.Probe.Interval); err != nil {
+				return fmt.Errorf("deploy.canary.%s: invalid probe interval '%s': %w", svc, c.Probe.Interval, err)
+			}
+		}
+	}
+	return nil
+}
+
+// WaitTimeout parses Timeout. A zero duration means the default should be used.
+func (d DeployConfig) WaitTimeout() (time.Duration, error) {
+	if d.Timeout == "" {
+		return 0, nil
+	}
+	t, err := time.ParseDuration(d.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid deploy.timeout '%s': %w", d.Timeout, err)
+	}
+	return t, nil
+}
+
+// --- Verify Configuration ---
+
+// VerifyConfig lists the checks run after a successful deploy.
+type VerifyConfig struct {
+	HTTP       []HTTPCheck     `yaml:"http"`
+	Containers []TestContainer `yaml:"containers"`
+}
+
+// HTTPCheck is a request that must return the expected status (and body).
+type HTTPCheck struct {
+	Name         string `yaml:"name"`
+	URL          string `yaml:"url"`
+	ExpectStatus int    `yaml:"expect_status"` // defaults to 200
+	BodyContains string `yaml:"body_contains"`
+	Retries      int    `yaml:"retries"`  // additional attempts after the first failure
+	Interval     string `yaml:"interval"` // delay between attempts (default "5s")
+}
+
+// TestContainer is a one-off container run on the cluster as a Swarm job.
+// It passes if it exits with code 0.
+type TestContainer struct {
+	Name     string            `yaml:"name"`
+	Image    string            `yaml:"image"`
+	Command  []string          `yaml:"command"`
+	Networks []string          `yaml:"networks"` // compose network names of the stack, e.g. "default"
+	Env      map[string]string `yaml:"env"`
+	Timeout  string            `yaml:"timeout"`
+}
+
+// Validate checks the durations and required fields.
+func (v VerifyConfig) Validate() error {
+	for i, c := range v.HTTP {
+		if c.URL == "" {
+			return fmt.Errorf("verify.http[%d]: url is required", i)
+		}
+		if c.Interval != "" {
+			if _, err := time.ParseDuration(c.Interval); err != nil {
+				return fmt.Errorf("verify.http[%d]: invalid interval '%s': %w", i, c.Interval, err)
+			}
+		}
+	}
+	for i, c := range v.Containers {
+		if c.Image == "" {
+			return fmt.Errorf("verify.containers[%d]: image is required", i)
+		}
+		if c.Timeout != "" {
+			if _, err := time.ParseDuration(c.Timeout); err != nil {
+				return fmt.Errorf("verify.containers[%d]: invalid timeout '%s': %w", i, c.Timeout, err)
+			}
+		}
+	}
+	return nil
+}
+
+// --- Migration Configuration ---
+
+// MigrateConfig is a job run on the cluster before the stack is deployed.
+type MigrateConfig struct {
+	Service  string            `yaml:"service"` // compose service whose (new) image and environment are used
+	Command  []string          `yaml:"command"`
+	Networks []string          `yaml:"networks"` // compose network names of the stack (default: "default")
+	Secrets  []string          `yaml:"secrets"`  // compose secret names, mounted at /run/secrets/<name>
+	Env      map[string]string `yaml:"env"`
+	Timeout  string            `yaml:"timeout"`
+}
+
+// Validate checks the required fields and the timeout.
+func (m MigrateConfig) Validate() error {
+	if m.Service == "" {
+		return fmt.Errorf("migrate.service is required")
+	}
+	if m.Timeout != "" {
+		if _, err := time.ParseDuration(m.Timeout); err != nil {
+			return fmt.Errorf("invalid migrate.timeout '%s': %w", m.Timeout, err)
+		}
+	}
+	return nil
+}
+
+// --- Hooks Configuration ---
+
+// HooksConfig lists local shell commands run around the deploy stages.
+type HooksConfig struct {
+	PreBuild   []string `yaml:"pre_build"`
+	PostBuild  []string `yaml:"post_build"`
+	PreDeploy  []string `yaml:"pre_deploy"`
+	PostDeploy []string `yaml:"post_deploy"`
+	OnFailure  []string `yaml:"on_failure"`
+}
+
+// Commands returns the commands configured for a stage (e.g. "pre_build").
+func (h HooksConfig) Commands(stage string) []string {
+	switch stage {
+	case "pre_build":
+		return h.PreBuild
+	case "post_build":
+		return h.PostBuild
+	case "pre_deploy":
+		return h.PreDeploy
+	case "post_deploy":
+		return h.PostDeploy
+	case "on_failure":
+		return h.OnFailure
+	}
+	return nil
+}
+
+// --- Notifications Configuration ---
+
+// NotificationsConfig lists where deploy events are sent. String values may use
+// ${VAR} to reference variables, e.g. for webhook URLs and passwords.
+type NotificationsConfig struct {
+	Webhooks []WebhookNotification `yaml:"webhooks"`
+	Slack    []SlackNotification   `yaml:"slack"`
+	Email    []EmailNotification   `yaml:"email"`
+}
+
+// WebhookNotification POSTs the event as JSON.
+type WebhookNotification struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	Events  []string          `yaml:"events"` // default: all
+}
+
+// SlackNotification posts a message to a Slack-compatible incoming webhook.
+type SlackNotification struct {
+	WebhookURL string   `yaml:"webhook_url"`
+	Channel    string   `yaml:"channel"` // overrides the webhook's default channel
+	Events     []string `yaml:"events"`
+}
+
+// EmailNotification sends a plain-text mail through an SMTP server.
+type EmailNotification struct {
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"` // default 587
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	Events   []string `yaml:"events"`
+}
+
+// Deploy events that can be notified.
+const (
+	EventStarted    = "started"
+	EventSucceeded  = "succeeded"
+	EventFailed     = "failed"
+	EventRolledBack = "rolled_back"
+)
+
+// Validate checks the required fields and event names.
+func (n NotificationsConfig) Validate() error {
+	for i, w := range n.Webhooks {
+		if w.URL == "" {
+			return fmt.Errorf("notifications.webhooks[%d]: url is required", i)
+		}
+		if err := validateEvents(fmt.Sprintf("notifications.webhooks[%d]", i), w.Events); err != nil {
+			return err
+		}
+	}
+	for i, s := range n.Slack {
+		if s.WebhookURL == "" {
+			return fmt.Errorf("notifications.slack[%d]: webhook_url is required", i)
+		}
+		if err := validateEvents(fmt.Sprintf("notifications.slack[%d]", i), s.Events); err != nil {
+			return err
+		}
+	}
+	for i, e := range n.Email {
+		if e.Host == "" || e.From == "" || len(e.To) == 0 {
+			return fmt.Errorf("notifications.email[%d]: host, from and to are required", i)
+		}
+		if err := validateEvents(fmt.Sprintf("notifications.email[%d]", i), e.Events); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateEvents(field string, events []string) error {
+	for _, e := range events {
+		switch e {
+		case EventStarted, EventSucceeded, EventFailed, EventRolledBack:
+		default:
+			return fmt.Errorf("%s: invalid event '%s' (expected %s, %s, %s or %s)", field, e, EventStarted, EventSucceeded, EventFailed, EventRolledBack)
+		}
+	}
+	return nil
 }
 
 // --- Main Config Structure ---
@@ -28,31 +481,80 @@ type DeployConfig struct {
 type Config struct {
 	Project string `yaml:"project"`
 
-	Stack   StackConfig   `yaml:"stack"`
-	Secrets SecretsConfig `yaml:"secrets"`
-	Deploy  DeployConfig  `yaml:"deploy"`
+	Stack   StackConfig    `yaml:"stack"`
+	Secrets SecretsConfig  `yaml:"secrets"`
+	Deploy  DeployConfig   `yaml:"deploy"`
+	Verify  VerifyConfig   `yaml:"verify"`
+	Hooks   HooksConfig    `yaml:"hooks"`
+	Migrate *MigrateConfig `yaml:"migrate"`
+
+	Notifications NotificationsConfig `yaml:"notifications"`
 
 	Variables map[string]string `yaml:"variables"`
 
+	// EnvFiles are dotenv files exported into the environment of every command;
+	// see DotenvFiles.
+	EnvFiles FileList `yaml:"env_files"`
+
 	Environments map[string]Environment `yaml:"environments"`
 }
 
+// DefaultEnvFile is loaded when no env_files are configured. Unlike configured
+// files it may be missing.
+const DefaultEnvFile = ".env"
+
+// DotenvFiles returns the dotenv files to load, in ascending precedence.
+func (c *Config) DotenvFiles() []string {
+	if len(c.EnvFiles) == 0 {
+		return []string{DefaultEnvFile}
+	}
+	return c.EnvFiles
+}
+
 type Environment struct {
 	Stack struct {
-		Name        string `yaml:"name"`
-		ComposeFile string `yaml:"compose_file"`
+		Name string `yaml:"name"`
+		// ComposeFile replaces the top-level files; ComposeOverlays are appended to them
+		ComposeFile     FileList `yaml:"compose_file"`
+		ComposeOverlays []string `yaml:"compose_overlays"`
 	} `yaml:"stack"`
 
 	Secrets struct {
 		StackPrefix string `yaml:"stack_prefix"`
+		File        string `yaml:"file"`
+		// Dirs replaces the top-level directories; Sources are merged per key
+		Dirs    []string                `yaml:"dirs"`
+		Sources map[string]SecretSource `yaml:"sources"`
+		// Vault replaces the top-level server settings when set
+		Vault *VaultConfig `yaml:"vault"`
 	} `yaml:"secrets"`
 
 	Deploy struct {
-		WithSecrets *bool `yaml:"with_secrets"`
-		Prune       *bool `yaml:"prune"`
+		WithSecrets  *bool                   `yaml:"with_secrets"`
+		Prune        *bool                   `yaml:"prune"`
+		Timeout      string                  `yaml:"timeout"`
+		AutoRollback *bool                   `yaml:"auto_rollback"`
+		Strategy     string                  `yaml:"strategy"`
+		BlueGreen    *BlueGreenConfig        `yaml:"blue_green"`
+		Canary       map[string]CanaryConfig `yaml:"canary"`
+		Driver       string                  `yaml:"driver"`
 	} `yaml:"deploy"`
 
+	// Verify replaces the top-level checks when set
+	Verify *VerifyConfig `yaml:"verify"`
+
+	// Each hook stage that is set replaces the top-level commands of that stage
+	Hooks HooksConfig `yaml:"hooks"`
+
+	Migrate *MigrateConfig `yaml:"migrate"`
+
+	// Notifications replaces the top-level targets when set
+	Notifications *NotificationsConfig `yaml:"notifications"`
+
 	Variables map[string]string `yaml:"variables"`
+
+	// EnvFiles are loaded on top of the top-level files
+	EnvFiles FileList `yaml:"env_files"`
 }
 
 func Load(path string) (*Config, error) {
@@ -86,14 +588,37 @@ func (c *Config) MergeWithEnv(envName string) (*Config, error) {
 	if env.Stack.Name != "" {
 		merged.Stack.Name = env.Stack.Name
 	}
-	if env.Stack.ComposeFile != "" {
+	if len(env.Stack.ComposeFile) > 0 {
 		merged.Stack.ComposeFile = env.Stack.ComposeFile
 	}
+	if len(env.Stack.ComposeOverlays) > 0 {
+		files := append([]string{}, merged.Stack.ComposeFiles()...)
+		merged.Stack.ComposeFile = append(files, env.Stack.ComposeOverlays...)
+	}
 
 	// 2. Secrets Overrides
 	if env.Secrets.StackPrefix != "" {
 		merged.Secrets.StackPrefix = env.Secrets.StackPrefix
 	}
+	if env.Secrets.File != "" {
+		merged.Secrets.File = env.Secrets.File
+	}
+	if len(env.Secrets.Dirs) > 0 {
+		merged.Secrets.Dirs = env.Secrets.Dirs
+	}
+	if len(env.Secrets.Sources) > 0 {
+		sources := make(map[string]SecretSource, len(c.Secrets.Sources)+len(env.Secrets.Sources))
+		for k, v := range c.Secrets.Sources {
+			sources[k] = v
+		}
+		for k, v := range env.Secrets.Sources {
+			sources[k] = v
+		}
+		merged.Secrets.Sources = sources
+	}
+	if env.Secrets.Vault != nil {
+		merged.Secrets.Vault = *env.Secrets.Vault
+	}
 
 	// 3. Deploy Overrides
 	if env.Deploy.WithSecrets != nil {
@@ -102,8 +627,64 @@ func (c *Config) MergeWithEnv(envName string) (*Config, error) {
 	if env.Deploy.Prune != nil {
 		merged.Deploy.Prune = *env.Deploy.Prune
 	}
+	if env.Deploy.Timeout != "" {
+		merged.Deploy.Timeout = env.Deploy.Timeout
+	}
+	if env.Deploy.AutoRollback != nil {
+		merged.Deploy.AutoRollback = *env.Deploy.AutoRollback
+	}
+	if env.Deploy.Strategy != "" {
+		merged.Deploy.Strategy = env.Deploy.Strategy
+	}
+	if env.Deploy.BlueGreen != nil {
+		merged.Deploy.BlueGreen = *env.Deploy.BlueGreen
+	}
+	if env.Deploy.Canary != nil {
+		merged.Deploy.Canary = env.Deploy.Canary
+	}
+	if env.Deploy.Driver != "" {
+		merged.Deploy.Driver = env.Deploy.Driver
+	}
+
+	// 4. Verify Overrides
+	if env.Verify != nil {
+		merged.Verify = *env.Verify
+	}
+
+	// 5. Hooks Overrides
+	if env.Hooks.PreBuild != nil {
+		merged.Hooks.PreBuild = env.Hooks.PreBuild
+	}
+	if env.Hooks.PostBuild != nil {
+		merged.Hooks.PostBuild = env.Hooks.PostBuild
+	}
+	if env.Hooks.PreDeploy != nil {
+		merged.Hooks.PreDeploy = env.Hooks.PreDeploy
+	}
+	if env.Hooks.PostDeploy != nil {
+		merged.Hooks.PostDeploy = env.Hooks.PostDeploy
+	}
+	if env.Hooks.OnFailure != nil {
+		merged.Hooks.OnFailure = env.Hooks.OnFailure
+	}
+
+	// 6. Migration Overrides
+	if env.Migrate != nil {
+		merged.Migrate = env.Migrate
+	}
+
+	// 7. Notification Overrides
+	if env.Notifications != nil {
+		merged.Notifications = *env.Notifications
+	}
+
+	// 8. Env Files (appended: the environment's values win)
+	if len(env.EnvFiles) > 0 {
+		files := append([]string{}, c.DotenvFiles()...)
+		merged.EnvFiles = append(files, env.EnvFiles...)
+	}
 
-	// 4. Variables Merge
+	// 9. Variables Merge
 	for k, v := range env.Variables {
 		merged.Variables[k] = v
 	}
@@ -0,0 +1,325 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/rollwave-dev/rollwave/internal/bluegreen"
+	"github.com/rollwave-dev/rollwave/internal/canary"
+	"github.com/rollwave-dev/rollwave/internal/compose"
+	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/hooks"
+	"github.com/rollwave-dev/rollwave/internal/migrate"
+	"github.com/rollwave-dev/rollwave/internal/notify"
+	"github.com/rollwave-dev/rollwave/internal/output"
+	"github.com/rollwave-dev/rollwave/internal/pipeline"
+	"github.com/rollwave-dev/rollwave/internal/release"
+	"github.com/rollwave-dev/rollwave/internal/rollback"
+	"github.com/rollwave-dev/rollwave/internal/stack"
+	"github.com/rollwave-dev/rollwave/internal/verify"
+)
+
+// Options defines how a rendered compose file is rolled out.
+type Options struct {
+	Config      *config.Config // merged with the environment
+	Environment string
+	Services    []string // only these services are deployed; all if empty
+
+	DryRun       bool
+	Detach       bool
+	AutoRollback bool
+	SkipVerify   bool
+	Timeout      time.Duration
+
+	Output *output.Printer
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Deployment runs everything 'deploy' and 'apply' have in common: lifecycle
+// hooks, notifications, the event stream, migrations, the deploy strategy and
+// the post-deploy checks. Begin it before the first step, run Rollout with the
+// rendered compose file and End it with the outcome.
+type Deployment struct {
+	Hooks *hooks.Runner
+
+	cfg      *config.Config
+	opt      Options
+	ev       *events
+	notifier *notify.Notifier
+	notice   notify.Event
+	rel      *release.Release
+}
+
+// Validate checks the parts of the configuration a deployment uses.
+func Validate(cfg *config.Config) error {
+	if err := cfg.Deploy.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Verify.Validate(); err != nil {
+		return err
+	}
+	if cfg.Migrate != nil {
+		if err := cfg.Migrate.Validate(); err != nil {
+			return err
+		}
+	}
+	return cfg.Notifications.Validate()
+}
+
+// Begin starts a deployment and sends the 'started' notification.
+func Begin(opt Options) *Deployment {
+	cfg := opt.Config
+	d := &Deployment{
+		Hooks: hooks.NewRunner(cfg, opt.Environment, opt.DryRun, opt.Stdout, opt.Stderr),
+		cfg:   cfg,
+		opt:   opt,
+		ev:    &events{out: opt.Output},
+	}
+
+	// Notifications are best effort: a failed delivery never fails the deploy
+	if !opt.DryRun {
+		d.notifier = notify.New(cfg.Notifications, cfg.Variables, opt.Stderr)
+		d.notice = notify.Event{
+			Project:     cfg.Project,
+			Environment: opt.Environment,
+			Stack:       cfg.Stack.Name,
+			GitSHA:      release.GitSHA(),
+			Deployer:    release.Deployer(),
+		}
+		started := d.notice
+		started.Type = config.EventStarted
+		d.notifier.Send(started)
+	}
+	return d
+}
+
+// End reports the outcome: it runs the on_failure hooks for an error, sends
+// the final notification and ends the event stream.
+func (d *Deployment) End(err error) {
+	if err != nil {
+		d.Hooks.Fail(err)
+	}
+
+	if d.notifier != nil {
+		e := d.notice
+		if d.rel != nil {
+			e.Release = d.rel.ID
+		}
+		switch {
+		case err == nil:
+			e.Type = config.EventSucceeded
+		case d.rel != nil && d.rel.Status == release.StatusRolledBack:
+			e.Type, e.Error = config.EventRolledBack, err.Error()
+		default:
+			e.Type, e.Error = config.EventFailed, err.Error()
+		}
+		d.notifier.Send(e)
+	}
+
+	d.ev.done(d.cfg.Stack.Name, d.rel, d.opt.DryRun, err)
+}
+
+// Start reports the beginning of a stage in the event stream.
+func (d *Deployment) Start(stage string) { d.ev.start(stage) }
+
+// Finish reports the end of the stage in progress.
+func (d *Deployment) Finish() { d.ev.finish() }
+
+// Emit writes an event to the event stream.
+func (d *Deployment) Emit(event string, data EventData) { d.ev.emit(event, data) }
+
+// Rollout deploys the rendered compose file with the configured strategy: it
+// runs the pre_deploy hooks and migrations, deploys (blue/green, canary or in
+// place), verifies the result and runs the post_deploy hooks. cli may be nil
+// for dry runs.
+func (d *Deployment) Rollout(ctx context.Context, cli engine.Engine, composeYaml []byte, secretMap map[string]string) error {
+	cfg, opt := d.cfg, d.opt
+
+	if err := d.Hooks.Run(ctx, hooks.PreDeploy); err != nil {
+		return err
+	}
+
+	if opt.DryRun {
+		return d.dryRun(ctx, composeYaml)
+	}
+
+	if images, err := compose.ServiceImages(composeYaml); err == nil {
+		d.notice.Images = images
+	}
+
+	p := pipeline.Options{
+		Stack:        cfg.Stack.Name,
+		Environment:  opt.Environment,
+		Compose:      composeYaml,
+		Secrets:      secretMap,
+		Variables:    cfg.Variables,
+		AutoRollback: opt.AutoRollback,
+		Services:     opt.Services,
+		Detach:       opt.Detach,
+		Timeout:      opt.Timeout,
+		Prune:        cfg.Deploy.Prune,
+		SecretPrefix: cfg.Secrets.StackPrefix,
+		Driver:       cfg.Deploy.Driver,
+		Stdout:       opt.Stdout,
+		Stderr:       opt.Stderr,
+	}
+
+	// Migrations run against the new image before any service is updated
+	if cfg.Migrate != nil {
+		d.Start(StageMigrate)
+		migrateStack, err := bluegreen.LiveStack(ctx, cli, cfg)
+		if err != nil {
+			return err
+		}
+		if err := migrate.Run(ctx, cli, *cfg.Migrate, migrate.Options{
+			Stack:     migrateStack,
+			Compose:   composeYaml,
+			Variables: cfg.Variables,
+			Stdout:    opt.Stdout,
+			Stderr:    opt.Stderr,
+		}); err != nil {
+			return err
+		}
+		d.Finish()
+	}
+
+	d.Start(StageDeploy)
+	detached := opt.Detach
+	if cfg.Deploy.Strategy == config.StrategyBlueGreen && opt.Detach {
+		fmt.Fprintln(opt.Stderr, "⚠️  --detach is ignored for blue/green deploys: traffic is only switched after the new colour converges.")
+		detached = false
+	}
+	// The release is only recorded as succeeded once verification passed
+	p.Verify = d.verifier(cli, detached)
+
+	if cfg.Deploy.Strategy == config.StrategyBlueGreen {
+		if err := bluegreen.Deploy(ctx, cli, cfg.Deploy.BlueGreen, p); err != nil {
+			return err
+		}
+		d.Emit(EventStackDeployed, EventData{Stack: cfg.Stack.Name})
+	} else {
+		if canaries := selectCanaries(cfg.Deploy.Canary, opt.Services); len(canaries) > 0 {
+			// Snapshot the stack before the canaries touch it, so an auto-rollback
+			// restores the state from before this deploy
+			if opt.AutoRollback {
+				snapshot, err := rollback.Capture(ctx, cli, cfg.Stack.Name)
+				if err != nil {
+					return fmt.Errorf("capture pre-deploy state: %w", err)
+				}
+				p.Snapshot = snapshot
+			}
+			if err := canary.Run(ctx, cli, canaries, canary.Options{
+				Stack:   cfg.Stack.Name,
+				Compose: composeYaml,
+				Timeout: opt.Timeout,
+				Stdout:  opt.Stdout,
+				Stderr:  opt.Stderr,
+			}); err != nil {
+				return err
+			}
+		}
+
+		rel, err := pipeline.Deploy(ctx, cli, p)
+		d.rel = rel
+		if err != nil {
+			return err
+		}
+		d.Emit(EventStackDeployed, EventData{Stack: cfg.Stack.Name, Release: rel.ID, Changes: rel.Changes})
+	}
+	d.Finish()
+
+	return d.Hooks.Run(ctx, hooks.PostDeploy)
+}
+
+// dryRun prints what Rollout would do without touching the Swarm.
+func (d *Deployment) dryRun(ctx context.Context, composeYaml []byte) error {
+	cfg, opt := d.cfg, d.opt
+
+	if cfg.Migrate != nil {
+		d.Start(StageMigrate)
+		if err := migrate.Run(ctx, nil, *cfg.Migrate, migrate.Options{
+			Stack:     cfg.Stack.Name,
+			Compose:   composeYaml,
+			Variables: cfg.Variables,
+			DryRun:    true,
+			Stdout:    opt.Stdout,
+		}); err != nil {
+			return err
+		}
+		d.Finish()
+	}
+
+	d.Start(StageDeploy)
+	if _, err := stack.Deploy(ctx, stack.DeployOptions{
+		Name:     cfg.Stack.Name,
+		Compose:  composeYaml,
+		Services: opt.Services,
+		DryRun:   true,
+		Driver:   cfg.Deploy.Driver,
+		Stdout:   opt.Stdout,
+		Stderr:   opt.Stderr,
+	}); err != nil {
+		return err
+	}
+	if err := d.Hooks.Run(ctx, hooks.PostDeploy); err != nil {
+		return err
+	}
+	d.Finish()
+	return nil
+}
+
+// verifier returns the verification run once the stack converged, or nil if
+// there is nothing to verify or it is skipped.
+func (d *Deployment) verifier(cli engine.Swarm, detached bool) func(context.Context) error {
+	cfg, opt := d.cfg, d.opt
+	if len(cfg.Verify.HTTP) == 0 && len(cfg.Verify.Containers) == 0 {
+		return nil
+	}
+	switch {
+	case opt.SkipVerify:
+		fmt.Fprintln(opt.Stdout, "⏭️  Skipping verification (--skip-verify).")
+		return nil
+	case detached:
+		fmt.Fprintln(opt.Stderr, "⚠️  Skipping verification: the deploy was detached and may not have converged yet.")
+		return nil
+	}
+
+	return func(ctx context.Context) error {
+		// The deploy stage ends once the stack converged; Rollout finishes this one
+		d.Finish()
+		d.Start(StageVerify)
+		stackName, err := bluegreen.LiveStack(ctx, cli, cfg)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(opt.Stdout, "")
+		_, err = verify.Run(ctx, cli, cfg.Verify, verify.Options{
+			Stack:     stackName,
+			Variables: cfg.Variables,
+			Stdout:    opt.Stdout,
+			Stderr:    opt.Stderr,
+		})
+		return err
+	}
+}
+
+// selectCanaries returns the canary settings of the selected services, or all of
+// them if no services were selected.
+func selectCanaries(canaries map[string]config.CanaryConfig, selected []string) map[string]config.CanaryConfig {
+	if len(selected) == 0 {
+		return canaries
+	}
+	out := make(map[string]config.CanaryConfig)
+	for name, c := range canaries {
+		if slices.Contains(selected, name) {
+			out[name] = c
+		}
+	}
+	return out
+}
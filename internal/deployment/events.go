@@ -0,0 +1,74 @@
+package deployment
+
+import (
+	"github.com/rollwave-dev/rollwave/internal/output"
+	"github.com/rollwave-dev/rollwave/internal/release"
+)
+
+// Events of the deploy event stream written with --output json or yaml.
+const (
+	EventStageStarted  = "stage_started"
+	EventStageFinished = "stage_finished"
+	EventImageBuilt    = "image_built"
+	EventSecretCreated = "secret_created"
+	EventStackDeployed = "stack_deployed"
+	EventSucceeded     = "deploy_succeeded"
+	EventFailed        = "deploy_failed"
+)
+
+// Stages of a deploy.
+const (
+	StageBuild   = "build"
+	StageSecrets = "secrets"
+	StageMigrate = "migrate"
+	StageDeploy  = "deploy"
+	StageVerify  = "verify"
+)
+
+// EventData is the payload of an event; only the fields relevant to the event are set.
+type EventData struct {
+	Stage   string           `json:"stage,omitempty"`
+	Service string           `json:"service,omitempty"`
+	Image   string           `json:"image,omitempty"`
+	Secret  string           `json:"secret,omitempty"` // key as used in the compose file
+	Name    string           `json:"name,omitempty"`   // secret name in the Swarm
+	Stack   string           `json:"stack,omitempty"`
+	Release int              `json:"release,omitempty"`
+	Changes []release.Change `json:"changes,omitempty"`
+	DryRun  bool             `json:"dry_run,omitempty"`
+	Error   string           `json:"error,omitempty"`
+}
+
+// events tracks the stage in progress so a failure can be attributed to it.
+type events struct {
+	out   *output.Printer
+	stage string
+}
+
+func (e *events) start(stage string) {
+	e.stage = stage
+	e.out.Emit(EventStageStarted, EventData{Stage: stage})
+}
+
+func (e *events) finish() {
+	e.out.Emit(EventStageFinished, EventData{Stage: e.stage})
+	e.stage = ""
+}
+
+func (e *events) emit(event string, data EventData) {
+	e.out.Emit(event, data)
+}
+
+// done ends the stream with the outcome of the deploy.
+func (e *events) done(stack string, rel *release.Release, dryRun bool, err error) {
+	data := EventData{Stack: stack, DryRun: dryRun}
+	if rel != nil {
+		data.Release = rel.ID
+	}
+	if err != nil {
+		data.Stage, data.Error = e.stage, err.Error()
+		e.out.Emit(EventFailed, data)
+		return
+	}
+	e.out.Emit(EventSucceeded, data)
+}
@@ -0,0 +1,496 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/registry"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// GeneratedComposeFile is the name of the temporary file handed to 'docker
+// stack deploy'. It holds the values of all variables, so it is only readable
+// by the user and lives in its own temporary directory; relative paths must
+// already be resolved.
+const GeneratedComposeFile = "docker-compose.rollwave.generated.yml"
+
+// CLI runs the docker binary. Swarm objects are read with 'docker ... inspect',
+// which prints the same JSON as the API. Operations the CLI cannot express from
+// a spec (service create and update, config update) return an error wrapping
+// errors.ErrUnsupported; NewFor refuses configurations that need them.
+type CLI struct {
+	Binary string // defaults to "docker"
+}
+
+// NewCLI returns a CLI engine using the docker binary from PATH.
+func NewCLI() *CLI {
+	return &CLI{Binary: "docker"}
+}
+
+// Close does nothing; the CLI engine holds no connection.
+func (c *CLI) Close() error {
+	return nil
+}
+
+// --- Images ---
+
+// Build runs 'docker build'.
+func (c *CLI) Build(ctx context.Context, opt BuildOptions) error {
+	args := []string{"build"}
+	for _, t := range opt.Tags {
+		args = append(args, "-t", t)
+	}
+	if opt.Dockerfile != "" {
+		args = append(args, "-f", opt.Dockerfile)
+	}
+	args = append(args, opt.ContextDir)
+
+	if err := c.run(ctx, nil, opt.Stdout, opt.Stderr, args...); err != nil {
+		return fmt.Errorf("docker build failed: %w", err)
+	}
+	return nil
+}
+
+// Push runs 'docker push'.
+func (c *CLI) Push(ctx context.Context, image string, stdout, stderr io.Writer) error {
+	if err := c.run(ctx, nil, stdout, stderr, "push", image); err != nil {
+		return fmt.Errorf("docker push %s: %w", image, err)
+	}
+	return nil
+}
+
+// Login runs 'docker login' with the password on stdin.
+func (c *CLI) Login(ctx context.Context, opt LoginOptions) error {
+	args := []string{"login", "-u", opt.Username, "--password-stdin"}
+	if opt.Registry != "" {
+		args = append(args, opt.Registry)
+	}
+	if err := c.run(ctx, strings.NewReader(opt.Password), opt.Stdout, opt.Stderr, args...); err != nil {
+		return fmt.Errorf("docker login failed: %w", err)
+	}
+	return nil
+}
+
+// --- Stacks ---
+
+// StackDeploy writes the compose file to the working directory and runs 'docker stack deploy'.
+func (c *CLI) StackDeploy(ctx context.Context, opt StackDeployOptions) error {
+	dir, err := os.MkdirTemp("", "rollwave-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, GeneratedComposeFile)
+	if err := os.WriteFile(file, opt.Compose, 0600); err != nil {
+		return err
+	}
+
+	args := []string{"stack", "deploy", "--compose-file", file, "--with-registry-auth"}
+	if opt.Prune {
+		args = append(args, "--prune")
+	}
+	cmd := exec.CommandContext(ctx, c.binary(), append(args, opt.Name)...)
+	cmd.Stdout = opt.Stdout
+	cmd.Stderr = opt.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("docker stack deploy: %w", err)
+	}
+	return nil
+}
+
+// StackRemove runs 'docker stack rm'.
+func (c *CLI) StackRemove(ctx context.Context, name string, stdout, stderr io.Writer) error {
+	if err := c.run(ctx, nil, stdout, stderr, "stack", "rm", name); err != nil {
+		return fmt.Errorf("docker stack rm: %w", err)
+	}
+	return nil
+}
+
+// --- Services and tasks ---
+
+// ServiceList runs 'docker service ls' and inspects the services found.
+func (c *CLI) ServiceList(ctx context.Context, options types.ServiceListOptions) ([]dockerswarm.Service, error) {
+	var out []dockerswarm.Service
+	return out, c.list(ctx, "service", options.Filters, &out)
+}
+
+// ServiceInspectWithRaw runs 'docker service inspect'.
+func (c *CLI) ServiceInspectWithRaw(ctx context.Context, serviceID string, _ types.ServiceInspectOptions) (dockerswarm.Service, []byte, error) {
+	var out dockerswarm.Service
+	raw, err := c.inspectOne(ctx, []string{"service", "inspect", serviceID}, &out)
+	return out, raw, err
+}
+
+// ServiceCreate is not supported by the CLI engine.
+func (c *CLI) ServiceCreate(context.Context, dockerswarm.ServiceSpec, types.ServiceCreateOptions) (dockerswarm.ServiceCreateResponse, error) {
+	return dockerswarm.ServiceCreateResponse{}, unsupported("service create")
+}
+
+// ServiceUpdate is not supported by the CLI engine.
+func (c *CLI) ServiceUpdate(context.Context, string, dockerswarm.Version, dockerswarm.ServiceSpec, types.ServiceUpdateOptions) (dockerswarm.ServiceUpdateResponse, error) {
+	return dockerswarm.ServiceUpdateResponse{}, unsupported("service update")
+}
+
+// ServiceRemove runs 'docker service rm'.
+func (c *CLI) ServiceRemove(ctx context.Context, serviceID string) error {
+	_, err := c.output(ctx, nil, "service", "rm", serviceID)
+	return err
+}
+
+// ServiceLogs runs 'docker service logs'. Its stdout and stderr are multiplexed
+// into one stream like the API returns, so stdcopy.StdCopy splits them again.
+func (c *CLI) ServiceLogs(ctx context.Context, serviceID string, options container.LogsOptions) (io.ReadCloser, error) {
+	args := []string{"service", "logs", "--raw", "--no-trunc"}
+	if options.Follow {
+		args = append(args, "--follow")
+	}
+	if options.Timestamps {
+		args = append(args, "--timestamps")
+	}
+	if options.Details {
+		args = append(args, "--details")
+	}
+	if options.Since != "" {
+		args = append(args, "--since", options.Since)
+	}
+	if options.Tail != "" {
+		args = append(args, "--tail", options.Tail)
+	}
+
+	pr, pw := io.Pipe()
+	cmd := exec.CommandContext(ctx, c.binary(), append(args, serviceID)...)
+	cmd.Stdout, cmd.Stderr = io.Discard, io.Discard
+	if options.ShowStdout {
+		cmd.Stdout = stdcopy.NewStdWriter(pw, stdcopy.Stdout)
+	}
+	if options.ShowStderr {
+		cmd.Stderr = stdcopy.NewStdWriter(pw, stdcopy.Stderr)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("docker service logs: %w", err)
+	}
+	go func() {
+		pw.CloseWithError(cmd.Wait())
+	}()
+	return &logReader{PipeReader: pr, cmd: cmd}, nil
+}
+
+// TaskList runs 'docker service ps' for the services selected by the "service"
+// filter, or by the "label" filter when no service is given.
+func (c *CLI) TaskList(ctx context.Context, options types.TaskListOptions) ([]dockerswarm.Task, error) {
+	f := options.Filters
+	services := f.Get("service")
+	if len(services) == 0 {
+		sf := filters.NewArgs()
+		for _, l := range f.Get("label") {
+			sf.Add("label", l)
+		}
+		list, err := c.ServiceList(ctx, types.ServiceListOptions{Filters: sf})
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range list {
+			services = append(services, s.ID)
+		}
+	}
+	if len(services) == 0 {
+		return nil, nil
+	}
+
+	args := []string{"service", "ps", "-q", "--no-trunc"}
+	for _, key := range []string{"desired-state", "id", "name", "node"} {
+		for _, v := range f.Get(key) {
+			args = append(args, "--filter", key+"="+v)
+		}
+	}
+	ids, err := c.lines(ctx, append(args, services...)...)
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	var out []dockerswarm.Task
+	return out, c.inspect(ctx, []string{"inspect", "--type", "task"}, ids, &out)
+}
+
+// --- Secrets ---
+
+// SecretList runs 'docker secret ls' and inspects the secrets found.
+func (c *CLI) SecretList(ctx context.Context, options types.SecretListOptions) ([]dockerswarm.Secret, error) {
+	var out []dockerswarm.Secret
+	return out, c.list(ctx, "secret", options.Filters, &out)
+}
+
+// SecretCreate runs 'docker secret create' with the data on stdin.
+func (c *CLI) SecretCreate(ctx context.Context, secret dockerswarm.SecretSpec) (types.SecretCreateResponse, error) {
+	id, err := c.create(ctx, "secret", secret.Name, secret.Labels, secret.Data)
+	return types.SecretCreateResponse{ID: id}, err
+}
+
+// SecretRemove runs 'docker secret rm'.
+func (c *CLI) SecretRemove(ctx context.Context, id string) error {
+	_, err := c.output(ctx, nil, "secret", "rm", id)
+	return err
+}
+
+// --- Configs ---
+
+// ConfigList runs 'docker config ls' and inspects the configs found.
+func (c *CLI) ConfigList(ctx context.Context, options types.ConfigListOptions) ([]dockerswarm.Config, error) {
+	var out []dockerswarm.Config
+	return out, c.list(ctx, "config", options.Filters, &out)
+}
+
+// ConfigInspectWithRaw runs 'docker config inspect'.
+func (c *CLI) ConfigInspectWithRaw(ctx context.Context, name string) (dockerswarm.Config, []byte, error) {
+	var out dockerswarm.Config
+	raw, err := c.inspectOne(ctx, []string{"config", "inspect", name}, &out)
+	return out, raw, err
+}
+
+// ConfigCreate runs 'docker config create' with the data on stdin.
+func (c *CLI) ConfigCreate(ctx context.Context, config dockerswarm.ConfigSpec) (types.ConfigCreateResponse, error) {
+	id, err := c.create(ctx, "config", config.Name, config.Labels, config.Data)
+	return types.ConfigCreateResponse{ID: id}, err
+}
+
+// ConfigUpdate is not supported by the CLI engine.
+func (c *CLI) ConfigUpdate(context.Context, string, dockerswarm.Version, dockerswarm.ConfigSpec) error {
+	return unsupported("config update")
+}
+
+// ConfigRemove runs 'docker config rm'.
+func (c *CLI) ConfigRemove(ctx context.Context, id string) error {
+	_, err := c.output(ctx, nil, "config", "rm", id)
+	return err
+}
+
+// --- Networks and images ---
+
+// NetworkInspect runs 'docker network inspect'.
+func (c *CLI) NetworkInspect(ctx context.Context, network string, _ types.NetworkInspectOptions) (types.NetworkResource, error) {
+	var out types.NetworkResource
+	_, err := c.inspectOne(ctx, []string{"network", "inspect", network}, &out)
+	return out, err
+}
+
+// NetworkCreate runs 'docker network create'.
+func (c *CLI) NetworkCreate(ctx context.Context, name string, options types.NetworkCreate) (types.NetworkCreateResponse, error) {
+	args := []string{"network", "create"}
+	if options.Driver != "" {
+		args = append(args, "--driver", options.Driver)
+	}
+	if options.Scope != "" {
+		args = append(args, "--scope", options.Scope)
+	}
+	if options.Attachable {
+		args = append(args, "--attachable")
+	}
+	if options.Internal {
+		args = append(args, "--internal")
+	}
+	for _, k := range sortedKeys(options.Labels) {
+		args = append(args, "--label", k+"="+options.Labels[k])
+	}
+	for _, k := range sortedKeys(options.Options) {
+		args = append(args, "--opt", k+"="+options.Options[k])
+	}
+	if options.IPAM != nil {
+		if options.IPAM.Driver != "" {
+			args = append(args, "--ipam-driver", options.IPAM.Driver)
+		}
+		for _, cfg := range options.IPAM.Config {
+			if cfg.Subnet != "" {
+				args = append(args, "--subnet", cfg.Subnet)
+			}
+		}
+	}
+
+	out, err := c.output(ctx, nil, append(args, name)...)
+	if err != nil {
+		return types.NetworkCreateResponse{}, err
+	}
+	return types.NetworkCreateResponse{ID: strings.TrimSpace(string(out))}, nil
+}
+
+// DistributionInspect runs 'docker buildx imagetools inspect' to resolve the
+// digest of an image in its registry. It uses the credentials stored by Login;
+// encodedRegistryAuth is ignored.
+func (c *CLI) DistributionInspect(ctx context.Context, image, _ string) (registry.DistributionInspect, error) {
+	var out registry.DistributionInspect
+	raw, err := c.output(ctx, nil, "buildx", "imagetools", "inspect", "--format", "{{json .Manifest}}", image)
+	if err != nil {
+		return out, err
+	}
+	if err := json.Unmarshal(raw, &out.Descriptor); err != nil {
+		return out, fmt.Errorf("parse docker buildx imagetools output: %w", err)
+	}
+	return out, nil
+}
+
+// --- Helpers ---
+
+func (c *CLI) binary() string {
+	if c.Binary == "" {
+		return "docker"
+	}
+	return c.Binary
+}
+
+// run streams the output of a docker command.
+func (c *CLI) run(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, args ...string) error {
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	cmd := exec.CommandContext(ctx, c.binary(), args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
+
+// output returns the stdout of a docker command. Its stderr becomes the error,
+// typed like API errors so errdefs.IsNotFound and errdefs.IsConflict work.
+func (c *CLI) output(ctx context.Context, stdin io.Reader, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.binary(), args...)
+	cmd.Stdin = stdin
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err == nil {
+		return out, nil
+	}
+
+	msg := strings.TrimSpace(stderr.String())
+	if msg == "" {
+		msg = err.Error()
+	}
+	name := args
+	if len(name) > 2 {
+		name = name[:2]
+	}
+	e := fmt.Errorf("docker %s: %s", strings.Join(name, " "), msg)
+	switch {
+	case strings.Contains(msg, "No such"), strings.Contains(msg, "not found"):
+		return nil, errdefs.NotFound(e)
+	case strings.Contains(msg, "already exists"):
+		return nil, errdefs.Conflict(e)
+	}
+	return nil, e
+}
+
+// lines returns the non-empty output lines of a docker command.
+func (c *CLI) lines(ctx context.Context, args ...string) ([]string, error) {
+	out, err := c.output(ctx, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, l := range strings.Split(string(out), "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines, nil
+}
+
+// list inspects the objects printed by 'docker <object> ls -q'.
+func (c *CLI) list(ctx context.Context, object string, f filters.Args, out interface{}) error {
+	args := []string{object, "ls", "-q"}
+	keys := f.Keys()
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, v := range f.Get(key) {
+			args = append(args, "--filter", key+"="+v)
+		}
+	}
+
+	ids, err := c.lines(ctx, args...)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	return c.inspect(ctx, []string{object, "inspect"}, ids, out)
+}
+
+func (c *CLI) inspect(ctx context.Context, command, ids []string, out interface{}) error {
+	raw, err := c.output(ctx, nil, append(command, ids...)...)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("parse docker %s output: %w", strings.Join(command, " "), err)
+	}
+	return nil
+}
+
+// inspectOne decodes the single object printed by an inspect command and returns its raw JSON.
+func (c *CLI) inspectOne(ctx context.Context, args []string, out interface{}) ([]byte, error) {
+	var list []json.RawMessage
+	if err := c.inspect(ctx, args[:len(args)-1], args[len(args)-1:], &list); err != nil {
+		return nil, err
+	}
+	if len(list) != 1 {
+		return nil, fmt.Errorf("docker %s: expected one object, got %d", strings.Join(args[:2], " "), len(list))
+	}
+	if err := json.Unmarshal(list[0], out); err != nil {
+		return nil, err
+	}
+	return list[0], nil
+}
+
+// create runs 'docker secret create' or 'docker config create' and returns the new ID.
+func (c *CLI) create(ctx context.Context, object, name string, labels map[string]string, data []byte) (string, error) {
+	args := []string{object, "create"}
+	for _, k := range sortedKeys(labels) {
+		args = append(args, "--label", k+"="+labels[k])
+	}
+	args = append(args, name, "-")
+
+	out, err := c.output(ctx, bytes.NewReader(data), args...)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// logReader stops 'docker service logs' when the stream is closed.
+type logReader struct {
+	*io.PipeReader
+	cmd *exec.Cmd
+}
+
+func (r *logReader) Close() error {
+	_ = r.cmd.Process.Kill()
+	return r.PipeReader.Close()
+}
+
+func unsupported(op string) error {
+	return fmt.Errorf("%s with the CLI engine (set ROLLWAVE_ENGINE=%s): %w", op, KindSDK, errors.ErrUnsupported)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
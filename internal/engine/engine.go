@@ -0,0 +1,157 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/registry"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+
+	"github.com/rollwave-dev/rollwave/internal/config"
+)
+
+// Engine is everything Rollwave needs from Docker. Packages take the narrowest
+// part they use (Swarm, Images or Stacks) so they can be exercised against Fake.
+type Engine interface {
+	Swarm
+	Images
+	Stacks
+
+	Close() error
+}
+
+var (
+	_ Engine = (*SDK)(nil)
+	_ Engine = (*CLI)(nil)
+	_ Engine = (*Fake)(nil)
+)
+
+// Swarm covers services, tasks, secrets, configs and networks. The method set
+// matches the Docker SDK client, so *client.Client implements it as is.
+type Swarm interface {
+	ServiceList(ctx context.Context, options types.ServiceListOptions) ([]dockerswarm.Service, error)
+	ServiceInspectWithRaw(ctx context.Context, serviceID string, options types.ServiceInspectOptions) (dockerswarm.Service, []byte, error)
+	ServiceCreate(ctx context.Context, service dockerswarm.ServiceSpec, options types.ServiceCreateOptions) (dockerswarm.ServiceCreateResponse, error)
+	ServiceUpdate(ctx context.Context, serviceID string, version dockerswarm.Version, service dockerswarm.ServiceSpec, options types.ServiceUpdateOptions) (dockerswarm.ServiceUpdateResponse, error)
+	ServiceRemove(ctx context.Context, serviceID string) error
+	ServiceLogs(ctx context.Context, serviceID string, options container.LogsOptions) (io.ReadCloser, error)
+
+	TaskList(ctx context.Context, options types.TaskListOptions) ([]dockerswarm.Task, error)
+
+	SecretList(ctx context.Context, options types.SecretListOptions) ([]dockerswarm.Secret, error)
+	SecretCreate(ctx context.Context, secret dockerswarm.SecretSpec) (types.SecretCreateResponse, error)
+	SecretRemove(ctx context.Context, id string) error
+
+	ConfigList(ctx context.Context, options types.ConfigListOptions) ([]dockerswarm.Config, error)
+	ConfigInspectWithRaw(ctx context.Context, name string) (dockerswarm.Config, []byte, error)
+	ConfigCreate(ctx context.Context, config dockerswarm.ConfigSpec) (types.ConfigCreateResponse, error)
+	ConfigUpdate(ctx context.Context, id string, version dockerswarm.Version, config dockerswarm.ConfigSpec) error
+	ConfigRemove(ctx context.Context, id string) error
+
+	NetworkInspect(ctx context.Context, network string, options types.NetworkInspectOptions) (types.NetworkResource, error)
+	NetworkCreate(ctx context.Context, name string, options types.NetworkCreate) (types.NetworkCreateResponse, error)
+
+	DistributionInspect(ctx context.Context, image, encodedRegistryAuth string) (registry.DistributionInspect, error)
+}
+
+// Images builds and publishes images.
+type Images interface {
+	Build(ctx context.Context, opt BuildOptions) error
+	Push(ctx context.Context, image string, stdout, stderr io.Writer) error
+	// Login stores registry credentials for later pushes and 'docker stack deploy'.
+	Login(ctx context.Context, opt LoginOptions) error
+}
+
+// Stacks runs the 'docker stack' operations.
+type Stacks interface {
+	StackDeploy(ctx context.Context, opt StackDeployOptions) error
+	StackRemove(ctx context.Context, name string, stdout, stderr io.Writer) error
+}
+
+// BuildOptions defines an image build.
+type BuildOptions struct {
+	Tags       []string
+	Dockerfile string
+	ContextDir string
+	Stdout     io.Writer
+	Stderr     io.Writer
+}
+
+// LoginOptions defines the credentials for a registry. An empty Registry means Docker Hub.
+type LoginOptions struct {
+	Registry string
+	Username string
+	Password string
+	Stdout   io.Writer
+	Stderr   io.Writer
+}
+
+// StackDeployOptions defines a 'docker stack deploy' run.
+type StackDeployOptions struct {
+	Name    string
+	Compose []byte
+	Prune   bool // remove the services of the stack that are not in Compose
+	Stdout  io.Writer
+	Stderr  io.Writer
+}
+
+// Engine implementations selectable through ROLLWAVE_ENGINE.
+const (
+	KindSDK = "sdk"
+	KindCLI = "cli"
+)
+
+// New returns the engine selected by ROLLWAVE_ENGINE: "sdk" (default) talks to
+// the Docker API, "cli" runs the docker binary for everything.
+func New() (Engine, error) {
+	switch kind := os.Getenv("ROLLWAVE_ENGINE"); kind {
+	case "", KindSDK:
+		return NewSDK()
+	case KindCLI:
+		return NewCLI(), nil
+	default:
+		return nil, fmt.Errorf("invalid ROLLWAVE_ENGINE '%s' (expected '%s' or '%s')", kind, KindSDK, KindCLI)
+	}
+}
+
+// NewFor returns the engine for deploying cfg, like New. The CLI engine is refused
+// up front when cfg uses features that create or update services from a spec,
+// which the docker binary cannot do. autoRollback is the effective setting,
+// which flags may override.
+func NewFor(cfg *config.Config, autoRollback bool) (Engine, error) {
+	eng, err := New()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := eng.(*CLI); !ok {
+		return eng, nil
+	}
+
+	var features []string
+	if cfg.Deploy.Driver != config.DriverCLI {
+		features = append(features, "the native deploy driver (set deploy.driver: cli)")
+	}
+	if autoRollback {
+		features = append(features, "auto_rollback")
+	}
+	if cfg.Deploy.Strategy == config.StrategyBlueGreen {
+		features = append(features, "the blue-green strategy")
+	}
+	if len(cfg.Deploy.Canary) > 0 {
+		features = append(features, "canaries")
+	}
+	if cfg.Migrate != nil {
+		features = append(features, "migrations")
+	}
+	if len(features) > 0 {
+		return nil, fmt.Errorf("ROLLWAVE_ENGINE=%s cannot run %s; use ROLLWAVE_ENGINE=%s: %w",
+			KindCLI, strings.Join(features, ", "), KindSDK, errors.ErrUnsupported)
+	}
+	return eng, nil
+}
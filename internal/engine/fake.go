@@ -0,0 +1,669 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/registry"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// stackLabel is the label 'docker stack' puts on every object of a stack (swarm.StackLabel).
+const stackLabel = "com.docker.stack.namespace"
+
+// Fake is an in-memory Swarm for tests. Services converge immediately: every
+// create or update replaces their tasks with new ones in the state returned by
+// TaskStatus. Builds, pushes, logins and stack deploys are only recorded.
+type Fake struct {
+	// TaskStatus decides the status of new tasks. By default tasks of services
+	// are running and tasks of jobs are complete with exit code 0.
+	TaskStatus func(spec dockerswarm.ServiceSpec) dockerswarm.TaskStatus
+
+	mu       sync.Mutex
+	seq      int
+	services map[string]*dockerswarm.Service
+	tasks    []dockerswarm.Task
+	secrets  map[string]*dockerswarm.Secret
+	configs  map[string]*dockerswarm.Config
+	networks map[string]*types.NetworkResource
+
+	// Recorded calls
+	Built    []string          // tags of built images
+	Pushed   []string          // pushed images
+	Logins   []string          // registries logged into ("" for Docker Hub)
+	Deployed map[string][]byte // stack name -> compose file of the last 'docker stack deploy'
+}
+
+// NewFake returns an empty Swarm.
+func NewFake() *Fake {
+	return &Fake{
+		services: make(map[string]*dockerswarm.Service),
+		secrets:  make(map[string]*dockerswarm.Secret),
+		configs:  make(map[string]*dockerswarm.Config),
+		networks: make(map[string]*types.NetworkResource),
+		Deployed: make(map[string][]byte),
+	}
+}
+
+// Close does nothing.
+func (f *Fake) Close() error {
+	return nil
+}
+
+// --- Images and stacks ---
+
+// Build records the tags.
+func (f *Fake) Build(_ context.Context, opt BuildOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Built = append(f.Built, opt.Tags...)
+	return nil
+}
+
+// Push records the image.
+func (f *Fake) Push(_ context.Context, image string, _, _ io.Writer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Pushed = append(f.Pushed, image)
+	return nil
+}
+
+// Login records the registry.
+func (f *Fake) Login(_ context.Context, opt LoginOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Logins = append(f.Logins, opt.Registry)
+	return nil
+}
+
+// StackDeploy records the compose file. It does not create services; use the
+// native deploy driver to exercise service creation.
+func (f *Fake) StackDeploy(_ context.Context, opt StackDeployOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Deployed[opt.Name] = append([]byte(nil), opt.Compose...)
+	return nil
+}
+
+// StackRemove removes the services, networks, secrets and configs of the stack.
+func (f *Fake) StackRemove(_ context.Context, name string, _, _ io.Writer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for id, s := range f.services {
+		if s.Spec.Labels[stackLabel] == name {
+			f.removeService(id)
+		}
+	}
+	for id, n := range f.networks {
+		if n.Labels[stackLabel] == name {
+			delete(f.networks, id)
+		}
+	}
+	for id, s := range f.secrets {
+		if s.Spec.Labels[stackLabel] == name {
+			delete(f.secrets, id)
+		}
+	}
+	for id, c := range f.configs {
+		if c.Spec.Labels[stackLabel] == name {
+			delete(f.configs, id)
+		}
+	}
+	return nil
+}
+
+// --- Services and tasks ---
+
+// ServiceList supports the id, name, label and mode filters.
+func (f *Fake) ServiceList(_ context.Context, options types.ServiceListOptions) ([]dockerswarm.Service, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var out []dockerswarm.Service
+	for _, s := range f.services {
+		if !matches(options.Filters, s.ID, s.Spec.Name, s.Spec.Labels) {
+			continue
+		}
+		if modes := options.Filters.Get("mode"); len(modes) > 0 {
+			mode := "replicated"
+			if s.Spec.Mode.Global != nil {
+				mode = "global"
+			}
+			if !options.Filters.ExactMatch("mode", mode) {
+				continue
+			}
+		}
+		out = append(out, copyService(s))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Spec.Name < out[j].Spec.Name })
+	return out, nil
+}
+
+// ServiceInspectWithRaw finds a service by ID or name.
+func (f *Fake) ServiceInspectWithRaw(_ context.Context, serviceID string, _ types.ServiceInspectOptions) (dockerswarm.Service, []byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s := f.findService(serviceID)
+	if s == nil {
+		return dockerswarm.Service{}, nil, errdefs.NotFound(fmt.Errorf("service %s not found", serviceID))
+	}
+	out := copyService(s)
+	raw, err := json.Marshal(out)
+	return out, raw, err
+}
+
+// ServiceCreate adds the service and starts its tasks.
+func (f *Fake) ServiceCreate(_ context.Context, spec dockerswarm.ServiceSpec, _ types.ServiceCreateOptions) (dockerswarm.ServiceCreateResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if spec.Name == "" {
+		return dockerswarm.ServiceCreateResponse{}, errdefs.InvalidParameter(fmt.Errorf("service has no name"))
+	}
+	if f.findService(spec.Name) != nil {
+		return dockerswarm.ServiceCreateResponse{}, errdefs.Conflict(fmt.Errorf("service %s already exists", spec.Name))
+	}
+
+	now := time.Now()
+	s := &dockerswarm.Service{
+		ID:   f.newID(),
+		Meta: dockerswarm.Meta{Version: dockerswarm.Version{Index: f.nextIndex()}, CreatedAt: now, UpdatedAt: now},
+		Spec: copySpec(spec),
+	}
+	s.Endpoint.Spec = derefEndpoint(spec.EndpointSpec)
+	s.Endpoint.Ports = s.Endpoint.Spec.Ports
+	f.services[s.ID] = s
+	f.startTasks(s)
+	return dockerswarm.ServiceCreateResponse{ID: s.ID}, nil
+}
+
+// ServiceUpdate replaces the spec if version matches the current one and
+// replaces the service's tasks.
+func (f *Fake) ServiceUpdate(_ context.Context, serviceID string, version dockerswarm.Version, spec dockerswarm.ServiceSpec, _ types.ServiceUpdateOptions) (dockerswarm.ServiceUpdateResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s := f.findService(serviceID)
+	if s == nil {
+		return dockerswarm.ServiceUpdateResponse{}, errdefs.NotFound(fmt.Errorf("service %s not found", serviceID))
+	}
+	if version.Index != s.Version.Index {
+		return dockerswarm.ServiceUpdateResponse{}, errdefs.InvalidParameter(fmt.Errorf("update out of sequence"))
+	}
+
+	previous := s.Spec
+	s.PreviousSpec = &previous
+	s.Spec = copySpec(spec)
+	s.Version.Index = f.nextIndex()
+	s.UpdatedAt = time.Now()
+	s.Endpoint.Spec = derefEndpoint(spec.EndpointSpec)
+	s.Endpoint.Ports = s.Endpoint.Spec.Ports
+	s.UpdateStatus = &dockerswarm.UpdateStatus{State: dockerswarm.UpdateStateCompleted, CompletedAt: &s.UpdatedAt}
+	f.startTasks(s)
+	return dockerswarm.ServiceUpdateResponse{}, nil
+}
+
+// ServiceRemove removes the service and its tasks.
+func (f *Fake) ServiceRemove(_ context.Context, serviceID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s := f.findService(serviceID)
+	if s == nil {
+		return errdefs.NotFound(fmt.Errorf("service %s not found", serviceID))
+	}
+	f.removeService(s.ID)
+	return nil
+}
+
+// ServiceLogs returns an empty log stream.
+func (f *Fake) ServiceLogs(_ context.Context, serviceID string, _ container.LogsOptions) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.findService(serviceID) == nil {
+		return nil, errdefs.NotFound(fmt.Errorf("service %s not found", serviceID))
+	}
+	return io.NopCloser(bytes.NewReader(nil)), nil
+}
+
+// TaskList supports the id, service, label and desired-state filters.
+func (f *Fake) TaskList(_ context.Context, options types.TaskListOptions) ([]dockerswarm.Task, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fl := options.Filters
+	var out []dockerswarm.Task
+	for _, t := range f.tasks {
+		if !matches(fl, t.ID, "", t.Labels) {
+			continue
+		}
+		if fl.Contains("service") {
+			s := f.services[t.ServiceID]
+			if s == nil || !(fl.ExactMatch("service", s.ID) || fl.ExactMatch("service", s.Spec.Name)) {
+				continue
+			}
+		}
+		if fl.Contains("desired-state") && !fl.ExactMatch("desired-state", string(t.DesiredState)) {
+			continue
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// --- Secrets ---
+
+// SecretList supports the id, name and label filters.
+func (f *Fake) SecretList(_ context.Context, options types.SecretListOptions) ([]dockerswarm.Secret, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var out []dockerswarm.Secret
+	for _, s := range f.secrets {
+		if matches(options.Filters, s.ID, s.Spec.Name, s.Spec.Labels) {
+			c := *s
+			c.Spec.Data = nil // the API never returns secret data
+			out = append(out, c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Spec.Name < out[j].Spec.Name })
+	return out, nil
+}
+
+// SecretCreate adds a secret; names must be unique.
+func (f *Fake) SecretCreate(_ context.Context, spec dockerswarm.SecretSpec) (types.SecretCreateResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, s := range f.secrets {
+		if s.Spec.Name == spec.Name {
+			return types.SecretCreateResponse{}, errdefs.Conflict(fmt.Errorf("secret %s already exists", spec.Name))
+		}
+	}
+	now := time.Now()
+	s := &dockerswarm.Secret{
+		ID:   f.newID(),
+		Meta: dockerswarm.Meta{Version: dockerswarm.Version{Index: f.nextIndex()}, CreatedAt: now, UpdatedAt: now},
+		Spec: spec,
+	}
+	s.Spec.Labels = copyLabels(spec.Labels)
+	f.secrets[s.ID] = s
+	return types.SecretCreateResponse{ID: s.ID}, nil
+}
+
+// SecretRemove removes a secret unless a service uses it.
+func (f *Fake) SecretRemove(_ context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s := f.secrets[id]
+	if s == nil {
+		for _, candidate := range f.secrets {
+			if candidate.Spec.Name == id {
+				s = candidate
+			}
+		}
+	}
+	if s == nil {
+		return errdefs.NotFound(fmt.Errorf("secret %s not found", id))
+	}
+	for _, svc := range f.services {
+		for _, ref := range svc.Spec.TaskTemplate.ContainerSpec.Secrets {
+			if ref.SecretID == s.ID {
+				return errdefs.InvalidParameter(fmt.Errorf("secret '%s' is in use by service %s", s.Spec.Name, svc.Spec.Name))
+			}
+		}
+	}
+	delete(f.secrets, s.ID)
+	return nil
+}
+
+// --- Configs ---
+
+// ConfigList supports the id, name and label filters.
+func (f *Fake) ConfigList(_ context.Context, options types.ConfigListOptions) ([]dockerswarm.Config, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var out []dockerswarm.Config
+	for _, c := range f.configs {
+		if matches(options.Filters, c.ID, c.Spec.Name, c.Spec.Labels) {
+			out = append(out, *c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Spec.Name < out[j].Spec.Name })
+	return out, nil
+}
+
+// ConfigInspectWithRaw finds a config by ID or name.
+func (f *Fake) ConfigInspectWithRaw(_ context.Context, name string) (dockerswarm.Config, []byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := f.findConfig(name)
+	if c == nil {
+		return dockerswarm.Config{}, nil, errdefs.NotFound(fmt.Errorf("config %s not found", name))
+	}
+	raw, err := json.Marshal(c)
+	return *c, raw, err
+}
+
+// ConfigCreate adds a config; names must be unique.
+func (f *Fake) ConfigCreate(_ context.Context, spec dockerswarm.ConfigSpec) (types.ConfigCreateResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, c := range f.configs {
+		if c.Spec.Name == spec.Name {
+			return types.ConfigCreateResponse{}, errdefs.Conflict(fmt.Errorf("config %s already exists", spec.Name))
+		}
+	}
+	now := time.Now()
+	c := &dockerswarm.Config{
+		ID:   f.newID(),
+		Meta: dockerswarm.Meta{Version: dockerswarm.Version{Index: f.nextIndex()}, CreatedAt: now, UpdatedAt: now},
+		Spec: spec,
+	}
+	c.Spec.Labels = copyLabels(spec.Labels)
+	f.configs[c.ID] = c
+	return types.ConfigCreateResponse{ID: c.ID}, nil
+}
+
+// ConfigUpdate replaces the labels of a config; like Swarm, the data cannot change.
+func (f *Fake) ConfigUpdate(_ context.Context, id string, version dockerswarm.Version, spec dockerswarm.ConfigSpec) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := f.findConfig(id)
+	if c == nil {
+		return errdefs.NotFound(fmt.Errorf("config %s not found", id))
+	}
+	if version.Index != c.Version.Index {
+		return errdefs.InvalidParameter(fmt.Errorf("update out of sequence"))
+	}
+	if spec.Data != nil && !bytes.Equal(spec.Data, c.Spec.Data) {
+		return errdefs.InvalidParameter(fmt.Errorf("only updates to Labels are allowed"))
+	}
+	c.Spec.Labels = copyLabels(spec.Labels)
+	c.Version.Index = f.nextIndex()
+	c.UpdatedAt = time.Now()
+	return nil
+}
+
+// ConfigRemove removes a config.
+func (f *Fake) ConfigRemove(_ context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := f.findConfig(id)
+	if c == nil {
+		return errdefs.NotFound(fmt.Errorf("config %s not found", id))
+	}
+	delete(f.configs, c.ID)
+	return nil
+}
+
+// --- Networks and images ---
+
+// NetworkInspect finds a network by ID or name.
+func (f *Fake) NetworkInspect(_ context.Context, network string, _ types.NetworkInspectOptions) (types.NetworkResource, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, n := range f.networks {
+		if n.ID == network || n.Name == network {
+			return *n, nil
+		}
+	}
+	return types.NetworkResource{}, errdefs.NotFound(fmt.Errorf("network %s not found", network))
+}
+
+// NetworkCreate adds a network; names must be unique.
+func (f *Fake) NetworkCreate(_ context.Context, name string, options types.NetworkCreate) (types.NetworkCreateResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, n := range f.networks {
+		if n.Name == name {
+			return types.NetworkCreateResponse{}, errdefs.Conflict(fmt.Errorf("network with name %s already exists", name))
+		}
+	}
+	n := &types.NetworkResource{
+		Name:       name,
+		ID:         f.newID(),
+		Created:    time.Now(),
+		Scope:      options.Scope,
+		Driver:     options.Driver,
+		Internal:   options.Internal,
+		Attachable: options.Attachable,
+		Options:    options.Options,
+		Labels:     copyLabels(options.Labels),
+	}
+	if options.IPAM != nil {
+		n.IPAM = *options.IPAM
+	}
+	f.networks[n.ID] = n
+	return types.NetworkCreateResponse{ID: n.ID}, nil
+}
+
+// DistributionInspect returns a digest derived from the image name, so pinned
+// images are stable across calls.
+func (f *Fake) DistributionInspect(_ context.Context, image, _ string) (registry.DistributionInspect, error) {
+	return registry.DistributionInspect{
+		Descriptor: ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Digest:    digest.FromString(image),
+		},
+	}, nil
+}
+
+// --- Helpers (called with f.mu held) ---
+
+func (f *Fake) newID() string {
+	f.seq++
+	sum := sha256.Sum256([]byte(fmt.Sprintf("rollwave-fake-%d", f.seq)))
+	return hex.EncodeToString(sum[:])[:25]
+}
+
+func (f *Fake) nextIndex() uint64 {
+	f.seq++
+	return uint64(f.seq)
+}
+
+func (f *Fake) findService(idOrName string) *dockerswarm.Service {
+	if s, ok := f.services[idOrName]; ok {
+		return s
+	}
+	for _, s := range f.services {
+		if s.Spec.Name == idOrName {
+			return s
+		}
+	}
+	return nil
+}
+
+func (f *Fake) findConfig(idOrName string) *dockerswarm.Config {
+	if c, ok := f.configs[idOrName]; ok {
+		return c
+	}
+	for _, c := range f.configs {
+		if c.Spec.Name == idOrName {
+			return c
+		}
+	}
+	return nil
+}
+
+func (f *Fake) removeService(id string) {
+	delete(f.services, id)
+	kept := f.tasks[:0]
+	for _, t := range f.tasks {
+		if t.ServiceID != id {
+			kept = append(kept, t)
+		}
+	}
+	f.tasks = kept
+}
+
+// startTasks shuts down the current tasks of a service and starts new ones.
+func (f *Fake) startTasks(s *dockerswarm.Service) {
+	now := time.Now()
+	for i := range f.tasks {
+		if f.tasks[i].ServiceID == s.ID && f.tasks[i].DesiredState == dockerswarm.TaskStateRunning {
+			f.tasks[i].DesiredState = dockerswarm.TaskStateShutdown
+			f.tasks[i].Status.State = dockerswarm.TaskStateShutdown
+			f.tasks[i].Status.Timestamp = now
+		}
+	}
+
+	count, job := 1, false
+	switch m := s.Spec.Mode; {
+	case m.Replicated != nil && m.Replicated.Replicas != nil:
+		count = int(*m.Replicated.Replicas)
+	case m.ReplicatedJob != nil:
+		job = true
+		if m.ReplicatedJob.TotalCompletions != nil {
+			count = int(*m.ReplicatedJob.TotalCompletions)
+		}
+	case m.GlobalJob != nil:
+		job = true
+	}
+
+	status := dockerswarm.TaskStatus{State: dockerswarm.TaskStateRunning}
+	if job {
+		status = dockerswarm.TaskStatus{State: dockerswarm.TaskStateComplete, ContainerStatus: &dockerswarm.ContainerStatus{ExitCode: 0}}
+	}
+	if f.TaskStatus != nil {
+		status = f.TaskStatus(s.Spec)
+	}
+	status.Timestamp = now
+
+	desired := dockerswarm.TaskStateRunning
+	if job {
+		desired = dockerswarm.TaskStateComplete
+	}
+
+	for i := 0; i < count; i++ {
+		spec := s.Spec.TaskTemplate
+		if spec.ContainerSpec != nil {
+			cs := *spec.ContainerSpec
+			spec.ContainerSpec = &cs
+		}
+		f.tasks = append(f.tasks, dockerswarm.Task{
+			ID:           f.newID(),
+			Meta:         dockerswarm.Meta{Version: dockerswarm.Version{Index: f.nextIndex()}, CreatedAt: now, UpdatedAt: now},
+			Annotations:  dockerswarm.Annotations{Labels: copyLabels(s.Spec.Labels)},
+			Spec:         spec,
+			ServiceID:    s.ID,
+			Slot:         i + 1,
+			Status:       status,
+			DesiredState: desired,
+		})
+	}
+}
+
+// matches applies the id (prefix), name (prefix) and label filters the API supports.
+func matches(f filters.Args, id, name string, labels map[string]string) bool {
+	if f.Contains("id") && !f.FuzzyMatch("id", id) {
+		return false
+	}
+	if f.Contains("name") && !f.FuzzyMatch("name", name) {
+		return false
+	}
+	if f.Contains("label") && !f.MatchKVList("label", labels) {
+		return false
+	}
+	return true
+}
+
+func copyService(s *dockerswarm.Service) dockerswarm.Service {
+	out := *s
+	out.Spec = copySpec(s.Spec)
+	return out
+}
+
+// copySpec copies the parts of a spec callers commonly modify, so a caller
+// mutating a listed service does not change the stored one.
+func copySpec(spec dockerswarm.ServiceSpec) dockerswarm.ServiceSpec {
+	spec.Labels = copyLabels(spec.Labels)
+	if spec.TaskTemplate.ContainerSpec != nil {
+		cs := *spec.TaskTemplate.ContainerSpec
+		cs.Labels = copyLabels(cs.Labels)
+		cs.Env = append([]string(nil), cs.Env...)
+		cs.Secrets = append([]*dockerswarm.SecretReference(nil), cs.Secrets...)
+		spec.TaskTemplate.ContainerSpec = &cs
+	}
+	if r := spec.Mode.Replicated; r != nil {
+		rc := *r
+		if r.Replicas != nil {
+			n := *r.Replicas
+			rc.Replicas = &n
+		}
+		spec.Mode.Replicated = &rc
+	}
+	if spec.EndpointSpec != nil {
+		es := *spec.EndpointSpec
+		es.Ports = append([]dockerswarm.PortConfig(nil), es.Ports...)
+		spec.EndpointSpec = &es
+	}
+	spec.TaskTemplate.Networks = append([]dockerswarm.NetworkAttachmentConfig(nil), spec.TaskTemplate.Networks...)
+	return spec
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	out := make(map[string]string, len(labels))
+	for k, v := range labels {
+		out[k] = v
+	}
+	return out
+}
+
+func derefEndpoint(es *dockerswarm.EndpointSpec) dockerswarm.EndpointSpec {
+	if es == nil {
+		return dockerswarm.EndpointSpec{}
+	}
+	out := *es
+	out.Ports = append([]dockerswarm.PortConfig(nil), es.Ports...)
+	if out.Mode == "" {
+		out.Mode = dockerswarm.ResolutionModeVIP
+	}
+	return out
+}
+
+// StackNames lists the stacks that have services, like 'docker stack ls'.
+func (f *Fake) StackNames() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	seen := make(map[string]bool)
+	for _, s := range f.services {
+		if name := s.Spec.Labels[stackLabel]; name != "" {
+			seen[name] = true
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for name := range seen {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/docker/cli/cli/connhelper"
+	"github.com/docker/docker/client"
+)
+
+// SDK talks to the Docker API for Swarm objects. Builds, pushes, logins and
+// 'docker stack' commands have no API equivalent that matches the CLI (BuildKit,
+// credential stores), so they go through the docker binary.
+type SDK struct {
+	*client.Client
+	cli *CLI
+}
+
+// NewSDK returns an SDK engine configured from the environment.
+// DOCKER_HOST values such as ssh://user@host are supported through the CLI connection helper.
+func NewSDK() (*SDK, error) {
+	opts := []client.Opt{
+		client.FromEnv,
+		client.WithAPIVersionNegotiation(),
+	}
+
+	host := os.Getenv("DOCKER_HOST")
+	if host != "" {
+		helper, err := connhelper.GetConnectionHelper(host)
+		if err != nil {
+			return nil, fmt.Errorf("ssh connection helper: %w", err)
+		}
+		if helper != nil {
+			opts = append(opts, client.WithDialContext(helper.Dialer))
+		}
+	}
+
+	cli, err := client.NewClientWithOpts(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("docker client: %w", err)
+	}
+	return &SDK{Client: cli, cli: NewCLI()}, nil
+}
+
+// Build runs 'docker build'.
+func (s *SDK) Build(ctx context.Context, opt BuildOptions) error {
+	return s.cli.Build(ctx, opt)
+}
+
+// Push runs 'docker push'.
+func (s *SDK) Push(ctx context.Context, image string, stdout, stderr io.Writer) error {
+	return s.cli.Push(ctx, image, stdout, stderr)
+}
+
+// Login runs 'docker login'.
+func (s *SDK) Login(ctx context.Context, opt LoginOptions) error {
+	return s.cli.Login(ctx, opt)
+}
+
+// StackDeploy runs 'docker stack deploy'.
+func (s *SDK) StackDeploy(ctx context.Context, opt StackDeployOptions) error {
+	return s.cli.StackDeploy(ctx, opt)
+}
+
+// StackRemove runs 'docker stack rm'.
+func (s *SDK) StackRemove(ctx context.Context, name string, stdout, stderr io.Writer) error {
+	return s.cli.StackRemove(ctx, name, stdout, stderr)
+}
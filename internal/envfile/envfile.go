@@ -0,0 +1,61 @@
+package envfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/joho/godotenv"
+
+	"github.com/rollwave-dev/rollwave/internal/config"
+)
+
+// Load exports the variables of the dotenv files into the process environment,
+// which lasts for the current command only. A variable defined in several files
+// takes the value of the last one. Variables set in the shell always win.
+//
+// Missing files are an error, except the first one when implicit is set: the
+// config.DefaultEnvFile loaded when no files are configured.
+func Load(files []string, implicit bool) error {
+	values := make(map[string]string)
+	for i, f := range files {
+		vars, err := godotenv.Read(f)
+		if errors.Is(err, os.ErrNotExist) && i == 0 && implicit {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("env file: %w", err)
+		}
+		for k, v := range vars {
+			values[k] = v
+		}
+	}
+
+	for k, v := range values {
+		if _, set := os.LookupEnv(k); set {
+			continue
+		}
+		if err := os.Setenv(k, v); err != nil {
+			return fmt.Errorf("env file: set %s: %w", k, err)
+		}
+	}
+	return nil
+}
+
+// Files returns the dotenv files of the config at cfgPath for env, followed by
+// extra. Without a readable config only the default file and extra are used;
+// the command itself reports configuration errors. implicit reports whether the
+// first file is the default one rather than a configured file.
+func Files(cfgPath, env string, extra []string) (files []string, implicit bool) {
+	files, implicit = []string{config.DefaultEnvFile}, true
+	if baseCfg, err := config.Load(cfgPath); err == nil {
+		// An environment's files are added after the top-level ones, so the
+		// default file stays first
+		implicit = len(baseCfg.EnvFiles) == 0
+		files = baseCfg.DotenvFiles()
+		if cfg, err := baseCfg.MergeWithEnv(env); err == nil {
+			files = cfg.DotenvFiles()
+		}
+	}
+	return append(files, extra...), implicit
+}
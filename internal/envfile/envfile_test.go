@@ -0,0 +1,223 @@
+package envfile
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rollwave-dev/rollwave/internal/compose"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string // name -> content; missing files are left out
+		load     []string
+		implicit bool
+		shell    map[string]string // set before loading
+		want     map[string]string // "" for unset
+		wantErr  string
+	}{
+		{
+			name: "parsing",
+			files: map[string]string{".env": `# comment
+ROLLWAVE_TEST_A=plain
+export ROLLWAVE_TEST_B=exported
+ROLLWAVE_TEST_C="two words"
+ROLLWAVE_TEST_D='single $quoted'
+ROLLWAVE_TEST_E=value # trailing comment
+ROLLWAVE_TEST_F="line\nbreak"
+`},
+			load: []string{".env"},
+			want: map[string]string{
+				"ROLLWAVE_TEST_A": "plain",
+				"ROLLWAVE_TEST_B": "exported",
+				"ROLLWAVE_TEST_C": "two words",
+				"ROLLWAVE_TEST_D": "single $quoted",
+				"ROLLWAVE_TEST_E": "value",
+				"ROLLWAVE_TEST_F": "line\nbreak",
+			},
+		},
+		{
+			name: "later files win",
+			files: map[string]string{
+				".env":            "ROLLWAVE_TEST_A=base\nROLLWAVE_TEST_B=base\n",
+				".env.production": "ROLLWAVE_TEST_B=production\n",
+			},
+			load: []string{".env", ".env.production"},
+			want: map[string]string{"ROLLWAVE_TEST_A": "base", "ROLLWAVE_TEST_B": "production"},
+		},
+		{
+			name:  "shell wins over env files",
+			files: map[string]string{".env": "ROLLWAVE_TEST_A=file\nROLLWAVE_TEST_B=file\n"},
+			load:  []string{".env"},
+			shell: map[string]string{"ROLLWAVE_TEST_A": "shell", "ROLLWAVE_TEST_B": ""},
+			want:  map[string]string{"ROLLWAVE_TEST_A": "shell", "ROLLWAVE_TEST_B": ""},
+		},
+		{
+			name:     "missing default file",
+			files:    map[string]string{"extra.env": "ROLLWAVE_TEST_A=extra\n"},
+			load:     []string{".env", "extra.env"},
+			implicit: true,
+			want:     map[string]string{"ROLLWAVE_TEST_A": "extra"},
+		},
+		{
+			name:    "missing configured file",
+			load:    []string{".env"},
+			wantErr: "env file: open .env",
+		},
+		{
+			name:     "missing extra file",
+			files:    map[string]string{".env": "ROLLWAVE_TEST_A=base\n"},
+			load:     []string{".env", "extra.env"},
+			implicit: true,
+			wantErr:  "env file: open extra.env",
+		},
+		{
+			name:    "invalid file",
+			files:   map[string]string{".env": "ROLLWAVE_TEST_A=\"unterminated\n"},
+			load:    []string{".env"},
+			wantErr: "env file:",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			unset(t, tt.want)
+			for k, v := range tt.shell {
+				t.Setenv(k, v)
+			}
+			for name, content := range tt.files {
+				if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := Load(tt.load, tt.implicit)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, want := range tt.want {
+				if got := os.Getenv(k); got != want {
+					t.Errorf("%s = %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestVariablesPrecedence checks the order compose files are interpolated in:
+// config variables, then the shell, then the env files.
+func TestVariablesPrecedence(t *testing.T) {
+	t.Chdir(t.TempDir())
+	want := map[string]string{
+		"ROLLWAVE_TEST_A": "config",
+		"ROLLWAVE_TEST_B": "shell",
+		"ROLLWAVE_TEST_C": "file",
+	}
+	unset(t, want)
+	t.Setenv("ROLLWAVE_TEST_A", "shell")
+	t.Setenv("ROLLWAVE_TEST_B", "shell")
+	if err := os.WriteFile(".env", []byte("ROLLWAVE_TEST_A=file\nROLLWAVE_TEST_B=file\nROLLWAVE_TEST_C=file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Load([]string{".env"}, true); err != nil {
+		t.Fatal(err)
+	}
+	lookup := compose.LookupVariables(map[string]string{"ROLLWAVE_TEST_A": "config"})
+	for k, v := range want {
+		if got, _ := lookup(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+}
+
+func TestFiles(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       string // rollwave.yml; none if empty
+		env          string
+		extra        []string
+		want         []string
+		wantImplicit bool
+	}{
+		{
+			name:         "no config",
+			extra:        []string{"ci.env"},
+			want:         []string{".env", "ci.env"},
+			wantImplicit: true,
+		},
+		{
+			name:         "no env files configured",
+			config:       "stack: {name: shop}\n",
+			want:         []string{".env"},
+			wantImplicit: true,
+		},
+		{
+			name:   "configured files",
+			config: "env_files: [common.env, local.env]\n",
+			extra:  []string{"ci.env"},
+			want:   []string{"common.env", "local.env", "ci.env"},
+		},
+		{
+			name: "environment files after the default one",
+			config: `environments:
+  production:
+    env_files: [.env.production]
+`,
+			env:          "production",
+			want:         []string{".env", ".env.production"},
+			wantImplicit: true,
+		},
+		{
+			name: "environment files after configured ones",
+			config: `env_files: [common.env]
+environments:
+  production:
+    env_files: [.env.production]
+`,
+			env:  "production",
+			want: []string{"common.env", ".env.production"},
+		},
+		{
+			name:   "unknown environment",
+			config: "env_files: [common.env]\n",
+			env:    "staging",
+			want:   []string{"common.env"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			if tt.config != "" {
+				if err := os.WriteFile("rollwave.yml", []byte(tt.config), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			files, implicit := Files("rollwave.yml", tt.env, tt.extra)
+			if !reflect.DeepEqual(files, tt.want) || implicit != tt.wantImplicit {
+				t.Errorf("Files = %q, %v; want %q, %v", files, implicit, tt.want, tt.wantImplicit)
+			}
+		})
+	}
+}
+
+// unset removes the variables from the environment for the duration of the test.
+func unset(t *testing.T, vars map[string]string) {
+	t.Helper()
+	for k := range vars {
+		t.Setenv(k, "")
+		os.Unsetenv(k)
+	}
+}
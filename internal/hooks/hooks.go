@@ -0,0 +1,124 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/rollwave-dev/rollwave/internal/config"
+)
+
+// Stages at which hooks run.
+const (
+	PreBuild   = "pre_build"
+	PostBuild  = "post_build"
+	PreDeploy  = "pre_deploy"
+	PostDeploy = "post_deploy"
+	OnFailure  = "on_failure"
+)
+
+// Runner executes the configured hooks with the deploy context in the environment.
+type Runner struct {
+	Hooks  config.HooksConfig
+	DryRun bool
+	Stdout io.Writer
+	Stderr io.Writer
+
+	env map[string]string
+}
+
+// NewRunner prepares a runner exposing the variables, stack and environment name to hooks.
+func NewRunner(cfg *config.Config, envName string, dryRun bool, stdout, stderr io.Writer) *Runner {
+	r := &Runner{
+		Hooks:  cfg.Hooks,
+		DryRun: dryRun,
+		Stdout: stdout,
+		Stderr: stderr,
+		env:    make(map[string]string),
+	}
+	for k, v := range cfg.Variables {
+		r.env[k] = v
+	}
+	r.env["ROLLWAVE_STACK"] = cfg.Stack.Name
+	r.env["ROLLWAVE_ENV"] = envName
+	return r
+}
+
+// Set adds or replaces an environment variable passed to later hooks.
+func (r *Runner) Set(key, value string) {
+	r.env[key] = value
+}
+
+// SetImages exposes built images as ROLLWAVE_IMAGE_<SERVICE> and ROLLWAVE_IMAGES.
+func (r *Runner) SetImages(images map[string]string) {
+	services := make([]string, 0, len(images))
+	for svc := range images {
+		services = append(services, svc)
+	}
+	sort.Strings(services)
+
+	pairs := make([]string, 0, len(services))
+	for _, svc := range services {
+		r.env["ROLLWAVE_IMAGE_"+envName(svc)] = images[svc]
+		pairs = append(pairs, svc+"="+images[svc])
+	}
+	r.env["ROLLWAVE_IMAGES"] = strings.Join(pairs, " ")
+}
+
+// Run executes the commands of a stage in order and stops at the first failure.
+func (r *Runner) Run(ctx context.Context, stage string) error {
+	commands := r.Hooks.Commands(stage)
+	if len(commands) == 0 {
+		return nil
+	}
+
+	env := os.Environ()
+	for k, v := range r.env {
+		env = append(env, k+"="+v)
+	}
+	env = append(env, "ROLLWAVE_HOOK="+stage)
+
+	for _, command := range commands {
+		if r.DryRun {
+			fmt.Fprintf(r.Stdout, "[dry-run] %s hook: %s\n", stage, command)
+			continue
+		}
+
+		fmt.Fprintf(r.Stdout, "🪝 %s: %s\n", stage, command)
+		c := exec.CommandContext(ctx, "sh", "-c", command)
+		c.Env = env
+		c.Stdout = r.Stdout
+		c.Stderr = r.Stderr
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("%s hook '%s' failed: %w", stage, command, err)
+		}
+	}
+	return nil
+}
+
+// Fail runs the on_failure hooks with the error exposed as ROLLWAVE_ERROR.
+// Errors from the hooks themselves are only reported.
+func (r *Runner) Fail(deployErr error) {
+	r.Set("ROLLWAVE_ERROR", deployErr.Error())
+	// Use a fresh context: the deploy context may already be cancelled
+	if err := r.Run(context.Background(), OnFailure); err != nil {
+		fmt.Fprintf(r.Stderr, "⚠️  %v\n", err)
+	}
+}
+
+// envName converts a service name into an environment variable suffix.
+func envName(service string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, service)
+}
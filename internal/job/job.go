@@ -0,0 +1,171 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github.com/rollwave-dev/rollwave/internal/build"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+)
+
+// LabelJob marks services created for one-off jobs with the kind of job.
+const LabelJob = "io.rollwave.job"
+
+// DefaultTimeout is used when a job does not set a timeout.
+const DefaultTimeout = 10 * time.Minute
+
+// ExitError reports a job that ran but exited with a non-zero code.
+type ExitError struct {
+	Name string
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("job %s exited with code %d", e.Name, e.Code)
+}
+
+// Options defines a one-off job.
+type Options struct {
+	// Spec is the service the job runs. Its mode, restart policy and published
+	// ports are replaced so it runs exactly once.
+	Spec    dockerswarm.ServiceSpec
+	Kind    string // value of LabelJob, e.g. "verify"
+	Timeout time.Duration
+
+	// Job output is copied to Stdout and Stderr
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Run starts the spec as a Swarm replicated job, streams its logs, waits for it
+// to finish and removes it. It returns the exit code of the job's container; the
+// error is only set if the job could not be run.
+func Run(ctx context.Context, cli engine.Swarm, opt Options) (int, error) {
+	if opt.Stdout == nil {
+		opt.Stdout = os.Stdout
+	}
+	if opt.Stderr == nil {
+		opt.Stderr = os.Stderr
+	}
+	if opt.Timeout <= 0 {
+		opt.Timeout = DefaultTimeout
+	}
+	if opt.Spec.TaskTemplate.ContainerSpec == nil {
+		return -1, fmt.Errorf("job %s has no container spec", opt.Spec.Name)
+	}
+
+	spec := opt.Spec
+	one := uint64(1)
+	spec.Mode = dockerswarm.ServiceMode{
+		ReplicatedJob: &dockerswarm.ReplicatedJob{MaxConcurrent: &one, TotalCompletions: &one},
+	}
+	spec.TaskTemplate.RestartPolicy = &dockerswarm.RestartPolicy{Condition: dockerswarm.RestartPolicyConditionNone}
+	spec.UpdateConfig = nil
+	spec.RollbackConfig = nil
+	spec.EndpointSpec = nil
+
+	spec.Labels = make(map[string]string, len(opt.Spec.Labels)+1)
+	for k, v := range opt.Spec.Labels {
+		spec.Labels[k] = v
+	}
+	spec.Labels[LabelJob] = opt.Kind
+
+	auth, err := build.RegistryAuth(spec.TaskTemplate.ContainerSpec.Image)
+	if err != nil {
+		return -1, fmt.Errorf("registry auth: %w", err)
+	}
+	resp, err := cli.ServiceCreate(ctx, spec, types.ServiceCreateOptions{EncodedRegistryAuth: auth})
+	if err != nil {
+		return -1, fmt.Errorf("create job %s: %w", spec.Name, err)
+	}
+	// Use a fresh context: the job must be removed even if ctx was cancelled
+	defer cli.ServiceRemove(context.Background(), resp.ID)
+
+	logCtx, stopLogs := context.WithCancel(ctx)
+	logsDone := make(chan struct{})
+	go func() {
+		defer close(logsDone)
+		streamLogs(logCtx, cli, resp.ID, opt.Stdout, opt.Stderr)
+	}()
+	finish := func() {
+		// Give the log stream a moment to drain before it is cut off
+		select {
+		case <-logsDone:
+		case <-time.After(2 * time.Second):
+		}
+		stopLogs()
+		<-logsDone
+	}
+
+	code, err := wait(ctx, cli, resp.ID, spec.Name, opt.Timeout)
+	finish()
+	return code, err
+}
+
+func wait(ctx context.Context, cli engine.Swarm, serviceID, name string, timeout time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	f := filters.NewArgs()
+	f.Add("service", serviceID)
+
+	for {
+		tasks, err := cli.TaskList(ctx, types.TaskListOptions{Filters: f})
+		if err != nil {
+			if ctx.Err() != nil {
+				return -1, fmt.Errorf("job %s did not finish within %s", name, timeout)
+			}
+			return -1, fmt.Errorf("list tasks: %w", err)
+		}
+
+		for _, t := range tasks {
+			switch t.Status.State {
+			case dockerswarm.TaskStateComplete:
+				return 0, nil
+			case dockerswarm.TaskStateFailed:
+				if t.Status.ContainerStatus != nil && t.Status.ContainerStatus.ExitCode != 0 {
+					return t.Status.ContainerStatus.ExitCode, nil
+				}
+				return -1, fmt.Errorf("job %s failed: %s", name, t.Status.Err)
+			case dockerswarm.TaskStateRejected:
+				return -1, fmt.Errorf("job %s was rejected: %s", name, t.Status.Err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return -1, fmt.Errorf("job %s did not finish within %s", name, timeout)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func streamLogs(ctx context.Context, cli engine.Swarm, serviceID string, stdout, stderr io.Writer) {
+	logs, err := cli.ServiceLogs(ctx, serviceID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			fmt.Fprintf(stderr, "⚠️  Failed to stream job logs: %v\n", err)
+		}
+		return
+	}
+	defer logs.Close()
+
+	go func() {
+		<-ctx.Done()
+		logs.Close()
+	}()
+	_, _ = stdcopy.StdCopy(stdout, stderr, logs)
+}
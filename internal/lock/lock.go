@@ -0,0 +1,295 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/release"
+)
+
+// Labels identifying lock objects (Docker config objects).
+const (
+	LabelHeartbeat = "io.rollwave.lock.heartbeat"
+
+	kindLock = "lock"
+)
+
+// StaleAfter is how long a lock may go without a heartbeat before it is
+// considered abandoned (e.g. after the holder was killed) and can be taken over.
+const StaleAfter = 5 * time.Minute
+
+// HeartbeatInterval is how often a held lock is refreshed.
+const HeartbeatInterval = 30 * time.Second
+
+// LostAfter is how long the heartbeat may keep failing before the holder's
+// context is cancelled. It is shorter than StaleAfter, so the operation stops
+// before another process can take the lock over.
+const LostAfter = StaleAfter - 2*HeartbeatInterval
+
+// Info describes the holder of a lock.
+type Info struct {
+	Stack      string    `json:"stack"`
+	Owner      string    `json:"owner"`
+	Host       string    `json:"host"`
+	PID        int       `json:"pid"`
+	Operation  string    `json:"operation"`
+	AcquiredAt time.Time `json:"acquired_at"`
+
+	// Heartbeat is read from the lock's label, not from its data
+	Heartbeat time.Time `json:"-"`
+}
+
+// Stale reports whether the holder stopped refreshing the lock.
+func (i Info) Stale() bool {
+	last := i.Heartbeat
+	if last.IsZero() {
+		last = i.AcquiredAt
+	}
+	return time.Since(last) > StaleAfter
+}
+
+func (i Info) String() string {
+	return fmt.Sprintf("%s on %s (%s, pid %d) since %s",
+		i.Owner, i.Host, i.Operation, i.PID, i.AcquiredAt.Local().Format(time.DateTime))
+}
+
+// HeldError is returned when another process holds the lock.
+type HeldError struct {
+	Info Info
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("stack '%s' is locked by %s\n   If that operation is no longer running, wait for the lock to expire (%s without heartbeat) or run 'rollwave lock release --force'",
+		e.Info.Stack, e.Info, StaleAfter)
+}
+
+// Lock is a held lock. It is refreshed in the background until released.
+type Lock struct {
+	cli    engine.Swarm
+	id     string
+	info   Info
+	stderr io.Writer
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	stop   chan struct{}
+	once   sync.Once
+	done   sync.WaitGroup
+}
+
+// Acquire takes the lock of a stack for the given operation (e.g. "deploy").
+// A stale lock left behind by a killed process is taken over with a warning.
+// The work done under the lock should use Context, which is cancelled if the
+// lock can no longer be refreshed.
+func Acquire(ctx context.Context, cli engine.Swarm, stack, operation string, stderr io.Writer) (*Lock, error) {
+	if stderr == nil {
+		stderr = io.Discard
+	}
+
+	host, _ := os.Hostname()
+	info := Info{
+		Stack:      stack,
+		Owner:      release.Deployer(),
+		Host:       host,
+		PID:        os.Getpid(),
+		Operation:  operation,
+		AcquiredAt: time.Now().UTC(),
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	// Creating a config with a fixed name is atomic: only one caller can succeed
+	for attempt := 0; attempt < 2; attempt++ {
+		resp, err := cli.ConfigCreate(ctx, dockerswarm.ConfigSpec{
+			Annotations: dockerswarm.Annotations{
+				Name: name(stack),
+				Labels: map[string]string{
+					release.LabelKind:  kindLock,
+					release.LabelStack: stack,
+					LabelHeartbeat:     info.AcquiredAt.Format(time.RFC3339),
+				},
+			},
+			Data: data,
+		})
+		if err == nil {
+			l := &Lock{cli: cli, id: resp.ID, info: info, stderr: stderr, stop: make(chan struct{})}
+			l.ctx, l.cancel = context.WithCancelCause(ctx)
+			l.done.Add(1)
+			go l.heartbeat()
+			return l, nil
+		}
+		if !errdefs.IsConflict(err) {
+			return nil, fmt.Errorf("acquire lock: %w", err)
+		}
+
+		holder, id, err := get(ctx, cli, stack)
+		if err != nil {
+			return nil, err
+		}
+		if holder == nil {
+			continue // released in the meantime
+		}
+		if !holder.Stale() {
+			return nil, &HeldError{Info: *holder}
+		}
+		fmt.Fprintf(stderr, "⚠️  Taking over stale lock held by %s\n", holder)
+		if err := cli.ConfigRemove(ctx, id); err != nil && !client.IsErrNotFound(err) {
+			return nil, fmt.Errorf("remove stale lock: %w", err)
+		}
+	}
+	return nil, fmt.Errorf("acquire lock: stack '%s' is being locked concurrently, try again", stack)
+}
+
+// Context returns the context of the work done under the lock. It is cancelled
+// when the lock is lost: removed by someone else, or not refreshed for LostAfter.
+func (l *Lock) Context() context.Context {
+	return l.ctx
+}
+
+// Release stops the heartbeat and removes the lock. It is safe to call more than once.
+func (l *Lock) Release() error {
+	var err error
+	l.once.Do(func() {
+		close(l.stop)
+		l.done.Wait()
+		l.cancel(context.Canceled)
+		// Use a fresh context: the operation's context may already be cancelled
+		if rmErr := l.cli.ConfigRemove(context.Background(), l.id); rmErr != nil && !client.IsErrNotFound(rmErr) {
+			err = fmt.Errorf("release lock: %w", rmErr)
+		}
+	})
+	return err
+}
+
+func (l *Lock) heartbeat() {
+	defer l.done.Done()
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	failures := 0
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		err := l.refresh()
+		if err == nil {
+			last, failures = time.Now(), 0
+			continue
+		}
+
+		failures++
+		if errors.Is(err, errors.ErrUnsupported) {
+			// The CLI engine cannot update configs; the lock expires after StaleAfter
+			fmt.Fprintf(l.stderr, "⚠️  The lock cannot be refreshed with this engine; it may be taken over after %s.\n", StaleAfter)
+			return
+		}
+		if client.IsErrNotFound(err) {
+			lost := fmt.Errorf("lock of stack '%s' was removed by another process", l.info.Stack)
+			fmt.Fprintf(l.stderr, "❌ %v, stopping.\n", lost)
+			l.cancel(lost)
+			return
+		}
+		fmt.Fprintf(l.stderr, "⚠️  Failed to refresh the lock (%d in a row): %v\n", failures, err)
+		if time.Since(last) > LostAfter {
+			lost := fmt.Errorf("lock of stack '%s' could not be refreshed for %s", l.info.Stack, LostAfter)
+			fmt.Fprintf(l.stderr, "❌ %v, stopping before another process takes it over.\n", lost)
+			l.cancel(lost)
+			return
+		}
+	}
+}
+
+// refresh updates the heartbeat label of the lock.
+func (l *Lock) refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), HeartbeatInterval)
+	defer cancel()
+
+	cfg, _, err := l.cli.ConfigInspectWithRaw(ctx, l.id)
+	if err != nil {
+		return err
+	}
+	// Only labels of a config can be updated; the data stays as created
+	spec := cfg.Spec
+	labels := make(map[string]string, len(spec.Labels)+1)
+	for k, v := range spec.Labels {
+		labels[k] = v
+	}
+	labels[LabelHeartbeat] = time.Now().UTC().Format(time.RFC3339)
+	spec.Labels = labels
+	return l.cli.ConfigUpdate(ctx, l.id, cfg.Version, spec)
+}
+
+// Get returns the current holder of the stack's lock, or nil if it is not locked.
+func Get(ctx context.Context, cli engine.Swarm, stack string) (*Info, error) {
+	info, _, err := get(ctx, cli, stack)
+	return info, err
+}
+
+// ForceRelease removes the stack's lock regardless of who holds it and returns the
+// previous holder, or nil if the stack was not locked.
+func ForceRelease(ctx context.Context, cli engine.Swarm, stack string) (*Info, error) {
+	info, id, err := get(ctx, cli, stack)
+	if err != nil || info == nil {
+		return nil, err
+	}
+	if err := cli.ConfigRemove(ctx, id); err != nil && !client.IsErrNotFound(err) {
+		return nil, fmt.Errorf("release lock: %w", err)
+	}
+	return info, nil
+}
+
+func get(ctx context.Context, cli engine.Swarm, stack string) (*Info, string, error) {
+	f := filters.NewArgs()
+	f.Add("label", release.LabelKind+"="+kindLock)
+	f.Add("label", release.LabelStack+"="+stack)
+
+	configs, err := cli.ConfigList(ctx, types.ConfigListOptions{Filters: f})
+	if err != nil {
+		return nil, "", fmt.Errorf("list locks: %w", err)
+	}
+	for _, c := range configs {
+		if c.Spec.Name != name(stack) {
+			continue
+		}
+		data := c.Spec.Data
+		if len(data) == 0 {
+			full, _, err := cli.ConfigInspectWithRaw(ctx, c.ID)
+			if err != nil {
+				return nil, "", fmt.Errorf("inspect lock: %w", err)
+			}
+			data = full.Spec.Data
+		}
+
+		var info Info
+		if err := json.Unmarshal(data, &info); err != nil {
+			return nil, "", fmt.Errorf("parse lock: %w", err)
+		}
+		if hb, err := time.Parse(time.RFC3339, c.Spec.Labels[LabelHeartbeat]); err == nil {
+			info.Heartbeat = hb
+		}
+		return &info, c.ID, nil
+	}
+	return nil, "", nil
+}
+
+func name(stack string) string {
+	return stack + "_rollwave_lock"
+}
@@ -0,0 +1,140 @@
+package lock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/release"
+)
+
+func TestAcquire(t *testing.T) {
+	tests := []struct {
+		name      string
+		heldSince time.Duration // age of the heartbeat of a lock held by another process; 0 if free
+		wantHeld  bool
+		wantWarn  string
+	}{
+		{
+			name: "free",
+		},
+		{
+			name:      "held",
+			heldSince: time.Minute,
+			wantHeld:  true,
+		},
+		{
+			name:      "stale",
+			heldSince: StaleAfter + time.Minute,
+			wantWarn:  "Taking over stale lock held by alice",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cli := engine.NewFake()
+			if tt.heldSince > 0 {
+				holdLock(t, cli, "shop", time.Now().Add(-tt.heldSince))
+			}
+
+			var stderr bytes.Buffer
+			l, err := Acquire(ctx, cli, "shop", "deploy", &stderr)
+
+			var held *HeldError
+			if tt.wantHeld {
+				if !errors.As(err, &held) {
+					t.Fatalf("err = %v, want a HeldError", err)
+				}
+				if held.Info.Owner != "alice" {
+					t.Errorf("holder = %s, want alice", held.Info.Owner)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Release()
+
+			if !strings.Contains(stderr.String(), tt.wantWarn) {
+				t.Errorf("stderr = %q, want %q", stderr.String(), tt.wantWarn)
+			}
+			if l.Context().Err() != nil {
+				t.Errorf("context of a held lock is done: %v", l.Context().Err())
+			}
+
+			info, err := Get(ctx, cli, "shop")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info == nil || info.Operation != "deploy" || info.PID == 0 {
+				t.Errorf("lock holder = %+v, want this process", info)
+			}
+		})
+	}
+}
+
+func TestRelease(t *testing.T) {
+	ctx := context.Background()
+	cli := engine.NewFake()
+
+	l, err := Acquire(ctx, cli, "shop", "deploy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Acquire(ctx, cli, "shop", "rollback", nil); err == nil {
+		t.Fatal("second Acquire succeeded while the lock was held")
+	}
+
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Release(); err != nil {
+		t.Errorf("second Release: %v", err)
+	}
+	if l.Context().Err() == nil {
+		t.Error("context of a released lock is not done")
+	}
+
+	l, err = Acquire(ctx, cli, "shop", "rollback", nil)
+	if err != nil {
+		t.Fatalf("Acquire after Release: %v", err)
+	}
+	defer l.Release()
+}
+
+// holdLock creates the lock of a stack as another process would.
+func holdLock(t *testing.T, cli *engine.Fake, stack string, heartbeat time.Time) {
+	t.Helper()
+	data, err := json.Marshal(Info{
+		Stack:      stack,
+		Owner:      "alice",
+		Host:       "ci-runner-3",
+		PID:        4121,
+		Operation:  "deploy",
+		AcquiredAt: heartbeat,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cli.ConfigCreate(context.Background(), dockerswarm.ConfigSpec{
+		Annotations: dockerswarm.Annotations{
+			Name: name(stack),
+			Labels: map[string]string{
+				release.LabelKind:  kindLock,
+				release.LabelStack: stack,
+				LabelHeartbeat:     heartbeat.UTC().Format(time.RFC3339),
+			},
+		},
+		Data: data,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,154 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+
+	"github.com/rollwave-dev/rollwave/internal/compose"
+	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/job"
+	"github.com/rollwave-dev/rollwave/internal/stack"
+	"github.com/rollwave-dev/rollwave/internal/swarm"
+)
+
+// Options defines the parameters for running the migration job.
+type Options struct {
+	Stack     string
+	Compose   []byte            // rendered compose file (interpolated, images replaced, secrets rewritten)
+	Variables map[string]string // interpolated into migrate.env
+	DryRun    bool
+
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Run starts the migration as a one-off Swarm job using the image and environment
+// of the configured compose service, streams its logs and returns an error if it
+// does not exit with code 0.
+func Run(ctx context.Context, cli engine.Swarm, m config.MigrateConfig, opt Options) error {
+	if opt.Stdout == nil {
+		opt.Stdout = os.Stdout
+	}
+	if opt.Stderr == nil {
+		opt.Stderr = os.Stderr
+	}
+
+	images, err := compose.ServiceImages(opt.Compose)
+	if err != nil {
+		return fmt.Errorf("read images: %w", err)
+	}
+	image, ok := images[m.Service]
+	if !ok {
+		return fmt.Errorf("migrate.service '%s' has no image in the compose file", m.Service)
+	}
+
+	if opt.DryRun {
+		fmt.Fprintf(opt.Stdout, "[dry-run] migration job: %s %s\n", image, strings.Join(m.Command, " "))
+		return nil
+	}
+
+	// Environment of the service, overridden by migrate.env
+	env, err := compose.ServiceEnvironment(opt.Compose, m.Service)
+	if err != nil {
+		return err
+	}
+	lookup := compose.LookupVariables(opt.Variables)
+	for k, v := range m.Env {
+		if env[k], err = compose.Interpolate(v, lookup); err != nil {
+			return err
+		}
+	}
+	envList := make([]string, 0, len(env))
+	for k, v := range env {
+		envList = append(envList, k+"="+v)
+	}
+	sort.Strings(envList)
+
+	// On the first deploy of a stack its networks, secrets and configs do not
+	// exist yet; create them like the deploy would, before the job needs them
+	workDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	specs, err := stack.Convert(opt.Stack, opt.Compose, workDir)
+	if err != nil {
+		return err
+	}
+	if err := stack.Prepare(ctx, cli, specs, opt.Stdout); err != nil {
+		return fmt.Errorf("prepare stack: %w", err)
+	}
+
+	networkNames := m.Networks
+	if len(networkNames) == 0 {
+		networkNames = []string{"default"}
+	}
+	networks := make([]dockerswarm.NetworkAttachmentConfig, 0, len(networkNames))
+	for _, n := range networkNames {
+		target, err := swarm.NetworkName(ctx, cli, opt.Stack, n)
+		if err != nil {
+			return err
+		}
+		networks = append(networks, dockerswarm.NetworkAttachmentConfig{Target: target})
+	}
+
+	secretNames, err := compose.SecretNames(opt.Compose, opt.Stack, m.Secrets)
+	if err != nil {
+		return fmt.Errorf("migration secrets: %w", err)
+	}
+	refs := make([]*dockerswarm.SecretReference, 0, len(m.Secrets))
+	for _, source := range m.Secrets {
+		id, err := swarm.SecretID(ctx, cli, secretNames[source])
+		if err != nil {
+			return fmt.Errorf("migration secrets: %w", err)
+		}
+		refs = append(refs, &dockerswarm.SecretReference{
+			SecretID:   id,
+			SecretName: secretNames[source],
+			File:       &dockerswarm.SecretReferenceFileTarget{Name: source, UID: "0", GID: "0", Mode: 0444},
+		})
+	}
+
+	var timeout time.Duration
+	if m.Timeout != "" {
+		if timeout, err = time.ParseDuration(m.Timeout); err != nil {
+			return fmt.Errorf("invalid migrate.timeout '%s': %w", m.Timeout, err)
+		}
+	}
+
+	fmt.Fprintf(opt.Stdout, "🗄️  Running migration job (%s): %s\n", image, strings.Join(m.Command, " "))
+	code, err := job.Run(ctx, cli, job.Options{
+		Spec: dockerswarm.ServiceSpec{
+			Annotations: dockerswarm.Annotations{Name: fmt.Sprintf("%s_migrate_%d", opt.Stack, time.Now().Unix())},
+			TaskTemplate: dockerswarm.TaskSpec{
+				ContainerSpec: &dockerswarm.ContainerSpec{
+					Image:   image,
+					Command: m.Command,
+					Env:     envList,
+					Secrets: refs,
+				},
+				Networks: networks,
+			},
+		},
+		Kind:    "migrate",
+		Timeout: timeout,
+		Stdout:  opt.Stdout,
+		Stderr:  opt.Stderr,
+	})
+	if err != nil {
+		return fmt.Errorf("migration: %w", err)
+	}
+	if code != 0 {
+		return fmt.Errorf("migration exited with code %d", code)
+	}
+
+	fmt.Fprintln(opt.Stdout, "✅ Migration completed.")
+	return nil
+}
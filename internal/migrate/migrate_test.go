@@ -0,0 +1,65 @@
+package migrate
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+)
+
+func TestRunFirstDeploy(t *testing.T) {
+	tests := []struct {
+		name        string
+		networks    []string // migrate.networks
+		wantNetwork string
+	}{
+		{
+			name:        "default network",
+			wantNetwork: "shop_default",
+		},
+		{
+			name:        "named network",
+			networks:    []string{"backend"},
+			wantNetwork: "shop_backend",
+		},
+	}
+
+	compose := []byte(`services:
+  web:
+    image: shop/web:2
+    environment:
+      DATABASE_URL: postgres://db/shop
+    networks: [default, backend]
+networks:
+  backend: {}
+`)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cli := engine.NewFake()
+
+			err := Run(ctx, cli, config.MigrateConfig{
+				Service:  "web",
+				Command:  []string{"./migrate", "up"},
+				Networks: tt.networks,
+			}, Options{
+				Stack:   "shop",
+				Compose: compose,
+				Stdout:  io.Discard,
+				Stderr:  io.Discard,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := cli.NetworkInspect(ctx, tt.wantNetwork, types.NetworkInspectOptions{}); err != nil {
+				t.Errorf("network %s: %v", tt.wantNetwork, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,357 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rollwave-dev/rollwave/internal/compose"
+	"github.com/rollwave-dev/rollwave/internal/config"
+)
+
+// Delivery settings. A notification is attempted up to Attempts times, waiting
+// RetryDelay, then twice as long, between attempts.
+const (
+	Attempts    = 3
+	RetryDelay  = 2 * time.Second
+	SendTimeout = 10 * time.Second
+)
+
+// Event is a deploy state change sent to the notification targets.
+type Event struct {
+	Type        string            `json:"event"` // config.EventStarted, ...
+	Project     string            `json:"project,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Stack       string            `json:"stack"`
+	Release     int               `json:"release,omitempty"`
+	Images      map[string]string `json:"images,omitempty"` // service -> image
+	GitSHA      string            `json:"git_sha,omitempty"`
+	Deployer    string            `json:"deployer,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	Time        time.Time         `json:"time"`
+}
+
+// Title is a one-line summary, e.g. "✅ Deploy of shop (production) succeeded".
+func (e Event) Title() string {
+	icon := "🚀"
+	switch e.Type {
+	case config.EventSucceeded:
+		icon = "✅"
+	case config.EventFailed:
+		icon = "❌"
+	case config.EventRolledBack:
+		icon = "↩️"
+	}
+	return icon + " " + e.Summary()
+}
+
+// Summary is the title without the icon, e.g. for mail subjects.
+func (e Event) Summary() string {
+	verb := "started"
+	switch e.Type {
+	case config.EventSucceeded:
+		verb = "succeeded"
+	case config.EventFailed:
+		verb = "failed"
+	case config.EventRolledBack:
+		verb = "failed and was rolled back"
+	}
+	target := e.Stack
+	if e.Environment != "" {
+		target += " (" + e.Environment + ")"
+	}
+	return fmt.Sprintf("Deploy of %s %s", target, verb)
+}
+
+// Text is the title followed by the details of the deploy.
+func (e Event) Text() string {
+	var b strings.Builder
+	b.WriteString(e.Title() + "\n")
+	if e.Release != 0 {
+		fmt.Fprintf(&b, "Release:  #%d\n", e.Release)
+	}
+	if e.GitSHA != "" {
+		fmt.Fprintf(&b, "Git SHA:  %s\n", e.GitSHA)
+	}
+	if e.Deployer != "" {
+		fmt.Fprintf(&b, "Deployer: %s\n", e.Deployer)
+	}
+	if len(e.Images) > 0 {
+		b.WriteString("Images:\n")
+		services := make([]string, 0, len(e.Images))
+		for svc := range e.Images {
+			services = append(services, svc)
+		}
+		sort.Strings(services)
+		for _, svc := range services {
+			fmt.Fprintf(&b, "  %s: %s\n", svc, e.Images[svc])
+		}
+	}
+	if e.Error != "" {
+		fmt.Fprintf(&b, "Error:    %s\n", e.Error)
+	}
+	return b.String()
+}
+
+// Notifier delivers events to the configured targets. A nil Notifier sends nothing.
+type Notifier struct {
+	cfg    config.NotificationsConfig
+	lookup compose.LookupFunc
+	client *http.Client
+	stderr io.Writer
+}
+
+// New returns a notifier for cfg. String values are interpolated from vars and
+// the environment when an event is sent.
+func New(cfg config.NotificationsConfig, vars map[string]string, stderr io.Writer) *Notifier {
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	return &Notifier{
+		cfg:    cfg,
+		lookup: compose.LookupVariables(vars),
+		client: &http.Client{Timeout: SendTimeout},
+		stderr: stderr,
+	}
+}
+
+// Send delivers e to every target subscribed to its type, in parallel. Failures
+// are retried and then reported as warnings; they never fail the deploy.
+func (n *Notifier) Send(e Event) {
+	if n == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	var wg sync.WaitGroup
+	deliver := func(name string, send func(context.Context) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := n.retry(send); err != nil {
+				fmt.Fprintf(n.stderr, "⚠️  Notification to %s failed: %v\n", name, err)
+			}
+		}()
+	}
+
+	for _, w := range n.cfg.Webhooks {
+		if subscribed(w.Events, e.Type) {
+			deliver("webhook", func(ctx context.Context) error { return n.webhook(ctx, w, e) })
+		}
+	}
+	for _, s := range n.cfg.Slack {
+		if subscribed(s.Events, e.Type) {
+			deliver("Slack", func(ctx context.Context) error { return n.slack(ctx, s, e) })
+		}
+	}
+	for _, m := range n.cfg.Email {
+		if subscribed(m.Events, e.Type) {
+			deliver("email", func(context.Context) error { return n.email(m, e) })
+		}
+	}
+	wg.Wait()
+}
+
+func (n *Notifier) retry(send func(context.Context) error) error {
+	delay := RetryDelay
+	var err error
+	for attempt := 1; attempt <= Attempts; attempt++ {
+		// Not derived from the deploy context: a cancelled deploy is still reported
+		ctx, cancel := context.WithTimeout(context.Background(), SendTimeout)
+		err = send(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		var perm permanentError
+		if errors.As(err, &perm) || attempt == Attempts {
+			break
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+	return err
+}
+
+func (n *Notifier) webhook(ctx context.Context, w config.WebhookNotification, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	headers := make(map[string]string, len(w.Headers))
+	for k, v := range w.Headers {
+		if headers[k], err = n.expand(v); err != nil {
+			return err
+		}
+	}
+	url, err := n.expand(w.URL)
+	if err != nil {
+		return err
+	}
+	return n.post(ctx, url, body, headers)
+}
+
+func (n *Notifier) slack(ctx context.Context, s config.SlackNotification, e Event) error {
+	msg := map[string]string{"text": e.Text()}
+	if s.Channel != "" {
+		msg["channel"] = s.Channel
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	url, err := n.expand(s.WebhookURL)
+	if err != nil {
+		return err
+	}
+	return n.post(ctx, url, body, nil)
+}
+
+func (n *Notifier) post(ctx context.Context, url string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "rollwave")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		// The request itself is wrong; sending it again will not help
+		return permanentError{fmt.Errorf("status %d", resp.StatusCode)}
+	default:
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+}
+
+func (n *Notifier) email(m config.EmailNotification, e Event) error {
+	host, err := n.expand(m.Host)
+	if err != nil {
+		return err
+	}
+	username, err := n.expand(m.Username)
+	if err != nil {
+		return err
+	}
+	password, err := n.expand(m.Password)
+	if err != nil {
+		return err
+	}
+	port := m.Port
+	if port == 0 {
+		port = 587
+	}
+
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", m.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", e.Summary())
+	fmt.Fprintf(&msg, "Date: %s\r\n", e.Time.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(e.Text(), "\n", "\r\n"))
+
+	return sendMail(net.JoinHostPort(host, strconv.Itoa(port)), host, auth, m.From, m.To, []byte(msg.String()))
+}
+
+// sendMail is smtp.SendMail with a deadline, so an unresponsive server cannot
+// hold up the deploy.
+func sendMail(addr, host string, auth smtp.Auth, from string, to []string, msg []byte) error {
+	conn, err := net.DialTimeout("tcp", addr, SendTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(SendTimeout)); err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return permanentError{err}
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (n *Notifier) expand(s string) (string, error) {
+	v, err := compose.Interpolate(s, n.lookup)
+	if err != nil {
+		return "", permanentError{err}
+	}
+	return v, nil
+}
+
+// subscribed reports whether a target listening to events receives event; an
+// empty list means all events.
+func subscribed(events []string, event string) bool {
+	return len(events) == 0 || slices.Contains(events, event)
+}
+
+// permanentError marks failures that are not retried.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
@@ -0,0 +1,152 @@
+package output
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats accepted by the global --output flag.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// Flag is the name of the global flag selecting the format.
+const Flag = "output"
+
+type contextKey struct{}
+
+// Validate checks that format is one of the supported formats.
+func Validate(format string) error {
+	switch format {
+	case FormatText, FormatJSON, FormatYAML:
+		return nil
+	default:
+		return fmt.Errorf("invalid --%s '%s' (expected %s, %s or %s)", Flag, format, FormatText, FormatJSON, FormatYAML)
+	}
+}
+
+// Printer writes the results of a command in the selected format.
+type Printer struct {
+	format string
+	w      io.Writer
+	yaml   *yaml.Encoder
+}
+
+// Setup installs a printer for format on cmd. In the json and yaml formats the
+// human readable messages written to cmd.OutOrStdout() go to stderr instead, so
+// stdout only carries the structured data.
+func Setup(cmd *cobra.Command, format string) error {
+	if err := Validate(format); err != nil {
+		return err
+	}
+	p := &Printer{format: format, w: cmd.OutOrStdout()}
+	if p.Structured() {
+		cmd.Root().SetOut(cmd.ErrOrStderr())
+	}
+
+	ctx := cmd.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cmd.SetContext(context.WithValue(ctx, contextKey{}, p))
+	return nil
+}
+
+// From returns the printer installed by Setup, or a text printer writing to
+// cmd.OutOrStdout() if there is none.
+func From(cmd *cobra.Command) *Printer {
+	if ctx := cmd.Context(); ctx != nil {
+		if p, ok := ctx.Value(contextKey{}).(*Printer); ok {
+			return p
+		}
+	}
+	return &Printer{format: FormatText, w: cmd.OutOrStdout()}
+}
+
+// Structured reports whether the output is json or yaml.
+func (p *Printer) Structured() bool {
+	return p.format != FormatText
+}
+
+// Writer is where the structured data goes, stdout unless redirected. Commands
+// whose result is a document of its own, like a rendered compose file, write it here.
+func (p *Printer) Writer() io.Writer {
+	return p.w
+}
+
+// Print writes v as a single JSON or YAML document. It does nothing in the text
+// format, where commands print their own tables.
+func (p *Printer) Print(v any) error {
+	switch p.format {
+	case FormatJSON:
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.w, "%s\n", data)
+		return err
+	case FormatYAML:
+		return p.encodeYAML(v)
+	}
+	return nil
+}
+
+// Event is one entry of the event stream of a long-running command.
+type Event struct {
+	Type string    `json:"event"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+}
+
+// Emit writes an event: one JSON object per line, or one YAML document per
+// event. It does nothing in the text format.
+func (p *Printer) Emit(eventType string, data any) {
+	e := Event{Type: eventType, Time: time.Now().UTC(), Data: data}
+	var err error
+	switch p.format {
+	case FormatJSON:
+		err = json.NewEncoder(p.w).Encode(e)
+	case FormatYAML:
+		err = p.encodeYAML(e)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️  Failed to write event '%s': %v\n", eventType, err)
+	}
+}
+
+// encodeYAML writes v with the keys named and ordered as in its JSON encoding.
+func (p *Printer) encodeYAML(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return err
+	}
+	blockStyle(&node)
+
+	// A single encoder separates the documents with '---'
+	if p.yaml == nil {
+		p.yaml = yaml.NewEncoder(p.w)
+		p.yaml.SetIndent(2)
+	}
+	return p.yaml.Encode(&node)
+}
+
+// blockStyle drops the flow style and quoting the JSON input was parsed with.
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
@@ -0,0 +1,196 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/prune"
+	"github.com/rollwave-dev/rollwave/internal/release"
+	"github.com/rollwave-dev/rollwave/internal/rollback"
+	"github.com/rollwave-dev/rollwave/internal/rollout"
+	"github.com/rollwave-dev/rollwave/internal/stack"
+)
+
+// Options defines the parameters for deploying a rendered compose file.
+type Options struct {
+	Stack        string
+	ReleaseStack string // stack the release is recorded for; defaults to Stack
+	LiveStack    string // stack currently running the release history; defaults to Stack
+	Environment  string
+	Compose      []byte            // rendered compose file (interpolated, images replaced, secrets rewritten)
+	Secrets      map[string]string // logical -> physical secret name
+	Variables    map[string]string // hashed into the release record
+
+	// RollbackOf is set when an older release is redeployed; the services are
+	// then labelled with that release instead of the new one.
+	RollbackOf int
+
+	AutoRollback bool
+	// Snapshot is the state an auto-rollback restores. Callers that change the
+	// stack before Deploy (canaries) capture it first; otherwise Deploy does.
+	Snapshot *rollback.Snapshot
+
+	// Services limits the update to these compose services; the other services
+	// keep running as they are. All are deployed if empty.
+	Services []string
+
+	// Verify runs once the stack converged. The release is only recorded as
+	// succeeded if it passes; otherwise the deploy fails like a failed rollout.
+	// It is skipped for detached deploys.
+	Verify func(ctx context.Context) error
+
+	Detach       bool
+	Timeout      time.Duration
+	Prune        bool
+	SecretPrefix string // secrets.stack_prefix, selects the secrets to prune
+	Driver       string // config.DriverNative or config.DriverCLI
+
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Deploy stamps release metadata onto the compose file, deploys the stack, waits for
+// it to converge and records the release. On failure the stack is optionally rolled
+// back to the specs captured before the deploy.
+func Deploy(ctx context.Context, cli engine.Engine, opt Options) (*release.Release, error) {
+	if opt.Stdout == nil {
+		opt.Stdout = os.Stdout
+	}
+	if opt.Stderr == nil {
+		opt.Stderr = os.Stderr
+	}
+
+	if opt.ReleaseStack == "" {
+		opt.ReleaseStack = opt.Stack
+	}
+	if opt.LiveStack == "" {
+		opt.LiveStack = opt.Stack
+	}
+
+	// Stamp release metadata onto the services so 'rollwave rollback' can find it
+	history, err := release.Discover(ctx, cli, opt.ReleaseStack, opt.LiveStack)
+	if err != nil {
+		return nil, fmt.Errorf("read release history: %w", err)
+	}
+	rel, err := release.New(history.NextID(), release.Options{
+		Stack:       opt.ReleaseStack,
+		Environment: opt.Environment,
+		Compose:     opt.Compose,
+		Secrets:     opt.Secrets,
+		Variables:   opt.Variables,
+	})
+	if err != nil {
+		return nil, err
+	}
+	rel.RollbackOf = opt.RollbackOf
+
+	current := rel.ID
+	if opt.RollbackOf != 0 {
+		current = opt.RollbackOf
+	}
+	composeYaml, err := release.Stamp(opt.Compose, current, history.Append(rel))
+	if err != nil {
+		return nil, fmt.Errorf("stamp release labels: %w", err)
+	}
+	fmt.Fprintf(opt.Stdout, "🏷️  Release #%d\n", rel.ID)
+
+	// Capture the current service specs so a failed deploy can be reverted
+	var snapshot *rollback.Snapshot
+	if opt.AutoRollback && opt.Snapshot != nil {
+		snapshot = opt.Snapshot
+	} else if opt.AutoRollback {
+		snapshot, err = rollback.Capture(ctx, cli, opt.Stack)
+		if err != nil {
+			return nil, fmt.Errorf("capture pre-deploy state: %w", err)
+		}
+	}
+
+	// Persist the release record for 'rollwave history'
+	record := func(status string, deployErr error) {
+		rel.Status = status
+		if deployErr != nil {
+			rel.Error = deployErr.Error()
+		}
+		// Use a fresh context: the deploy context may already be cancelled
+		if err := release.Save(context.Background(), cli, rel); err != nil {
+			fmt.Fprintf(opt.Stderr, "⚠️  Failed to record release: %v\n", err)
+		}
+	}
+
+	fail := func(deployErr error) (*release.Release, error) {
+		if snapshot == nil {
+			record(release.StatusFailed, deployErr)
+			return &rel, fmt.Errorf("deployment failed: %w", deployErr)
+		}
+		if len(snapshot.Services) == 0 {
+			record(release.StatusFailed, deployErr)
+			fmt.Fprintln(opt.Stderr, "⚠️  Nothing to roll back: stack had no services before this deploy.")
+			return &rel, fmt.Errorf("deployment failed: %w", deployErr)
+		}
+
+		fmt.Fprintf(opt.Stderr, "❌ Deployment failed: %v\n", deployErr)
+		res := rollback.Restore(context.Background(), cli, snapshot, opt.Stdout)
+		fmt.Fprintf(opt.Stdout, "📋 Rollback summary:\n%s", res.Summary())
+		if len(res.Errors) > 0 {
+			record(release.StatusFailed, deployErr)
+			return &rel, fmt.Errorf("deployment failed and rollback was incomplete: %w", deployErr)
+		}
+		record(release.StatusRolledBack, deployErr)
+		return &rel, fmt.Errorf("deployment failed and was rolled back: %w", deployErr)
+	}
+
+	res, err := stack.Deploy(ctx, stack.DeployOptions{
+		Name:     opt.Stack,
+		Compose:  composeYaml,
+		Services: opt.Services,
+		Driver:   opt.Driver,
+		Engine:   cli,
+		Stdout:   opt.Stdout,
+		Stderr:   opt.Stderr,
+	})
+	if res != nil {
+		for _, s := range res.Services {
+			rel.Changes = append(rel.Changes, release.Change{Service: s.Name, Action: s.Action})
+		}
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	// Wait for convergence
+	if opt.Detach {
+		fmt.Fprintln(opt.Stdout, "⏭️  Detached: not waiting for services to converge.")
+		record(release.StatusDetached, nil)
+	} else {
+		if err := rollout.Wait(ctx, cli, rollout.Options{
+			Stack:   opt.Stack,
+			Timeout: opt.Timeout,
+			Stdout:  opt.Stdout,
+		}); err != nil {
+			return fail(err)
+		}
+		if opt.Verify != nil {
+			if err := opt.Verify(ctx); err != nil {
+				return fail(err)
+			}
+		}
+		record(release.StatusSucceeded, nil)
+	}
+
+	fmt.Fprintln(opt.Stdout, "✅ Deployment successful.")
+
+	// --- AUTO PRUNE ---
+	if opt.Prune {
+		fmt.Fprintln(opt.Stdout, "") // New line for separation
+		if _, err := prune.Run(ctx, cli, opt.Stack, opt.SecretPrefix, opt.Stdout, opt.Stderr); err != nil {
+			// We don't fail the deployment if prune fails, just warn
+			fmt.Fprintf(opt.Stderr, "⚠️  Auto-prune failed: %v\n", err)
+		}
+	}
+
+	return &rel, nil
+}
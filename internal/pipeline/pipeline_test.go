@@ -0,0 +1,137 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/release"
+)
+
+func TestDeploy(t *testing.T) {
+	tests := []struct {
+		name         string
+		images       []string // deployed one after the other
+		autoRollback bool
+		failVerify   bool // verification of the last deploy fails
+		wantErr      bool
+		wantStatus   string // of the last release
+		wantImage    string // web runs afterwards
+	}{
+		{
+			name:       "first deploy",
+			images:     []string{"nginx:1"},
+			wantStatus: release.StatusSucceeded,
+			wantImage:  "nginx:1",
+		},
+		{
+			name:       "update",
+			images:     []string{"nginx:1", "nginx:2"},
+			wantStatus: release.StatusSucceeded,
+			wantImage:  "nginx:2",
+		},
+		{
+			name:       "failed deploy without auto-rollback",
+			images:     []string{"nginx:1", "broken:1"},
+			wantErr:    true,
+			wantStatus: release.StatusFailed,
+			wantImage:  "broken:1",
+		},
+		{
+			name:         "failed deploy with auto-rollback",
+			images:       []string{"nginx:1", "broken:1"},
+			autoRollback: true,
+			wantErr:      true,
+			wantStatus:   release.StatusRolledBack,
+			wantImage:    "nginx:1",
+		},
+		{
+			name:       "failed verification",
+			images:     []string{"nginx:1", "nginx:2"},
+			failVerify: true,
+			wantErr:    true,
+			wantStatus: release.StatusFailed,
+			wantImage:  "nginx:2",
+		},
+		{
+			name:         "failed verification with auto-rollback",
+			images:       []string{"nginx:1", "nginx:2"},
+			failVerify:   true,
+			autoRollback: true,
+			wantErr:      true,
+			wantStatus:   release.StatusRolledBack,
+			wantImage:    "nginx:1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cli := engine.NewFake()
+			cli.TaskStatus = func(spec dockerswarm.ServiceSpec) dockerswarm.TaskStatus {
+				if strings.HasPrefix(spec.TaskTemplate.ContainerSpec.Image, "broken") {
+					return dockerswarm.TaskStatus{State: dockerswarm.TaskStateFailed, Err: "exit code 1"}
+				}
+				return dockerswarm.TaskStatus{State: dockerswarm.TaskStateRunning}
+			}
+
+			var rel *release.Release
+			var err error
+			for i, image := range tt.images {
+				var verify func(context.Context) error
+				if tt.failVerify && i == len(tt.images)-1 {
+					verify = func(context.Context) error { return errors.New("GET /healthz: status 500") }
+				}
+				rel, err = Deploy(ctx, cli, Options{
+					Stack:        "shop",
+					Compose:      []byte("services:\n  web:\n    image: " + image + "\n"),
+					AutoRollback: tt.autoRollback,
+					Verify:       verify,
+					Timeout:      3 * time.Second,
+					Stdout:       io.Discard,
+					Stderr:       io.Discard,
+				})
+				if i < len(tt.images)-1 && err != nil {
+					t.Fatalf("deploy %s: %v", image, err)
+				}
+			}
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %v", err, tt.wantErr)
+			}
+			if rel == nil {
+				t.Fatal("no release returned")
+			}
+			if rel.ID != len(tt.images) {
+				t.Errorf("release ID = %d, want %d", rel.ID, len(tt.images))
+			}
+			if rel.Status != tt.wantStatus {
+				t.Errorf("release status = %q, want %q", rel.Status, tt.wantStatus)
+			}
+
+			records, err := release.List(ctx, cli, "shop")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != len(tt.images) {
+				t.Errorf("%d release records, want %d", len(records), len(tt.images))
+			}
+
+			svc, _, err := cli.ServiceInspectWithRaw(ctx, "shop_web", types.ServiceInspectOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			image, _, _ := strings.Cut(svc.Spec.TaskTemplate.ContainerSpec.Image, "@")
+			if image != tt.wantImage {
+				t.Errorf("web runs %s, want %s", image, tt.wantImage)
+			}
+		})
+	}
+}
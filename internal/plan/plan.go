@@ -0,0 +1,368 @@
+package plan
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+
+	"github.com/rollwave-dev/rollwave/internal/compose"
+	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/release"
+	"github.com/rollwave-dev/rollwave/internal/swarm"
+)
+
+// FormatVersion is bumped whenever the plan file layout changes incompatibly.
+const FormatVersion = 3
+
+// Kinds of changes a plan can contain.
+const (
+	KindService  = "service"
+	KindSecret   = "secret"
+	KindVariable = "variable"
+)
+
+// Actions a change can perform.
+const (
+	ActionAdd    = "add"
+	ActionRemove = "remove"
+	ActionUpdate = "update"
+	ActionCreate = "create"
+)
+
+// Build is an image that has to be built and pushed before deploying.
+type Build struct {
+	Service    string `json:"service"`
+	ImageName  string `json:"image_name"`
+	Tag        string `json:"tag"`
+	Context    string `json:"context"`
+	Dockerfile string `json:"dockerfile"`
+}
+
+// Image returns the full image reference including the tag.
+func (b Build) Image() string {
+	return b.ImageName + ":" + b.Tag
+}
+
+// Change is a single difference between the live stack and the plan.
+type Change struct {
+	Kind   string `json:"kind"`
+	Action string `json:"action"`
+	Name   string `json:"name"`
+	Detail string `json:"detail,omitempty"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+func (c Change) String() string {
+	symbol := map[string]string{
+		ActionAdd:    "+",
+		ActionCreate: "+",
+		ActionRemove: "-",
+		ActionUpdate: "~",
+	}[c.Action]
+
+	s := fmt.Sprintf("%s %s %s", symbol, c.Kind, c.Name)
+	if c.Detail != "" {
+		s += " " + c.Detail
+	}
+	switch {
+	case c.From != "" && c.To != "":
+		s += fmt.Sprintf(": %s -> %s", c.From, c.To)
+	case c.To != "":
+		s += ": " + c.To
+	case c.From != "":
+		s += ": " + c.From
+	}
+	return s
+}
+
+// Plan is a fully rendered deploy that can be saved and applied later.
+type Plan struct {
+	Version     int       `json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+	Stack       string    `json:"stack"`
+	Environment string    `json:"environment,omitempty"`
+
+	// The configuration is read again on apply for hooks, migrations, the deploy
+	// strategy, verification and notifications; the hash detects changes to it.
+	ConfigPath string `json:"config_path"`
+	ConfigHash string `json:"config_hash"`
+
+	Compose      string            `json:"compose"` // rendered compose file
+	Builds       []Build           `json:"builds,omitempty"`
+	Secrets      map[string]string `json:"secrets,omitempty"` // logical -> physical secret name
+	SecretPrefix string            `json:"secret_prefix,omitempty"`
+	// Where the secret values are read from when the plan is applied
+	SecretFile    string                         `json:"secret_file,omitempty"`
+	SecretDirs    []string                       `json:"secret_dirs,omitempty"`
+	SecretSources map[string]config.SecretSource `json:"secret_sources,omitempty"`
+	SecretVault   *config.VaultConfig            `json:"secret_vault,omitempty"`
+
+	Variables map[string]string `json:"variables,omitempty"`
+
+	Prune        bool   `json:"prune"`
+	AutoRollback bool   `json:"auto_rollback"`
+	Timeout      string `json:"timeout,omitempty"`
+	Driver       string `json:"driver,omitempty"`
+
+	// Fingerprint identifies the live service specs the plan was computed against.
+	Fingerprint string   `json:"fingerprint"`
+	Changes     []Change `json:"changes"`
+}
+
+// Write saves the plan as JSON.
+func (p *Plan) Write(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// Read loads a plan saved with Write.
+func Read(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parse plan '%s': %w", path, err)
+	}
+	if p.Version != FormatVersion {
+		return nil, fmt.Errorf("plan '%s' has unsupported version %d (expected %d)", path, p.Version, FormatVersion)
+	}
+	return &p, nil
+}
+
+// Print writes a human readable summary of the plan.
+func (p *Plan) Print(w io.Writer) {
+	for _, b := range p.Builds {
+		fmt.Fprintf(w, "🏗️  build %s: %s\n", b.Service, b.Image())
+	}
+	if len(p.Changes) == 0 {
+		fmt.Fprintln(w, "✨ No changes. The stack matches the configuration.")
+		return
+	}
+	for _, c := range p.Changes {
+		fmt.Fprintf(w, "   %s\n", c)
+	}
+
+	counts := make(map[string]int)
+	for _, c := range p.Changes {
+		counts[c.Action]++
+	}
+	fmt.Fprintf(w, "\nPlan: %d to add, %d to change, %d to remove, %d secrets to create.\n",
+		counts[ActionAdd], counts[ActionUpdate], counts[ActionRemove], counts[ActionCreate])
+}
+
+// ConfigHash hashes the configuration merged with the environment.
+func ConfigHash(cfg *config.Config) (string, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return "", fmt.Errorf("hash config: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Fingerprint hashes the IDs and versions of the live services in the stack.
+// Any service update, creation or removal changes the fingerprint.
+func Fingerprint(ctx context.Context, cli engine.Swarm, stack string) (string, error) {
+	services, err := listServices(ctx, cli, stack)
+	if err != nil {
+		return "", err
+	}
+
+	lines := make([]string, 0, len(services))
+	for _, svc := range services {
+		lines = append(lines, fmt.Sprintf("%s %s %d", svc.Spec.Name, svc.ID, svc.Version.Index))
+	}
+	sort.Strings(lines)
+
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Diff compares the rendered compose file, secrets and variables with the live stack.
+// liveStack is the Swarm stack running the services, the active colour under blue/green.
+func Diff(ctx context.Context, cli engine.Swarm, p *Plan, liveStack string) ([]Change, error) {
+	var changes []Change
+
+	services, err := listServices(ctx, cli, liveStack)
+	if err != nil {
+		return nil, err
+	}
+	live := make(map[string]dockerswarm.Service)
+	for _, svc := range services {
+		live[strings.TrimPrefix(svc.Spec.Name, liveStack+"_")] = svc
+	}
+
+	desiredImages, err := compose.ServiceImages([]byte(p.Compose))
+	if err != nil {
+		return nil, fmt.Errorf("read images: %w", err)
+	}
+	desiredSecrets, err := compose.ServiceSecrets([]byte(p.Compose), p.Stack)
+	if err != nil {
+		return nil, fmt.Errorf("read secrets: %w", err)
+	}
+
+	// 1. Services
+	for _, name := range sortedKeys(desiredSecrets) {
+		image := desiredImages[name]
+		svc, exists := live[name]
+		if !exists {
+			changes = append(changes, Change{Kind: KindService, Action: ActionAdd, Name: name, To: image})
+			continue
+		}
+
+		spec := svc.Spec.TaskTemplate.ContainerSpec
+		if spec == nil {
+			continue
+		}
+		if from := trimDigest(spec.Image); normalizeImage(from) != normalizeImage(image) {
+			changes = append(changes, Change{Kind: KindService, Action: ActionUpdate, Name: name, Detail: "image", From: from, To: image})
+		}
+
+		var liveSecrets []string
+		for _, s := range spec.Secrets {
+			liveSecrets = append(liveSecrets, s.SecretName)
+		}
+		from, to := strings.Join(sorted(liveSecrets), ","), strings.Join(sorted(desiredSecrets[name]), ",")
+		if from != to {
+			changes = append(changes, Change{Kind: KindService, Action: ActionUpdate, Name: name, Detail: "secrets", From: orNone(from), To: orNone(to)})
+		}
+	}
+	for _, name := range sortedKeys(live) {
+		if _, ok := desiredSecrets[name]; !ok {
+			change := Change{Kind: KindService, Action: ActionRemove, Name: name}
+			if spec := live[name].Spec.TaskTemplate.ContainerSpec; spec != nil {
+				change.From = trimDigest(spec.Image)
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	// 2. New secret versions
+	if len(p.Secrets) > 0 {
+		f := filters.NewArgs()
+		for _, name := range p.Secrets {
+			f.Add("name", name)
+		}
+		existing, err := cli.SecretList(ctx, types.SecretListOptions{Filters: f})
+		if err != nil {
+			return nil, fmt.Errorf("list secrets: %w", err)
+		}
+		found := make(map[string]bool)
+		for _, s := range existing {
+			found[s.Spec.Name] = true
+		}
+		for _, key := range sortedKeys(p.Secrets) {
+			if !found[p.Secrets[key]] {
+				changes = append(changes, Change{Kind: KindSecret, Action: ActionCreate, Name: key, To: p.Secrets[key]})
+			}
+		}
+	}
+
+	// 3. Variables, compared with the last successful release
+	records, err := release.List(ctx, cli, p.Stack)
+	if err != nil {
+		return nil, err
+	}
+	var previous release.Release
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Status == release.StatusSucceeded {
+			previous = records[i]
+			break
+		}
+	}
+	changes = append(changes, diffVariables(previous, p.Variables)...)
+
+	return changes, nil
+}
+
+// diffVariables compares the variables of the plan with the value hashes of
+// a release; the previous values themselves are not recorded.
+func diffVariables(rel release.Release, to map[string]string) []Change {
+	var changes []Change
+	from := rel.VariableHashes
+	toHashes := rel.HashValues(to)
+	for _, k := range sortedKeys(to) {
+		old, ok := from[k]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Kind: KindVariable, Action: ActionAdd, Name: k, To: to[k]})
+		case old != toHashes[k]:
+			changes = append(changes, Change{Kind: KindVariable, Action: ActionUpdate, Name: k, To: to[k]})
+		}
+	}
+	for _, k := range sortedKeys(from) {
+		if _, ok := to[k]; !ok {
+			changes = append(changes, Change{Kind: KindVariable, Action: ActionRemove, Name: k})
+		}
+	}
+	return changes
+}
+
+// --- Helpers ---
+
+func listServices(ctx context.Context, cli engine.Swarm, stack string) ([]dockerswarm.Service, error) {
+	f := filters.NewArgs()
+	f.Add("label", swarm.StackLabel+"="+stack)
+
+	services, err := cli.ServiceList(ctx, types.ServiceListOptions{Filters: f})
+	if err != nil {
+		return nil, fmt.Errorf("list services: %w", err)
+	}
+	return services, nil
+}
+
+func trimDigest(image string) string {
+	if idx := strings.Index(image, "@sha256"); idx != -1 {
+		return image[:idx]
+	}
+	return image
+}
+
+// normalizeImage adds the implicit ':latest' tag so 'app' and 'app:latest' compare equal.
+func normalizeImage(image string) string {
+	last := image[strings.LastIndex(image, "/")+1:]
+	if image != "" && !strings.Contains(last, ":") {
+		return image + ":latest"
+	}
+	return image
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
+
+func sorted(in []string) []string {
+	out := append([]string(nil), in...)
+	sort.Strings(out)
+	return out
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
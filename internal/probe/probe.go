@@ -0,0 +1,57 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTP describes a single HTTP check.
+type HTTP struct {
+	URL          string
+	ExpectStatus int           // defaults to 200
+	BodyContains string        // optional substring the response body must contain
+	Timeout      time.Duration // per request, defaults to 10s
+}
+
+// Check performs the request once and returns an error if the response does not match.
+func (h HTTP) Check(ctx context.Context) error {
+	expect := h.ExpectStatus
+	if expect == 0 {
+		expect = http.StatusOK
+	}
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", h.URL, err)
+	}
+	defer resp.Body.Close()
+
+	// Limit how much of the body is read; checks only need a marker near the top
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("GET %s: read body: %w", h.URL, err)
+	}
+
+	if resp.StatusCode != expect {
+		return fmt.Errorf("GET %s: status %d, expected %d", h.URL, resp.StatusCode, expect)
+	}
+	if h.BodyContains != "" && !strings.Contains(string(body), h.BodyContains) {
+		return fmt.Errorf("GET %s: body does not contain %q", h.URL, h.BodyContains)
+	}
+	return nil
+}
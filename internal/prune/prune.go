@@ -2,15 +2,35 @@ package prune
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
-	"os/exec"
+	"regexp"
 	"strings"
+
+	"github.com/docker/docker/api/types"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/secrets"
+	"github.com/rollwave-dev/rollwave/internal/swarm"
 )
 
-// Run identifies and removes unused secrets for the given stack.
-func Run(ctx context.Context, stackName string, stdout, stderr io.Writer) error {
+// Run identifies and removes unused secrets that Rollwave created for the given
+// stack and secrets prefix, and returns the secrets it deleted. Secrets are
+// matched by their labels, so those of other stacks and tools are never touched.
+func Run(ctx context.Context, cli engine.Swarm, stackName, prefix string, stdout, stderr io.Writer) ([]SecretInfo, error) {
+	return run(ctx, cli, stackName, prefix, false, stdout, stderr)
+}
+
+// RunLegacy is like Run, but also removes unused unlabelled secrets that are
+// named like the ones Rollwave created before it labelled its secrets; see
+// legacyMatcher. A name cannot prove who created a secret, so this is only done
+// on request.
+func RunLegacy(ctx context.Context, cli engine.Swarm, stackName, prefix string, stdout, stderr io.Writer) ([]SecretInfo, error) {
+	return run(ctx, cli, stackName, prefix, true, stdout, stderr)
+}
+
+func run(ctx context.Context, cli engine.Swarm, stackName, prefix string, legacy bool, stdout, stderr io.Writer) ([]SecretInfo, error) {
 	if stdout == nil {
 		stdout = io.Discard
 	}
@@ -20,133 +40,132 @@ func Run(ctx context.Context, stackName string, stdout, stderr io.Writer) error
 
 	fmt.Fprintf(stdout, "🧹 Pruning secrets for stack '%s'...\n", stackName)
 
-	// 1. List all secrets belonging to this stack
-	secrets, err := listSecrets(ctx, stackName)
+	// All services in the Swarm, not only the stack: blue/green deployments run
+	// the stack's services under '<stack>_blue' / '<stack>_green'.
+	services, err := cli.ServiceList(ctx, types.ServiceListOptions{})
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("list services: %w", err)
 	}
 
-	// 2. Get list of secrets currently used by services
-	usedSecretIDs, err := getUsedSecretIDs(ctx, stackName)
+	// 1. List all secrets belonging to this stack
+	owned, err := listSecrets(ctx, cli, stackName, prefix, legacy, services)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	// 2. Get list of secrets currently used by services
+	usedSecretIDs := getUsedSecretIDs(services)
+
 	// 3. Compare and delete
-	deletedCount := 0
-	for _, s := range secrets {
+	deleted := []SecretInfo{}
+	for _, s := range owned {
 		// If secret ID is not in the used list -> DELETE
 		if !usedSecretIDs[s.ID] {
 			fmt.Fprintf(stdout, "   Deleting unused secret: %s\n", s.Name)
-			if err := removeSecret(ctx, s.ID); err != nil {
+			if err := cli.SecretRemove(ctx, s.ID); err != nil {
 				fmt.Fprintf(stderr, "   ⚠️ Failed to remove %s: %v\n", s.Name, err)
 			} else {
-				deletedCount++
+				deleted = append(deleted, s)
 			}
 		}
 	}
 
-	if deletedCount == 0 {
+	if len(deleted) == 0 {
 		fmt.Fprintln(stdout, "✨ No unused secrets found. Clean.")
 	} else {
-		fmt.Fprintf(stdout, "🗑️  Deleted %d secrets.\n", deletedCount)
+		fmt.Fprintf(stdout, "🗑️  Deleted %d secrets.\n", len(deleted))
 	}
 
-	return nil
+	return deleted, nil
 }
 
 // --- Helpers ---
 
 type SecretInfo struct {
-	ID   string
-	Name string
+	ID   string `json:"id"`
+	Name string `json:"name"`
 }
 
-func listSecrets(ctx context.Context, stackPrefix string) ([]SecretInfo, error) {
-	// docker secret ls --format json
-	cmd := exec.CommandContext(ctx, "docker", "secret", "ls", "--format", "{{json .}}")
-	out, err := cmd.Output()
+func listSecrets(ctx context.Context, cli engine.Swarm, stack, prefix string, legacy bool, services []dockerswarm.Service) ([]SecretInfo, error) {
+	// Secrets created before labels have none to filter on, so list them all
+	list, err := cli.SecretList(ctx, types.SecretListOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("list secrets: %w", err)
 	}
 
-	var secrets []SecretInfo
-	// Docker sometimes returns JSON objects separated by newlines
-	lines := strings.Split(string(out), "\n")
-	for _, line := range lines {
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-		var s struct {
-			ID   string `json:"ID"`
-			Name string `json:"Name"`
-		}
-		if err := json.Unmarshal([]byte(line), &s); err != nil {
-			continue
-		}
-
-		// Filter: We are only interested in secrets for this stack
-		if strings.HasPrefix(s.Name, stackPrefix+"_") {
-			secrets = append(secrets, SecretInfo{ID: s.ID, Name: s.Name})
+	unlabelled := func(dockerswarm.SecretSpec) bool { return false }
+	if legacy {
+		unlabelled = legacyMatcher(stack, prefix, list, services)
+	}
+	var out []SecretInfo
+	for _, s := range list {
+		// Filter: We are only interested in secrets for this stack and prefix
+		if secrets.OwnedBy(s.Spec.Labels, stack, prefix) || unlabelled(s.Spec) {
+			out = append(out, SecretInfo{ID: s.ID, Name: s.Spec.Name})
 		}
 	}
-	return secrets, nil
+	return out, nil
 }
 
-func getUsedSecretIDs(ctx context.Context, stackName string) (map[string]bool, error) {
-	// 1. Get IDs of all services in the stack
-	cmd := exec.CommandContext(ctx, "docker", "stack", "services", stackName, "--format", "{{.ID}}")
-	out, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("list services: %w", err)
+// legacyMatcher returns a function reporting whether an unlabelled secret is
+// named like one Rollwave created for the stack and prefix before it labelled
+// its secrets. A name alone cannot tell 'app_v2_DB_PASSWORD_1a2b3c4d' of stack
+// 'app_v2' from a 'v2_DB_PASSWORD' key of 'app', so keys must be upper case, as
+// ROLLWAVE_SECRET_* variables are, and names starting with a longer stack or
+// another prefix seen in the Swarm are never matched.
+func legacyMatcher(stack, prefix string, list []dockerswarm.Secret, services []dockerswarm.Service) func(dockerswarm.SecretSpec) bool {
+	base := stack + "_"
+	if prefix != "" {
+		base += prefix + "_"
 	}
-
-	serviceIDs := strings.Split(strings.TrimSpace(string(out)), "\n")
-	var validIDs []string
-	for _, id := range serviceIDs {
-		if strings.TrimSpace(id) != "" {
-			validIDs = append(validIDs, strings.TrimSpace(id))
+	name := regexp.MustCompile("^" + regexp.QuoteMeta(base) + "[A-Z0-9_]+_[0-9a-f]{8}$")
+
+	var others []string
+	claim := func(otherStack, otherPrefix string) {
+		switch {
+		case otherStack != stack && strings.HasPrefix(otherStack+"_", base):
+			others = append(others, otherStack+"_")
+		case otherStack == stack && otherPrefix != prefix && strings.HasPrefix(stack+"_"+otherPrefix+"_", base):
+			others = append(others, stack+"_"+otherPrefix+"_")
 		}
 	}
-
-	used := make(map[string]bool)
-	if len(validIDs) == 0 {
-		return used, nil
+	for _, s := range list {
+		if s.Spec.Labels[secrets.LabelManagedBy] == secrets.ManagedBy {
+			claim(s.Spec.Labels[secrets.LabelStack], s.Spec.Labels[secrets.LabelPrefix])
+		}
 	}
-
-	// 2. Retrieve secret list for each service
-	args := append([]string{"service", "inspect", "--format", "{{json .Spec.TaskTemplate.ContainerSpec.Secrets}}"}, validIDs...)
-	cmdInspect := exec.CommandContext(ctx, "docker", args...)
-	outInspect, err := cmdInspect.Output()
-	if err != nil {
-		return nil, fmt.Errorf("inspect services: %w", err)
+	for _, svc := range services {
+		if otherStack := svc.Spec.Labels[swarm.StackLabel]; otherStack != "" {
+			claim(otherStack, prefix)
+		}
 	}
 
-	lines := strings.Split(string(outInspect), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" || line == "null" {
-			continue
+	return func(spec dockerswarm.SecretSpec) bool {
+		if len(spec.Labels) > 0 || !name.MatchString(spec.Name) {
+			return false
 		}
-
-		var serviceSecrets []struct {
-			SecretID string `json:"SecretID"`
+		for _, other := range others {
+			if strings.HasPrefix(spec.Name, other) {
+				return false
+			}
 		}
+		return true
+	}
+}
 
-		if err := json.Unmarshal([]byte(line), &serviceSecrets); err != nil {
-			return nil, fmt.Errorf("parse inspect line '%s': %w", line, err)
+func getUsedSecretIDs(services []dockerswarm.Service) map[string]bool {
+	used := make(map[string]bool)
+	for _, svc := range services {
+		cs := svc.Spec.TaskTemplate.ContainerSpec
+		if cs == nil {
+			continue
 		}
-
-		for _, s := range serviceSecrets {
+		for _, s := range cs.Secrets {
 			if s.SecretID != "" {
 				used[s.SecretID] = true
 			}
 		}
 	}
 
-	return used, nil
-}
-
-func removeSecret(ctx context.Context, id string) error {
-	return exec.CommandContext(ctx, "docker", "secret", "rm", id).Run()
+	return used
 }
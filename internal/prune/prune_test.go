@@ -0,0 +1,161 @@
+package prune
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/secrets"
+	"github.com/rollwave-dev/rollwave/internal/swarm"
+)
+
+func TestRun(t *testing.T) {
+	owned := func(stack, prefix string) map[string]string {
+		return map[string]string{
+			secrets.LabelManagedBy: secrets.ManagedBy,
+			secrets.LabelStack:     stack,
+			secrets.LabelPrefix:    prefix,
+		}
+	}
+
+	tests := []struct {
+		name        string
+		secret      string
+		labels      map[string]string
+		used        bool
+		otherStack  string // a stack with a service in the Swarm
+		legacy      bool   // RunLegacy instead of Run
+		wantDeleted bool
+	}{
+		{
+			name:        "unused secret of the stack",
+			secret:      "app_DB_PASSWORD_1a2b3c4d",
+			labels:      owned("app", ""),
+			wantDeleted: true,
+		},
+		{
+			name:   "secret in use",
+			secret: "app_DB_PASSWORD_1a2b3c4d",
+			labels: owned("app", ""),
+			used:   true,
+		},
+		{
+			name:   "stack sharing the name prefix",
+			secret: "app_v2_DB_PASSWORD_1a2b3c4d",
+			labels: owned("app_v2", ""),
+		},
+		{
+			name:   "other secrets prefix",
+			secret: "app_api_DB_PASSWORD_1a2b3c4d",
+			labels: owned("app", "api"),
+		},
+		{
+			name:   "secret of another tool",
+			secret: "app_tls_cert",
+			labels: map[string]string{"com.example.owner": "certbot"},
+		},
+		{
+			name:   "unlabelled secret of the stack",
+			secret: "app_DB_PASSWORD_1a2b3c4d",
+		},
+		{
+			name:        "legacy: labelled secret of the stack",
+			secret:      "app_DB_PASSWORD_1a2b3c4d",
+			labels:      owned("app", ""),
+			legacy:      true,
+			wantDeleted: true,
+		},
+		{
+			name:        "legacy: unlabelled secret of the stack",
+			secret:      "app_DB_PASSWORD_1a2b3c4d",
+			legacy:      true,
+			wantDeleted: true,
+		},
+		{
+			name:   "legacy: unlabelled secret in use",
+			secret: "app_DB_PASSWORD_1a2b3c4d",
+			used:   true,
+			legacy: true,
+		},
+		{
+			name:   "legacy: unlabelled secret with a lower-case key",
+			secret: "app_v2_DB_PASSWORD_1a2b3c4d",
+			legacy: true,
+		},
+		{
+			name:       "legacy: unlabelled secret of a longer stack",
+			secret:     "app_V2_DB_PASSWORD_1a2b3c4d",
+			otherStack: "app_V2",
+			legacy:     true,
+		},
+		{
+			name:   "legacy: labelled secret of another stack",
+			secret: "app_V2_DB_PASSWORD_1a2b3c4d",
+			labels: owned("app_V2", ""),
+			legacy: true,
+		},
+		{
+			name:   "legacy: unlabelled secret of another tool",
+			secret: "app_tls_cert",
+			legacy: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cli := engine.NewFake()
+
+			resp, err := cli.SecretCreate(ctx, dockerswarm.SecretSpec{
+				Annotations: dockerswarm.Annotations{Name: tt.secret, Labels: tt.labels},
+				Data:        []byte("s3cret"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.used {
+				if _, err := cli.ServiceCreate(ctx, dockerswarm.ServiceSpec{
+					Annotations: dockerswarm.Annotations{Name: "app_blue_web"},
+					TaskTemplate: dockerswarm.TaskSpec{ContainerSpec: &dockerswarm.ContainerSpec{
+						Image:   "nginx",
+						Secrets: []*dockerswarm.SecretReference{{SecretID: resp.ID, SecretName: tt.secret}},
+					}},
+				}, types.ServiceCreateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tt.otherStack != "" {
+				if _, err := cli.ServiceCreate(ctx, dockerswarm.ServiceSpec{
+					Annotations:  dockerswarm.Annotations{Name: tt.otherStack + "_web", Labels: map[string]string{swarm.StackLabel: tt.otherStack}},
+					TaskTemplate: dockerswarm.TaskSpec{ContainerSpec: &dockerswarm.ContainerSpec{Image: "nginx"}},
+				}, types.ServiceCreateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			run := Run
+			if tt.legacy {
+				run = RunLegacy
+			}
+			deleted, err := run(ctx, cli, "app", "", nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(deleted) == 1; got != tt.wantDeleted {
+				t.Errorf("deleted = %v, want deleted: %v", deleted, tt.wantDeleted)
+			}
+
+			left, err := cli.SecretList(ctx, types.SecretListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if remains := len(left) == 1; remains == tt.wantDeleted {
+				t.Errorf("%d secrets left in the Swarm", len(left))
+			}
+		})
+	}
+}
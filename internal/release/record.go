@@ -0,0 +1,85 @@
+package release
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+
+	"github.com/rollwave-dev/rollwave/internal/engine"
+)
+
+// Labels identifying release records (Docker config objects).
+const (
+	LabelKind  = "io.rollwave.kind"
+	LabelStack = "io.rollwave.stack"
+
+	kindRelease = "release"
+)
+
+// Save persists the release as an immutable Docker config object, so every
+// machine talking to the Swarm sees the same history.
+func Save(ctx context.Context, cli engine.Swarm, r Release) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = cli.ConfigCreate(ctx, dockerswarm.ConfigSpec{
+		Annotations: dockerswarm.Annotations{
+			Name: recordName(r.Stack, r.ID),
+			Labels: map[string]string{
+				LabelKind:    kindRelease,
+				LabelStack:   r.Stack,
+				LabelRelease: strconv.Itoa(r.ID),
+			},
+		},
+		Data: data,
+	})
+	if err != nil {
+		return fmt.Errorf("save release #%d: %w", r.ID, err)
+	}
+	return nil
+}
+
+// List returns all release records of a stack, oldest first.
+func List(ctx context.Context, cli engine.Swarm, stack string) ([]Release, error) {
+	f := filters.NewArgs()
+	f.Add("label", LabelKind+"="+kindRelease)
+	f.Add("label", LabelStack+"="+stack)
+
+	configs, err := cli.ConfigList(ctx, types.ConfigListOptions{Filters: f})
+	if err != nil {
+		return nil, fmt.Errorf("list release records: %w", err)
+	}
+
+	out := make([]Release, 0, len(configs))
+	for _, c := range configs {
+		data := c.Spec.Data
+		if len(data) == 0 {
+			full, _, err := cli.ConfigInspectWithRaw(ctx, c.ID)
+			if err != nil {
+				return nil, fmt.Errorf("inspect release record %s: %w", c.Spec.Name, err)
+			}
+			data = full.Spec.Data
+		}
+
+		var r Release
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, fmt.Errorf("parse release record %s: %w", c.Spec.Name, err)
+		}
+		out = append(out, r)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func recordName(stack string, id int) string {
+	return fmt.Sprintf("%s_rollwave_release_%d", stack, id)
+}
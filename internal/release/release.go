@@ -0,0 +1,344 @@
+package release
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+
+	"github.com/rollwave-dev/rollwave/internal/compose"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/swarm"
+)
+
+// Labels stamped onto every service of a stack at deploy time.
+const (
+	LabelRelease = "io.rollwave.release" // ID of the release currently deployed
+	LabelHistory = "io.rollwave.history" // JSON list of recent releases
+)
+
+// MaxHistory is the number of releases kept in the history label.
+const MaxHistory = 10
+
+// Release describes everything needed to redeploy a previous version without rebuilding.
+type Release struct {
+	ID          int               `json:"id"`
+	Stack       string            `json:"stack,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	GitSHA      string            `json:"git_sha,omitempty"`
+	DeployedAt  time.Time         `json:"deployed_at"`
+	DeployedBy  string            `json:"deployed_by,omitempty"`
+	Images      map[string]string `json:"images,omitempty"`  // service -> image
+	Secrets     map[string]string `json:"secrets,omitempty"` // logical -> physical secret name
+
+	// Fields below are only kept in the release record, not in the service labels.
+	// Variables may hold credentials and release records are readable by anyone
+	// with access to the Swarm, so only keyed hashes of their values are stored;
+	// see HashValues.
+	VariableKey    string            `json:"variable_key,omitempty"`    // random HMAC key, hex encoded
+	VariableHashes map[string]string `json:"variable_hashes,omitempty"` // variable -> HMAC-SHA256 of its value
+	VariablesHash  string            `json:"variables_hash,omitempty"`
+	ComposeHash    string            `json:"compose_hash,omitempty"`
+	Status         string            `json:"status,omitempty"`
+	Error          string            `json:"error,omitempty"`
+	RollbackOf     int               `json:"rollback_of,omitempty"` // set when the release redeployed an older one
+	Changes        []Change          `json:"changes,omitempty"`     // per-service result of the native deploy driver
+}
+
+// Change records what a deploy did with a single service.
+type Change struct {
+	Service string `json:"service"`
+	Action  string `json:"action"` // created, updated, unchanged or removed
+}
+
+// Release statuses stored in the release record.
+const (
+	StatusSucceeded  = "succeeded"
+	StatusFailed     = "failed"
+	StatusRolledBack = "rolled back"
+	StatusDetached   = "submitted"
+)
+
+// Options defines the inputs for a new release.
+type Options struct {
+	Stack       string
+	Environment string
+	Compose     []byte            // rendered compose file, before release labels are stamped
+	Secrets     map[string]string // logical -> physical secret name
+	Variables   map[string]string
+}
+
+// History is the release metadata discovered on a running stack.
+type History struct {
+	Current  int       // ID of the release currently deployed (0 if unknown)
+	Releases []Release // sorted by ID, oldest first
+}
+
+// Discover reads the release history of a stack from the release records stored
+// in the Swarm and from the release labels of its services. liveStack is the
+// Swarm stack running the services; under blue/green it is the active colour,
+// otherwise the stack itself.
+func Discover(ctx context.Context, cli engine.Swarm, stack, liveStack string) (*History, error) {
+	f := filters.NewArgs()
+	f.Add("label", swarm.StackLabel+"="+liveStack)
+
+	services, err := cli.ServiceList(ctx, types.ServiceListOptions{Filters: f})
+	if err != nil {
+		return nil, fmt.Errorf("list services: %w", err)
+	}
+
+	records, err := List(ctx, cli, stack)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &History{}
+	byID := make(map[int]Release)
+
+	for _, svc := range services {
+		labels := svc.Spec.Labels
+		if id, err := strconv.Atoi(labels[LabelRelease]); err == nil && id > h.Current {
+			h.Current = id
+		}
+
+		raw := labels[LabelHistory]
+		if raw == "" {
+			continue
+		}
+		var releases []Release
+		if err := json.Unmarshal([]byte(raw), &releases); err != nil {
+			return nil, fmt.Errorf("parse release history of %s: %w", svc.Spec.Name, err)
+		}
+		for _, r := range releases {
+			byID[r.ID] = r
+		}
+	}
+
+	// Records are authoritative and carry the full details
+	for _, r := range records {
+		byID[r.ID] = r
+	}
+
+	for _, r := range byID {
+		h.Releases = append(h.Releases, r)
+	}
+	sort.Slice(h.Releases, func(i, j int) bool { return h.Releases[i].ID < h.Releases[j].ID })
+
+	return h, nil
+}
+
+// Get returns the release with the given ID.
+func (h *History) Get(id int) (Release, bool) {
+	for _, r := range h.Releases {
+		if r.ID == id {
+			return r, true
+		}
+	}
+	return Release{}, false
+}
+
+// Previous returns the newest release older than the current one, skipping
+// releases that are known to have failed.
+func (h *History) Previous() (Release, bool) {
+	for i := len(h.Releases) - 1; i >= 0; i-- {
+		switch h.Releases[i].Status {
+		case StatusFailed, StatusRolledBack:
+			continue
+		}
+		if h.Releases[i].ID < h.Current {
+			return h.Releases[i], true
+		}
+	}
+	return Release{}, false
+}
+
+// NextID returns the ID for a new release.
+func (h *History) NextID() int {
+	next := h.Current
+	for _, r := range h.Releases {
+		if r.ID > next {
+			next = r.ID
+		}
+	}
+	return next + 1
+}
+
+// New builds a release for the rendered compose file.
+func New(id int, opt Options) (Release, error) {
+	images, err := compose.ServiceImages(opt.Compose)
+	if err != nil {
+		return Release{}, fmt.Errorf("read images: %w", err)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return Release{}, fmt.Errorf("generate variable key: %w", err)
+	}
+
+	r := Release{
+		ID:          id,
+		Stack:       opt.Stack,
+		Environment: opt.Environment,
+		GitSHA:      GitSHA(),
+		DeployedAt:  time.Now().UTC(),
+		DeployedBy:  Deployer(),
+		Images:      images,
+		Secrets:     opt.Secrets,
+		VariableKey: hex.EncodeToString(key),
+		ComposeHash: hashBytes(opt.Compose),
+	}
+	r.VariableHashes = r.HashValues(opt.Variables)
+	r.VariablesHash = r.hashVariables(opt.Variables)
+	return r, nil
+}
+
+// Stamp labels every service in the compose file with the current release ID
+// and the release history (including current, trimmed to MaxHistory).
+func Stamp(composeYaml []byte, current int, history []Release) ([]byte, error) {
+	if len(history) > MaxHistory {
+		history = history[len(history)-MaxHistory:]
+	}
+
+	// Keep labels small: only what is needed to redeploy
+	compact := make([]Release, 0, len(history))
+	for _, r := range history {
+		compact = append(compact, Release{
+			ID:         r.ID,
+			GitSHA:     r.GitSHA,
+			DeployedAt: r.DeployedAt,
+			Images:     r.Images,
+			Secrets:    r.Secrets,
+		})
+	}
+
+	raw, err := json.Marshal(compact)
+	if err != nil {
+		return nil, err
+	}
+
+	return compose.SetServiceLabels(composeYaml, map[string]string{
+		LabelRelease: strconv.Itoa(current),
+		LabelHistory: string(raw),
+	})
+}
+
+// Append returns the history with r added, replacing any release with the same ID.
+func (h *History) Append(r Release) []Release {
+	out := make([]Release, 0, len(h.Releases)+1)
+	for _, existing := range h.Releases {
+		if existing.ID != r.ID {
+			out = append(out, existing)
+		}
+	}
+	return append(out, r)
+}
+
+// ChangedVariables returns the names of the variables in vars whose values
+// differ from those the release was deployed with, including variables added or
+// removed since. It returns nil for releases recorded without variable hashes.
+func (r Release) ChangedVariables(vars map[string]string) []string {
+	if r.VariablesHash == "" {
+		return nil
+	}
+	hashes := r.HashValues(vars)
+	var changed []string
+	for k, h := range hashes {
+		if r.VariableHashes[k] != h {
+			changed = append(changed, k)
+		}
+	}
+	for k := range r.VariableHashes {
+		if _, ok := hashes[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// HashValues returns an HMAC-SHA256 of every value, keyed with the release's
+// random VariableKey, so values can be compared with those of the release
+// without being stored in it, and low-entropy values cannot be found by hashing
+// guesses. Releases recorded before the key existed use plain SHA-256.
+func (r Release) HashValues(vars map[string]string) map[string]string {
+	if len(vars) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(vars))
+	for k, v := range vars {
+		out[k] = r.hashValue([]byte(v))
+	}
+	return out
+}
+
+func (r Release) hashVariables(vars map[string]string) string {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%s\n", k, vars[k])
+	}
+	return r.hashValue([]byte(b.String()))
+}
+
+func (r Release) hashValue(data []byte) string {
+	key, err := hex.DecodeString(r.VariableKey)
+	if err != nil || len(key) == 0 {
+		return hashBytes(data)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Deployer identifies who runs the deploy: ROLLWAVE_DEPLOYER, the git user email,
+// or the local user and host name.
+func Deployer() string {
+	if d := os.Getenv("ROLLWAVE_DEPLOYER"); d != "" {
+		return d
+	}
+	if out, err := exec.Command("git", "config", "user.email").Output(); err == nil {
+		if email := strings.TrimSpace(string(out)); email != "" {
+			return email
+		}
+	}
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		name += "@" + host
+	}
+	return name
+}
+
+// GitSHA returns the current git commit or an empty string if git is unavailable.
+func GitSHA() string {
+	out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
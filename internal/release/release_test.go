@@ -0,0 +1,68 @@
+package release
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestHashValues(t *testing.T) {
+	vars := map[string]string{"DB_PASSWORD": "hunter2", "REPLICAS": "3"}
+	a, err := New(1, Options{Stack: "shop", Compose: []byte("services: {}\n"), Variables: vars})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(2, Options{Stack: "shop", Compose: []byte("services: {}\n"), Variables: vars})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain := sha256.Sum256([]byte("hunter2"))
+	for _, r := range []Release{a, b} {
+		if r.VariableHashes["DB_PASSWORD"] == hex.EncodeToString(plain[:]) {
+			t.Errorf("release #%d stores the plain SHA-256 of the value", r.ID)
+		}
+	}
+	if a.VariableHashes["DB_PASSWORD"] == b.VariableHashes["DB_PASSWORD"] {
+		t.Errorf("releases share the hash of an equal value")
+	}
+	if a.VariablesHash == b.VariablesHash {
+		t.Errorf("releases share the hash of equal variables")
+	}
+	if !reflect.DeepEqual(a.HashValues(vars), a.VariableHashes) {
+		t.Errorf("HashValues does not reproduce the recorded hashes")
+	}
+}
+
+func TestChangedVariables(t *testing.T) {
+	recorded := map[string]string{"DB_PASSWORD": "hunter2", "REPLICAS": "3", "REGION": "eu"}
+	keyed, err := New(1, Options{Stack: "shop", Compose: []byte("services: {}\n"), Variables: recorded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Recorded before releases had a key
+	legacy := Release{ID: 1, VariablesHash: "old", VariableHashes: map[string]string{}}
+	for k, v := range recorded {
+		sum := sha256.Sum256([]byte(v))
+		legacy.VariableHashes[k] = hex.EncodeToString(sum[:])
+	}
+
+	current := map[string]string{"DB_PASSWORD": "hunter2", "REPLICAS": "5", "TAG": "2"}
+	want := []string{"REGION", "REPLICAS", "TAG"}
+
+	for name, r := range map[string]Release{"keyed": keyed, "legacy": legacy} {
+		t.Run(name, func(t *testing.T) {
+			if got := r.ChangedVariables(recorded); got != nil {
+				t.Errorf("ChangedVariables(same values) = %v, want none", got)
+			}
+			if got := r.ChangedVariables(current); !reflect.DeepEqual(got, want) {
+				t.Errorf("ChangedVariables = %v, want %v", got, want)
+			}
+		})
+	}
+
+	if got := (Release{ID: 1}).ChangedVariables(current); got != nil {
+		t.Errorf("release without hashes: ChangedVariables = %v, want none", got)
+	}
+}
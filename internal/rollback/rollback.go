@@ -0,0 +1,215 @@
+package rollback
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+
+	"github.com/rollwave-dev/rollwave/internal/build"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/swarm"
+)
+
+// Snapshot holds the service specs of a stack as they were before a deploy.
+type Snapshot struct {
+	Stack    string
+	Services map[string]dockerswarm.ServiceSpec // keyed by full service name
+}
+
+// Result summarizes what Restore changed.
+type Result struct {
+	Reverted []string // services whose previous spec was restored
+	Removed  []string // services created by the failed deploy
+	Restored []string // services removed by the failed deploy and created again
+	Changes  []string // human readable description of reverted images/secrets
+	Errors   []error
+}
+
+// Capture records the current spec of every service in the stack.
+func Capture(ctx context.Context, cli engine.Swarm, stack string) (*Snapshot, error) {
+	services, err := listServices(ctx, cli, stack)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &Snapshot{
+		Stack:    stack,
+		Services: make(map[string]dockerswarm.ServiceSpec, len(services)),
+	}
+	for _, svc := range services {
+		snap.Services[svc.Spec.Name] = svc.Spec
+	}
+	return snap, nil
+}
+
+// Restore brings every service of the stack back to the spec recorded in the snapshot.
+// Services that did not exist before are removed, and services that disappeared are recreated.
+// It keeps going after individual failures and reports them in Result.Errors.
+func Restore(ctx context.Context, cli engine.Swarm, snap *Snapshot, stdout io.Writer) *Result {
+	if stdout == nil {
+		stdout = io.Discard
+	}
+
+	res := &Result{}
+
+	fmt.Fprintf(stdout, "⏪ Rolling back stack '%s' to its previous state...\n", snap.Stack)
+
+	current, err := listServices(ctx, cli, snap.Stack)
+	if err != nil {
+		res.Errors = append(res.Errors, err)
+		return res
+	}
+
+	seen := make(map[string]bool)
+	for _, svc := range current {
+		name := svc.Spec.Name
+		seen[name] = true
+
+		prev, existed := snap.Services[name]
+		if !existed {
+			fmt.Fprintf(stdout, "   Removing new service: %s\n", name)
+			if err := cli.ServiceRemove(ctx, svc.ID); err != nil {
+				res.Errors = append(res.Errors, fmt.Errorf("remove %s: %w", name, err))
+				continue
+			}
+			res.Removed = append(res.Removed, name)
+			continue
+		}
+
+		res.Changes = append(res.Changes, describeChanges(name, svc.Spec, prev)...)
+
+		auth, err := build.RegistryAuth(imageOf(prev))
+		if err != nil {
+			res.Errors = append(res.Errors, fmt.Errorf("registry auth for %s: %w", name, err))
+			continue
+		}
+
+		fmt.Fprintf(stdout, "   Reverting service: %s\n", name)
+		resp, err := cli.ServiceUpdate(ctx, svc.ID, svc.Version, prev, types.ServiceUpdateOptions{
+			EncodedRegistryAuth: auth,
+		})
+		if err != nil {
+			res.Errors = append(res.Errors, fmt.Errorf("revert %s: %w", name, err))
+			continue
+		}
+		for _, w := range resp.Warnings {
+			fmt.Fprintf(stdout, "   ⚠️  %s\n", w)
+		}
+		res.Reverted = append(res.Reverted, name)
+	}
+
+	for name, spec := range snap.Services {
+		if seen[name] {
+			continue
+		}
+
+		auth, err := build.RegistryAuth(imageOf(spec))
+		if err != nil {
+			res.Errors = append(res.Errors, fmt.Errorf("registry auth for %s: %w", name, err))
+			continue
+		}
+
+		fmt.Fprintf(stdout, "   Recreating removed service: %s\n", name)
+		if _, err := cli.ServiceCreate(ctx, spec, types.ServiceCreateOptions{EncodedRegistryAuth: auth}); err != nil {
+			res.Errors = append(res.Errors, fmt.Errorf("recreate %s: %w", name, err))
+			continue
+		}
+		res.Restored = append(res.Restored, name)
+	}
+
+	sort.Strings(res.Reverted)
+	sort.Strings(res.Removed)
+	sort.Strings(res.Restored)
+
+	return res
+}
+
+// Summary renders the result as a multi-line report.
+func (r *Result) Summary() string {
+	var b strings.Builder
+	if len(r.Reverted) > 0 {
+		fmt.Fprintf(&b, "reverted: %s\n", strings.Join(r.Reverted, ", "))
+	}
+	for _, c := range r.Changes {
+		fmt.Fprintf(&b, "   %s\n", c)
+	}
+	if len(r.Removed) > 0 {
+		fmt.Fprintf(&b, "removed: %s\n", strings.Join(r.Removed, ", "))
+	}
+	if len(r.Restored) > 0 {
+		fmt.Fprintf(&b, "recreated: %s\n", strings.Join(r.Restored, ", "))
+	}
+	for _, err := range r.Errors {
+		fmt.Fprintf(&b, "error: %v\n", err)
+	}
+	if b.Len() == 0 {
+		return "nothing to revert\n"
+	}
+	return b.String()
+}
+
+// --- Helpers ---
+
+func listServices(ctx context.Context, cli engine.Swarm, stack string) ([]dockerswarm.Service, error) {
+	f := filters.NewArgs()
+	f.Add("label", swarm.StackLabel+"="+stack)
+
+	services, err := cli.ServiceList(ctx, types.ServiceListOptions{Filters: f})
+	if err != nil {
+		return nil, fmt.Errorf("list services: %w", err)
+	}
+	return services, nil
+}
+
+// describeChanges lists the image and secret differences between the failed and previous spec.
+func describeChanges(name string, failed, prev dockerswarm.ServiceSpec) []string {
+	var out []string
+
+	failedImage := imageOf(failed)
+	prevImage := imageOf(prev)
+	if failedImage != prevImage {
+		out = append(out, fmt.Sprintf("%s: image %s -> %s", name, failedImage, prevImage))
+	}
+
+	failedSecrets := secretNames(failed)
+	prevSecrets := secretNames(prev)
+	for target, prevName := range prevSecrets {
+		if failedName, ok := failedSecrets[target]; ok && failedName != prevName {
+			out = append(out, fmt.Sprintf("%s: secret %s -> %s", name, failedName, prevName))
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func secretNames(spec dockerswarm.ServiceSpec) map[string]string {
+	out := make(map[string]string)
+	if spec.TaskTemplate.ContainerSpec == nil {
+		return out
+	}
+	for _, s := range spec.TaskTemplate.ContainerSpec.Secrets {
+		target := s.SecretName
+		if s.File != nil {
+			target = s.File.Name
+		}
+		out[target] = s.SecretName
+	}
+	return out
+}
+
+func imageOf(spec dockerswarm.ServiceSpec) string {
+	if spec.TaskTemplate.ContainerSpec == nil {
+		return ""
+	}
+	image := spec.TaskTemplate.ContainerSpec.Image
+	if idx := strings.Index(image, "@sha256"); idx != -1 {
+		return image[:idx]
+	}
+	return image
+}
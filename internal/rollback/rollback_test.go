@@ -0,0 +1,156 @@
+package rollback
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/swarm"
+)
+
+func TestCaptureRestore(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, cli *engine.Fake) // applied between Capture and Restore
+
+		wantReverted []string
+		wantRemoved  []string
+		wantRestored []string
+	}{
+		{
+			name: "updated service is reverted",
+			change: func(t *testing.T, cli *engine.Fake) {
+				setImage(t, cli, "shop_web", "nginx:2")
+			},
+			wantReverted: []string{"shop_db", "shop_web"},
+		},
+		{
+			name: "new service is removed",
+			change: func(t *testing.T, cli *engine.Fake) {
+				createService(t, cli, "shop_worker", "worker:1")
+			},
+			wantReverted: []string{"shop_db", "shop_web"},
+			wantRemoved:  []string{"shop_worker"},
+		},
+		{
+			name: "removed service is recreated",
+			change: func(t *testing.T, cli *engine.Fake) {
+				if err := cli.ServiceRemove(context.Background(), "shop_db"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantReverted: []string{"shop_web"},
+			wantRestored: []string{"shop_db"},
+		},
+		{
+			name: "other stacks are left alone",
+			change: func(t *testing.T, cli *engine.Fake) {
+				setImage(t, cli, "blog_web", "ghost:2")
+			},
+			wantReverted: []string{"shop_db", "shop_web"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cli := engine.NewFake()
+			createService(t, cli, "shop_web", "nginx:1")
+			createService(t, cli, "shop_db", "postgres:16")
+			createService(t, cli, "blog_web", "ghost:1")
+
+			snap, err := Capture(ctx, cli, "shop")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(snap.Services) != 2 {
+				t.Fatalf("captured %d services, want 2", len(snap.Services))
+			}
+
+			tt.change(t, cli)
+
+			res := Restore(ctx, cli, snap, io.Discard)
+			if len(res.Errors) > 0 {
+				t.Fatalf("restore errors: %v", res.Errors)
+			}
+			check := func(what string, got, want []string) {
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%s = %v, want %v", what, got, want)
+				}
+			}
+			check("reverted", res.Reverted, tt.wantReverted)
+			check("removed", res.Removed, tt.wantRemoved)
+			check("restored", res.Restored, tt.wantRestored)
+
+			images := make(map[string]string)
+			for _, name := range []string{"shop_web", "shop_db"} {
+				svc, _, err := cli.ServiceInspectWithRaw(ctx, name, types.ServiceInspectOptions{})
+				if err != nil {
+					t.Fatalf("%s after restore: %v", name, err)
+				}
+				images[name] = svc.Spec.TaskTemplate.ContainerSpec.Image
+			}
+			want := map[string]string{"shop_web": "nginx:1", "shop_db": "postgres:16"}
+			if !reflect.DeepEqual(images, want) {
+				t.Errorf("images after restore = %v, want %v", images, want)
+			}
+
+			names, err := serviceNames(ctx, cli, "shop")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(names, []string{"shop_db", "shop_web"}) {
+				t.Errorf("services after restore = %v", names)
+			}
+		})
+	}
+}
+
+func createService(t *testing.T, cli *engine.Fake, name, image string) {
+	t.Helper()
+	stack, _, _ := strings.Cut(name, "_")
+	if _, err := cli.ServiceCreate(context.Background(), dockerswarm.ServiceSpec{
+		Annotations: dockerswarm.Annotations{Name: name, Labels: map[string]string{swarm.StackLabel: stack}},
+		TaskTemplate: dockerswarm.TaskSpec{
+			ContainerSpec: &dockerswarm.ContainerSpec{Image: image},
+		},
+	}, types.ServiceCreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setImage(t *testing.T, cli *engine.Fake, name, image string) {
+	t.Helper()
+	ctx := context.Background()
+	svc, _, err := cli.ServiceInspectWithRaw(ctx, name, types.ServiceInspectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := svc.Spec
+	cs := *spec.TaskTemplate.ContainerSpec
+	cs.Image = image
+	spec.TaskTemplate.ContainerSpec = &cs
+	if _, err := cli.ServiceUpdate(ctx, svc.ID, svc.Version, spec, types.ServiceUpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func serviceNames(ctx context.Context, cli engine.Swarm, stack string) ([]string, error) {
+	services, err := listServices(ctx, cli, stack)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, svc := range services {
+		names = append(names, svc.Spec.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,239 @@
+package rollout
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/swarm"
+)
+
+// DefaultTimeout is used when neither the config nor the CLI specify one.
+const DefaultTimeout = 5 * time.Minute
+
+// Options defines the parameters for waiting on a stack.
+type Options struct {
+	Stack    string
+	Timeout  time.Duration
+	Interval time.Duration
+	Stdout   io.Writer
+}
+
+// ServiceProgress describes the rollout state of a single service.
+type ServiceProgress struct {
+	Name      string
+	Running   int
+	Desired   int
+	Global    bool
+	State     string // Swarm UpdateStatus state ("updating", "completed", ...) or empty
+	Message   string // Last update message or task error
+	Converged bool
+	Failed    bool
+}
+
+func (p ServiceProgress) String() string {
+	replicas := fmt.Sprintf("%d/%d", p.Running, p.Desired)
+	if p.Global {
+		replicas = fmt.Sprintf("%d/%d (global)", p.Running, p.Desired)
+	}
+	state := p.State
+	if state == "" {
+		state = "-"
+	}
+	s := fmt.Sprintf("%s: %s running, update %s", p.Name, replicas, state)
+	if p.Message != "" {
+		s += " (" + p.Message + ")"
+	}
+	return s
+}
+
+// Wait blocks until every service in the stack runs its desired number of up-to-date
+// replicas, printing progress whenever a service changes state.
+// It returns an error if a service update is paused or rolled back, or if the timeout expires.
+func Wait(ctx context.Context, cli engine.Swarm, opt Options) error {
+	if opt.Stdout == nil {
+		opt.Stdout = io.Discard
+	}
+	if opt.Timeout <= 0 {
+		opt.Timeout = DefaultTimeout
+	}
+	if opt.Interval <= 0 {
+		opt.Interval = 2 * time.Second
+	}
+
+	fmt.Fprintf(opt.Stdout, "⏳ Waiting for stack '%s' to converge (timeout %s)...\n", opt.Stack, opt.Timeout)
+
+	ctx, cancel := context.WithTimeout(ctx, opt.Timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(opt.Interval)
+	defer ticker.Stop()
+
+	last := make(map[string]string)
+	var progress []ServiceProgress
+
+	for {
+		select {
+		case <-ctx.Done():
+			return timeoutError(opt.Stack, opt.Timeout, progress)
+		case <-ticker.C:
+		}
+
+		var err error
+		progress, err = Check(ctx, cli, opt.Stack)
+		if err != nil {
+			if ctx.Err() != nil {
+				return timeoutError(opt.Stack, opt.Timeout, progress)
+			}
+			return err
+		}
+
+		done := true
+		var failed []string
+		for _, p := range progress {
+			line := p.String()
+			if last[p.Name] != line {
+				fmt.Fprintf(opt.Stdout, "   %s\n", line)
+				last[p.Name] = line
+			}
+			if p.Failed {
+				failed = append(failed, p.Name)
+			}
+			if !p.Converged {
+				done = false
+			}
+		}
+
+		if len(failed) > 0 {
+			return fmt.Errorf("services failed to converge: %s", strings.Join(failed, ", "))
+		}
+		if done {
+			fmt.Fprintln(opt.Stdout, "✅ All services converged.")
+			return nil
+		}
+	}
+}
+
+// Check returns the current rollout state of every service in the stack, sorted by name.
+func Check(ctx context.Context, cli engine.Swarm, stack string) ([]ServiceProgress, error) {
+	serviceFilter := filters.NewArgs()
+	serviceFilter.Add("label", swarm.StackLabel+"="+stack)
+
+	services, err := cli.ServiceList(ctx, types.ServiceListOptions{Filters: serviceFilter})
+	if err != nil {
+		return nil, fmt.Errorf("list services: %w", err)
+	}
+
+	taskFilter := filters.NewArgs()
+	taskFilter.Add("label", swarm.StackLabel+"="+stack)
+
+	tasks, err := cli.TaskList(ctx, types.TaskListOptions{Filters: taskFilter})
+	if err != nil {
+		return nil, fmt.Errorf("list tasks: %w", err)
+	}
+
+	tasksByService := make(map[string][]dockerswarm.Task)
+	for _, t := range tasks {
+		tasksByService[t.ServiceID] = append(tasksByService[t.ServiceID], t)
+	}
+
+	out := make([]ServiceProgress, 0, len(services))
+	for _, svc := range services {
+		out = append(out, serviceProgress(stack, svc, tasksByService[svc.ID]))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+func serviceProgress(stack string, svc dockerswarm.Service, tasks []dockerswarm.Task) ServiceProgress {
+	p := ServiceProgress{
+		Name: strings.TrimPrefix(svc.Spec.Name, stack+"_"),
+	}
+
+	image := svc.Spec.TaskTemplate.ContainerSpec.Image
+	latestFailure := time.Time{}
+
+	for _, t := range tasks {
+		if t.DesiredState == dockerswarm.TaskStateRunning {
+			p.Desired++
+		}
+		// Only count tasks that run the current image; old tasks may still be
+		// running while the update has not started yet.
+		if t.Status.State == dockerswarm.TaskStateRunning && t.DesiredState == dockerswarm.TaskStateRunning &&
+			t.Spec.ContainerSpec != nil && t.Spec.ContainerSpec.Image == image {
+			p.Running++
+		}
+		if isFailedTask(t) && t.Status.Timestamp.After(latestFailure) {
+			latestFailure = t.Status.Timestamp
+			p.Message = fmt.Sprintf("task %s: %s", t.Status.State, taskError(t))
+		}
+	}
+
+	switch {
+	case svc.Spec.Mode.Replicated != nil && svc.Spec.Mode.Replicated.Replicas != nil:
+		p.Desired = int(*svc.Spec.Mode.Replicated.Replicas)
+	case svc.Spec.Mode.Global != nil:
+		p.Global = true
+	}
+
+	if svc.UpdateStatus != nil {
+		p.State = string(svc.UpdateStatus.State)
+		if svc.UpdateStatus.Message != "" {
+			p.Message = svc.UpdateStatus.Message
+		}
+	}
+
+	switch dockerswarm.UpdateState(p.State) {
+	case dockerswarm.UpdateStatePaused, dockerswarm.UpdateStateRollbackStarted,
+		dockerswarm.UpdateStateRollbackPaused, dockerswarm.UpdateStateRollbackCompleted:
+		p.Failed = true
+	case "", dockerswarm.UpdateStateCompleted:
+		p.Converged = p.Running >= p.Desired
+	}
+
+	// A converged service should not report stale errors from earlier tasks.
+	if p.Converged {
+		p.Message = ""
+	}
+
+	return p
+}
+
+func isFailedTask(t dockerswarm.Task) bool {
+	switch t.Status.State {
+	case dockerswarm.TaskStateFailed, dockerswarm.TaskStateRejected:
+		return true
+	}
+	return false
+}
+
+func taskError(t dockerswarm.Task) string {
+	if t.Status.Err != "" {
+		return t.Status.Err
+	}
+	if t.Status.ContainerStatus != nil && t.Status.ContainerStatus.ExitCode != 0 {
+		return fmt.Sprintf("exit code %d", t.Status.ContainerStatus.ExitCode)
+	}
+	return t.Status.Message
+}
+
+func timeoutError(stack string, timeout time.Duration, progress []ServiceProgress) error {
+	var pending []string
+	for _, p := range progress {
+		if !p.Converged {
+			pending = append(pending, p.String())
+		}
+	}
+	if len(pending) == 0 {
+		return fmt.Errorf("stack '%s' did not converge within %s", stack, timeout)
+	}
+	return fmt.Errorf("stack '%s' did not converge within %s:\n   %s", stack, timeout, strings.Join(pending, "\n   "))
+}
@@ -0,0 +1,229 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables holding the age identity that decrypts secrets files.
+const (
+	AgeKeyEnv     = "ROLLWAVE_AGE_KEY"      // the key itself, "AGE-SECRET-KEY-1..."
+	AgeKeyFileEnv = "ROLLWAVE_AGE_KEY_FILE" // path to a key file
+)
+
+// EncryptedFile is a secrets file encrypted with age for a list of recipients.
+// The recipients are stored in clear text next to the encrypted values, so the
+// file can be re-encrypted by anyone who can decrypt it.
+type EncryptedFile struct {
+	Path       string
+	Recipients []string          // age public keys, "age1..."
+	Values     map[string]string // decrypted secrets
+}
+
+// encryptedFileYAML is the on-disk layout.
+type encryptedFileYAML struct {
+	Recipients []string `yaml:"recipients"`
+	Data       string   `yaml:"data"` // armored age ciphertext of a YAML mapping
+}
+
+// ErrNoSecretsFile is returned by OpenEncrypted for a file that does not exist.
+var ErrNoSecretsFile = errors.New("secrets file does not exist")
+
+const encryptedFileHeader = "# Encrypted by rollwave. Edit with 'rollwave secrets edit' or 'rollwave secrets set'.\n"
+
+// OpenEncrypted reads and decrypts the secrets file at path. A missing file
+// returns an empty EncryptedFile and an error matching ErrNoSecretsFile.
+func OpenEncrypted(path string) (*EncryptedFile, error) {
+	f := &EncryptedFile{Path: path, Values: make(map[string]string)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return f, fmt.Errorf("%s: %w", path, ErrNoSecretsFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var raw encryptedFileYAML
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	f.Recipients = raw.Recipients
+	if strings.TrimSpace(raw.Data) == "" {
+		return f, nil
+	}
+
+	identities, err := Identities()
+	if err != nil {
+		return nil, err
+	}
+	r, err := age.Decrypt(armor.NewReader(strings.NewReader(raw.Data)), identities...)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt %s: %w", path, err)
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(plain, &f.Values); err != nil {
+		return nil, fmt.Errorf("parse decrypted %s: %w", path, err)
+	}
+	if f.Values == nil {
+		f.Values = make(map[string]string)
+	}
+	return f, nil
+}
+
+// Save encrypts the values for the recipients and writes the file.
+func (f *EncryptedFile) Save() error {
+	if len(f.Recipients) == 0 {
+		return fmt.Errorf("%s has no recipients; add one with 'rollwave secrets recipients add'", f.Path)
+	}
+	recipients := make([]age.Recipient, 0, len(f.Recipients))
+	for _, r := range f.Recipients {
+		parsed, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return fmt.Errorf("recipient '%s': %w", r, err)
+		}
+		recipients = append(recipients, parsed)
+	}
+
+	plain, err := yaml.Marshal(f.Values)
+	if err != nil {
+		return err
+	}
+	var cipher bytes.Buffer
+	aw := armor.NewWriter(&cipher)
+	w, err := age.Encrypt(aw, recipients...)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(plain); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := aw.Close(); err != nil {
+		return err
+	}
+
+	out, err := yaml.Marshal(encryptedFileYAML{Recipients: f.Recipients, Data: cipher.String()})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(f.Path, append([]byte(encryptedFileHeader), out...), 0644)
+}
+
+// Identities returns the age identities from ROLLWAVE_AGE_KEY, the file named by
+// ROLLWAVE_AGE_KEY_FILE, or the default key file, in that order.
+func Identities() ([]age.Identity, error) {
+	if key := os.Getenv(AgeKeyEnv); key != "" {
+		ids, err := age.ParseIdentities(strings.NewReader(key))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", AgeKeyEnv, err)
+		}
+		return ids, nil
+	}
+
+	path := os.Getenv(AgeKeyFileEnv)
+	if path == "" {
+		var err error
+		if path, err = DefaultKeyFile(); err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no age key: set %s or %s, or run 'rollwave secrets keygen'", AgeKeyEnv, AgeKeyFileEnv)
+		}
+	}
+	keyFile, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read age key: %w", err)
+	}
+	defer keyFile.Close()
+	ids, err := age.ParseIdentities(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("age key %s: %w", path, err)
+	}
+	return ids, nil
+}
+
+// DefaultKeyFile is where 'rollwave secrets keygen' stores the key, e.g.
+// ~/.config/rollwave/age.key.
+func DefaultKeyFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("locate age key: %w", err)
+	}
+	return filepath.Join(dir, "rollwave", "age.key"), nil
+}
+
+// OwnRecipients returns the public keys of the X25519 identities in use, so a
+// new secrets file can be encrypted for whoever creates it.
+func OwnRecipients() ([]string, error) {
+	ids, err := Identities()
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, id := range ids {
+		if x, ok := id.(*age.X25519Identity); ok {
+			out = append(out, x.Recipient().String())
+		}
+	}
+	return out, nil
+}
+
+// EncryptedFileProvider reads the secrets of an encrypted secrets file. A
+// missing file supplies no secrets unless Required is set.
+type EncryptedFileProvider struct {
+	Path     string
+	Required bool
+}
+
+func (p EncryptedFileProvider) Load(context.Context) ([]Secret, error) {
+	f, err := OpenEncrypted(p.Path)
+	if errors.Is(err, ErrNoSecretsFile) && !p.Required {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("secrets file: %w", err)
+	}
+
+	out := make([]Secret, 0, len(f.Values))
+	for k, v := range f.Values {
+		out = append(out, Secret{Key: k, Value: v, Source: "encrypted " + p.Path})
+	}
+	return out, nil
+}
+
+// writeFileAtomic replaces path with data so an interrupted write cannot leave a
+// truncated file behind.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,155 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/vault"
+)
+
+// FileProvider reads a single secret from a file. The content is used verbatim,
+// so multiline values like certificates and binary files work unchanged.
+type FileProvider struct {
+	Key      string
+	Path     string
+	Encoding string
+}
+
+func (p FileProvider) Load(context.Context) ([]Secret, error) {
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("secret %s: %w", p.Key, err)
+	}
+	val, err := decode(string(data), p.Encoding)
+	if err != nil {
+		return nil, fmt.Errorf("secret %s: %s: %w", p.Key, p.Path, err)
+	}
+	return []Secret{{Key: p.Key, Value: val, Source: "file " + p.Path}}, nil
+}
+
+// DirProvider reads every regular file in a directory as a secret named after
+// the file, like the /run/secrets layout. Hidden files are skipped.
+type DirProvider struct {
+	Path string
+}
+
+func (p DirProvider) Load(context.Context) ([]Secret, error) {
+	entries, err := os.ReadDir(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("secrets dir: %w", err)
+	}
+
+	var out []Secret
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") || !e.Type().IsRegular() {
+			continue
+		}
+		path := filepath.Join(p.Path, e.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("secrets dir: %w", err)
+		}
+		out = append(out, Secret{Key: e.Name(), Value: string(data), Source: "file " + path})
+	}
+	return out, nil
+}
+
+// ExecProvider runs a shell command and uses its output as the secret, e.g.
+// "pass show db". A single trailing newline is dropped.
+type ExecProvider struct {
+	Key      string
+	Command  string
+	Encoding string
+}
+
+func (p ExecProvider) Load(ctx context.Context) ([]Secret, error) {
+	var stdout, stderr bytes.Buffer
+	c := exec.CommandContext(ctx, "sh", "-c", p.Command)
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	c.Stdin = os.Stdin // password managers may prompt for a passphrase
+
+	if err := c.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("secret %s: '%s' failed: %w: %s", p.Key, p.Command, err, msg)
+		}
+		return nil, fmt.Errorf("secret %s: '%s' failed: %w", p.Key, p.Command, err)
+	}
+
+	val := stdout.String()
+	if strings.HasSuffix(val, "\r\n") {
+		val = strings.TrimSuffix(val, "\r\n")
+	} else {
+		val = strings.TrimSuffix(val, "\n")
+	}
+	val, err := decode(val, p.Encoding)
+	if err != nil {
+		return nil, fmt.Errorf("secret %s: output of '%s': %w", p.Key, p.Command, err)
+	}
+	return []Secret{{Key: p.Key, Value: val, Source: "exec"}}, nil
+}
+
+// VarProvider reads a single secret from an environment variable of any name.
+type VarProvider struct {
+	Key      string
+	Name     string
+	Encoding string
+}
+
+func (p VarProvider) Load(context.Context) ([]Secret, error) {
+	val, ok := os.LookupEnv(p.Name)
+	if !ok {
+		return nil, fmt.Errorf("secret %s: environment variable %s is not set", p.Key, p.Name)
+	}
+	val, err := decode(val, p.Encoding)
+	if err != nil {
+		return nil, fmt.Errorf("secret %s: %w", p.Key, err)
+	}
+	return []Secret{{Key: p.Key, Value: val, Source: "env " + p.Name}}, nil
+}
+
+// VaultProvider reads a single secret from a field of a Vault KV v2 secret.
+type VaultProvider struct {
+	Client   *vault.Client
+	Key      string
+	Path     string
+	Field    string // optional if the secret has a single field
+	Encoding string
+}
+
+func (p VaultProvider) Load(ctx context.Context) ([]Secret, error) {
+	val, err := p.Client.Field(ctx, p.Path, p.Field)
+	if err != nil {
+		return nil, fmt.Errorf("secret %s: %w", p.Key, err)
+	}
+	val, err = decode(val, p.Encoding)
+	if err != nil {
+		return nil, fmt.Errorf("secret %s: %w", p.Key, err)
+	}
+	source := "vault " + p.Path
+	if p.Field != "" {
+		source += "#" + p.Field
+	}
+	return []Secret{{Key: p.Key, Value: val, Source: source}}, nil
+}
+
+// decode converts a value stored with encoding into the raw secret value.
+func decode(val, encoding string) (string, error) {
+	if encoding != config.EncodingBase64 {
+		return val, nil
+	}
+	// Ignore line breaks, e.g. from 'base64' wrapping its output at 76 columns
+	compact := strings.Join(strings.Fields(val), "")
+	data, err := base64.StdEncoding.DecodeString(compact)
+	if err != nil {
+		return "", fmt.Errorf("invalid base64: %w", err)
+	}
+	return string(data), nil
+}
@@ -1,18 +1,107 @@
 package secrets
 
 import (
+	"context"
 	"os"
+	"sort"
 	"strings"
+
+	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/vault"
 )
 
+// EnvPrefix marks environment variables holding secrets.
+const EnvPrefix = "ROLLWAVE_SECRET_"
+
 // Secret represents a single item (key without prefix + value).
 type Secret struct {
-	Key   string // e.g. "DB_PASSWORD"
-	Value string
+	Key    string // e.g. "DB_PASSWORD"
+	Value  string
+	Source string // where the value came from, e.g. "env" or "file ./certs/tls.key"
+}
+
+// Provider supplies secret values.
+type Provider interface {
+	Load(ctx context.Context) ([]Secret, error)
+}
+
+// Providers returns the providers configured in cfg for env, in ascending
+// precedence: ROLLWAVE_SECRET_* variables, the encrypted secrets file, the files
+// in Dirs, then Sources.
+func Providers(cfg config.SecretsConfig, env string) ([]Provider, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	providers := []Provider{EnvProvider{}}
+	path, explicit := cfg.SecretsFile(env)
+	providers = append(providers, EncryptedFileProvider{Path: path, Required: explicit})
+	for _, dir := range cfg.Dirs {
+		providers = append(providers, DirProvider{Path: dir})
+	}
+
+	var vaultClient *vault.Client
+	keys := make([]string, 0, len(cfg.Sources))
+	for k := range cfg.Sources {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		src := cfg.Sources[key]
+		switch {
+		case src.File != "":
+			providers = append(providers, FileProvider{Key: key, Path: src.File, Encoding: src.Encoding})
+		case src.Exec != "":
+			providers = append(providers, ExecProvider{Key: key, Command: src.Exec, Encoding: src.Encoding})
+		case src.Env != "":
+			providers = append(providers, VarProvider{Key: key, Name: src.Env, Encoding: src.Encoding})
+		case src.Vault != "":
+			// One client for all secrets, so it logs in once and reads each path once
+			if vaultClient == nil {
+				var err error
+				if vaultClient, err = vault.New(cfg.Vault); err != nil {
+					return nil, err
+				}
+			}
+			path, field, _ := strings.Cut(src.Vault, "#")
+			providers = append(providers, VaultProvider{Client: vaultClient, Key: key, Path: path, Field: field, Encoding: src.Encoding})
+		}
+	}
+	return providers, nil
+}
+
+// Load returns the secrets of all providers, sorted by key. A key supplied by
+// several providers takes the value of the last one. Without providers only the
+// ROLLWAVE_SECRET_* variables are read.
+func Load(ctx context.Context, providers []Provider) ([]Secret, error) {
+	if providers == nil {
+		providers = []Provider{EnvProvider{}}
+	}
+
+	byKey := make(map[string]Secret)
+	for _, p := range providers {
+		secs, err := p.Load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range secs {
+			byKey[s.Key] = s
+		}
+	}
+
+	out := make([]Secret, 0, len(byKey))
+	for _, s := range byKey {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
 }
 
-// Load returns a list of secrets from the environment + .env file.
-func Load() ([]Secret, error) {
+// EnvProvider reads the ROLLWAVE_SECRET_* environment variables (and the .env
+// file, which is loaded into the environment).
+type EnvProvider struct{}
+
+func (EnvProvider) Load(context.Context) ([]Secret, error) {
 	var out []Secret
 
 	for _, env := range os.Environ() {
@@ -23,14 +112,15 @@ func Load() ([]Secret, error) {
 		}
 		key, val := parts[0], parts[1]
 
-		if !strings.HasPrefix(key, "ROLLWAVE_SECRET_") {
+		if !strings.HasPrefix(key, EnvPrefix) {
 			continue
 		}
 
-		trimmed := strings.TrimPrefix(key, "ROLLWAVE_SECRET_")
+		trimmed := strings.TrimPrefix(key, EnvPrefix)
 		out = append(out, Secret{
-			Key:   trimmed,
-			Value: val,
+			Key:    trimmed,
+			Value:  val,
+			Source: "env",
 		})
 	}
 
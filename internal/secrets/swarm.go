@@ -7,10 +7,32 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+
+	"github.com/rollwave-dev/rollwave/internal/engine"
 )
 
+// Labels stamped on every secret Rollwave creates. Ownership is decided by
+// these labels, never by the name: 'app_v2_DB_...' does not belong to 'app'.
+const (
+	LabelManagedBy = "io.rollwave.managed-by"
+	LabelStack     = "io.rollwave.stack"
+	LabelPrefix    = "io.rollwave.secret.prefix"
+	LabelKey       = "io.rollwave.secret.key"
+	LabelHash      = "io.rollwave.secret.hash"
+	LabelCreatedAt = "io.rollwave.secret.created-at"
+
+	ManagedBy = "rollwave"
+)
+
+// MaxNameLength is the longest secret name Swarm accepts.
+const MaxNameLength = 64
+
 // SyncOptions defines options for secret synchronization.
 type SyncOptions struct {
 	Stack  string
@@ -18,13 +40,20 @@ type SyncOptions struct {
 	DryRun bool
 	Stdout io.Writer
 	Stderr io.Writer
+
+	// Providers supply the secret values; nil reads the ROLLWAVE_SECRET_* variables.
+	Providers []Provider
+
+	// OnCreate, if set, is called for every secret version that was created.
+	OnCreate func(key, name string)
 }
 
 // SecretMap maps the logical name (from docker-compose) to the physical name (in Swarm).
 type SecretMap map[string]string
 
 // EnsureSecrets creates new secret versions if they don't exist and returns the mapping.
-func EnsureSecrets(ctx context.Context, opt SyncOptions) (SecretMap, error) {
+// cli may be nil for dry runs.
+func EnsureSecrets(ctx context.Context, cli engine.Swarm, opt SyncOptions) (SecretMap, error) {
 	if opt.Stdout == nil {
 		opt.Stdout = os.Stdout
 	}
@@ -32,7 +61,7 @@ func EnsureSecrets(ctx context.Context, opt SyncOptions) (SecretMap, error) {
 		opt.Stderr = os.Stderr
 	}
 
-	loadedSecrets, err := Load()
+	loadedSecrets, err := Load(ctx, opt.Providers)
 	if err != nil {
 		return nil, err
 	}
@@ -45,7 +74,8 @@ func EnsureSecrets(ctx context.Context, opt SyncOptions) (SecretMap, error) {
 
 	for _, s := range loadedSecrets {
 		// 1. Calculate content hash (first 8 chars are sufficient)
-		hash := hashString(s.Value)[:8]
+		fullHash := hashString(s.Value)
+		hash := fullHash[:8]
 
 		// 2. Construct physical name: stack_prefix_key_hash
 		physicalName := buildSwarmSecretName(opt.Stack, opt.Prefix, s.Key, hash)
@@ -59,11 +89,26 @@ func EnsureSecrets(ctx context.Context, opt SyncOptions) (SecretMap, error) {
 		}
 
 		// 4. Create secret only if it doesn't exist (idempotency)
-		if !secretExists(ctx, physicalName) {
-			if err := createSecret(ctx, physicalName, s.Value); err != nil {
+		exists, err := secretExists(ctx, cli, physicalName)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			labels := map[string]string{
+				LabelManagedBy: ManagedBy,
+				LabelStack:     opt.Stack,
+				LabelPrefix:    opt.Prefix,
+				LabelKey:       s.Key,
+				LabelHash:      fullHash,
+				LabelCreatedAt: time.Now().UTC().Format(time.RFC3339),
+			}
+			if err := createSecret(ctx, cli, physicalName, s.Value, labels); err != nil {
 				return nil, fmt.Errorf("failed to create secret %s: %w", physicalName, err)
 			}
 			fmt.Fprintf(opt.Stdout, "Created new secret version: %s\n", physicalName)
+			if opt.OnCreate != nil {
+				opt.OnCreate(s.Key, physicalName)
+			}
 		} else {
 			// Secret already exists, do nothing (immutable)
 		}
@@ -88,21 +133,49 @@ func buildSwarmSecretName(stack, prefix, key, hash string) string {
 	if prefix != "" {
 		parts = append(parts, prefix)
 	}
-	parts = append(parts, key, hash)
-	return strings.Join(parts, "_")
+	parts = append(parts, key)
+	base := strings.Join(parts, "_")
+
+	name := base + "_" + hash
+	if len(name) <= MaxNameLength {
+		return name
+	}
+
+	// Too long for Swarm: truncate the readable part and add a digest of it, so
+	// keys sharing a long start still get different names
+	digest := hashString(base)[:8]
+	keep := MaxNameLength - len(digest) - len(hash) - 2
+	return base[:keep] + "_" + digest + "_" + hash
+}
+
+// OwnedBy reports whether a Swarm secret was created by Rollwave for the stack
+// and secrets prefix, judging by its labels.
+func OwnedBy(labels map[string]string, stack, prefix string) bool {
+	return labels[LabelManagedBy] == ManagedBy &&
+		labels[LabelStack] == stack &&
+		labels[LabelPrefix] == prefix
 }
 
-func secretExists(ctx context.Context, name string) bool {
-	// Quick check using inspect
-	cmd := exec.CommandContext(ctx, "docker", "secret", "inspect", name)
-	cmd.Stdout = io.Discard
-	cmd.Stderr = io.Discard
-	return cmd.Run() == nil
+func secretExists(ctx context.Context, cli engine.Swarm, name string) (bool, error) {
+	// The name filter matches prefixes, so compare the names exactly
+	f := filters.NewArgs()
+	f.Add("name", name)
+	list, err := cli.SecretList(ctx, types.SecretListOptions{Filters: f})
+	if err != nil {
+		return false, fmt.Errorf("list secrets: %w", err)
+	}
+	for _, s := range list {
+		if s.Spec.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
-func createSecret(ctx context.Context, name, value string) error {
-	cmd := exec.CommandContext(ctx, "docker", "secret", "create", name, "-")
-	cmd.Stdin = strings.NewReader(value)
-	cmd.Stdout = io.Discard
-	return cmd.Run()
+func createSecret(ctx context.Context, cli engine.Swarm, name, value string, labels map[string]string) error {
+	_, err := cli.SecretCreate(ctx, dockerswarm.SecretSpec{
+		Annotations: dockerswarm.Annotations{Name: name, Labels: labels},
+		Data:        []byte(value),
+	})
+	return err
 }
@@ -0,0 +1,129 @@
+package secrets
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+
+	"github.com/rollwave-dev/rollwave/internal/engine"
+)
+
+// staticProvider supplies fixed secret values.
+type staticProvider []Secret
+
+func (p staticProvider) Load(context.Context) ([]Secret, error) {
+	return p, nil
+}
+
+func TestEnsureSecrets(t *testing.T) {
+	hash := hashString("hunter2")[:8]
+	longKey := strings.Repeat("VERY_LONG_KEY_", 6)
+
+	tests := []struct {
+		name     string
+		prefix   string
+		key      string
+		existing bool // the version already exists
+		dryRun   bool
+
+		wantName    string
+		wantCreated bool
+	}{
+		{
+			name:        "creates a new version",
+			key:         "DB_PASSWORD",
+			wantName:    "shop_DB_PASSWORD_" + hash,
+			wantCreated: true,
+		},
+		{
+			name:        "prefix",
+			prefix:      "api",
+			key:         "DB_PASSWORD",
+			wantName:    "shop_api_DB_PASSWORD_" + hash,
+			wantCreated: true,
+		},
+		{
+			name:     "reuses an existing version",
+			key:      "DB_PASSWORD",
+			existing: true,
+			wantName: "shop_DB_PASSWORD_" + hash,
+		},
+		{
+			name:     "dry run",
+			key:      "DB_PASSWORD",
+			dryRun:   true,
+			wantName: "shop_DB_PASSWORD_" + hash,
+		},
+		{
+			name:        "long names are shortened",
+			key:         longKey,
+			wantName:    "shop_VERY_LONG_KEY_VERY_LONG_KEY_VERY_LONG_KEY_a7527b02_f52fbd32",
+			wantCreated: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fake := engine.NewFake()
+			if tt.existing {
+				if _, err := fake.SecretCreate(ctx, dockerswarm.SecretSpec{
+					Annotations: dockerswarm.Annotations{Name: tt.wantName},
+					Data:        []byte("hunter2"),
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var cli engine.Swarm = fake
+			if tt.dryRun {
+				cli = nil
+			}
+			var created []string
+			mapping, err := EnsureSecrets(ctx, cli, SyncOptions{
+				Stack:     "shop",
+				Prefix:    tt.prefix,
+				DryRun:    tt.dryRun,
+				Stdout:    io.Discard,
+				Stderr:    io.Discard,
+				Providers: []Provider{staticProvider{{Key: tt.key, Value: "hunter2"}}},
+				OnCreate:  func(_, name string) { created = append(created, name) },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := mapping[tt.key]
+			if got != tt.wantName {
+				t.Errorf("physical name = %q, want %q", got, tt.wantName)
+			}
+			if len(got) > 64 {
+				t.Errorf("name %q is longer than Swarm's 64 characters", got)
+			}
+			if (len(created) == 1) != tt.wantCreated {
+				t.Errorf("created = %v, want created: %v", created, tt.wantCreated)
+			}
+
+			list, err := fake.SecretList(ctx, types.SecretListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.dryRun {
+				if len(list) != 0 {
+					t.Errorf("dry run created %d secrets", len(list))
+				}
+				return
+			}
+			if len(list) != 1 {
+				t.Fatalf("%d secrets in the Swarm, want 1", len(list))
+			}
+			if tt.wantCreated && !OwnedBy(list[0].Spec.Labels, "shop", tt.prefix) {
+				t.Errorf("created secret is not labelled as owned: %v", list[0].Spec.Labels)
+			}
+		})
+	}
+}
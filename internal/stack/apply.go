@@ -0,0 +1,387 @@
+package stack
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+
+	"github.com/rollwave-dev/rollwave/internal/build"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/job"
+	"github.com/rollwave-dev/rollwave/internal/swarm"
+)
+
+// LabelSpecHash stores a hash of the service spec as deployed, so unchanged
+// services can be told apart from updated ones.
+const LabelSpecHash = "io.rollwave.spec-hash"
+
+// LabelContentHash stores the SHA-256 of a secret created from a file. Swarm
+// never returns secret data, so the label is what tells a changed file apart.
+const LabelContentHash = "io.rollwave.content-hash"
+
+// Service actions reported by Apply.
+const (
+	ActionCreated   = "created"
+	ActionUpdated   = "updated"
+	ActionUnchanged = "unchanged"
+	ActionRemoved   = "removed"
+)
+
+// ServiceResult is what Apply did with a single service.
+type ServiceResult struct {
+	Name   string // service name without the stack prefix
+	Action string
+	Image  string
+}
+
+// Result lists the services of a deploy, sorted by name.
+type Result struct {
+	Services []ServiceResult
+}
+
+// Changed reports whether any service was created, updated or removed.
+func (r *Result) Changed() bool {
+	for _, s := range r.Services {
+		if s.Action != ActionUnchanged {
+			return true
+		}
+	}
+	return false
+}
+
+// Summary returns one line per service.
+func (r *Result) Summary() string {
+	var b strings.Builder
+	for _, s := range r.Services {
+		icon := "="
+		switch s.Action {
+		case ActionCreated:
+			icon = "+"
+		case ActionUpdated:
+			icon = "~"
+		case ActionRemoved:
+			icon = "-"
+		}
+		fmt.Fprintf(&b, "   %s %s: %s\n", icon, s.Name, s.Action)
+	}
+	return b.String()
+}
+
+// Prepare creates the networks, secrets and configs the stack needs and fills
+// their IDs into the secret and config references of the service specs.
+func Prepare(ctx context.Context, cli engine.Swarm, specs *Specs, stdout io.Writer) error {
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+
+	if err := applyNetworks(ctx, cli, specs.Networks, stdout); err != nil {
+		return err
+	}
+	secretIDs, err := applySecrets(ctx, cli, specs.Secrets, stdout)
+	if err != nil {
+		return err
+	}
+	configIDs, err := applyConfigs(ctx, cli, specs.Configs, stdout)
+	if err != nil {
+		return err
+	}
+
+	for _, spec := range specs.Services {
+		for _, ref := range spec.TaskTemplate.ContainerSpec.Secrets {
+			ref.SecretID = secretIDs[ref.SecretName]
+		}
+		for _, ref := range spec.TaskTemplate.ContainerSpec.Configs {
+			ref.ConfigID = configIDs[ref.ConfigName]
+		}
+	}
+	return nil
+}
+
+// Apply creates the networks, secrets and configs the stack needs and creates,
+// updates or removes its services so they match specs.
+func Apply(ctx context.Context, cli engine.Swarm, stack string, specs *Specs, stdout io.Writer) (*Result, error) {
+	return apply(ctx, cli, stack, specs, true, stdout)
+}
+
+// Update is like Apply but leaves the services that are not in specs alone.
+func Update(ctx context.Context, cli engine.Swarm, stack string, specs *Specs, stdout io.Writer) (*Result, error) {
+	return apply(ctx, cli, stack, specs, false, stdout)
+}
+
+func apply(ctx context.Context, cli engine.Swarm, stack string, specs *Specs, prune bool, stdout io.Writer) (*Result, error) {
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+
+	if err := Prepare(ctx, cli, specs, stdout); err != nil {
+		return nil, err
+	}
+
+	f := filters.NewArgs()
+	f.Add("label", swarm.StackLabel+"="+stack)
+	existing, err := cli.ServiceList(ctx, types.ServiceListOptions{Filters: f})
+	if err != nil {
+		return nil, fmt.Errorf("list services: %w", err)
+	}
+	byName := make(map[string]dockerswarm.Service, len(existing))
+	for _, svc := range existing {
+		byName[svc.Spec.Name] = svc
+	}
+
+	result := &Result{}
+	for _, name := range sortedKeys(specs.Services) {
+		spec := specs.Services[name]
+		action, err := applyService(ctx, cli, spec, byName, stdout)
+		if err != nil {
+			return result, fmt.Errorf("service %s: %w", name, err)
+		}
+		delete(byName, spec.Name)
+		result.Services = append(result.Services, ServiceResult{Name: name, Action: action, Image: spec.Labels[ImageLabel]})
+	}
+
+	// Prune services that are no longer in the compose file
+	if !prune {
+		byName = nil
+	}
+	for _, fullName := range sortedKeys(byName) {
+		svc := byName[fullName]
+		if svc.Spec.Labels[job.LabelJob] != "" || svc.Spec.Labels[swarm.CanaryLabel] != "" {
+			continue // managed by their own commands
+		}
+		fmt.Fprintf(stdout, "   Removing service %s\n", fullName)
+		if err := cli.ServiceRemove(ctx, svc.ID); err != nil {
+			return result, fmt.Errorf("remove service %s: %w", fullName, err)
+		}
+		result.Services = append(result.Services, ServiceResult{
+			Name:   strings.TrimPrefix(fullName, stack+"_"),
+			Action: ActionRemoved,
+			Image:  svc.Spec.Labels[ImageLabel],
+		})
+	}
+	sort.Slice(result.Services, func(i, j int) bool { return result.Services[i].Name < result.Services[j].Name })
+
+	return result, nil
+}
+
+func applyService(ctx context.Context, cli engine.Swarm, spec dockerswarm.ServiceSpec, existing map[string]dockerswarm.Service, stdout io.Writer) (string, error) {
+	cs := spec.TaskTemplate.ContainerSpec
+	auth, err := build.RegistryAuth(cs.Image)
+	if err != nil {
+		return "", fmt.Errorf("registry auth: %w", err)
+	}
+
+	// Pin the image to its digest so every node runs the same image
+	if !strings.Contains(cs.Image, "@") {
+		if info, err := cli.DistributionInspect(ctx, cs.Image, auth); err == nil {
+			cs.Image = cs.Image + "@" + info.Descriptor.Digest.String()
+		} else {
+			fmt.Fprintf(stdout, "   ⚠️  Could not resolve digest of %s, using the tag: %v\n", cs.Image, err)
+		}
+	}
+
+	current, found := existing[spec.Name]
+
+	if r := spec.Mode.Replicated; r != nil && r.Replicas == nil {
+		// No replica count in the compose file: keep the current scale
+		replicas := uint64(1)
+		if found && current.Spec.Mode.Replicated != nil && current.Spec.Mode.Replicated.Replicas != nil {
+			replicas = *current.Spec.Mode.Replicated.Replicas
+		}
+		spec.Mode.Replicated = &dockerswarm.ReplicatedService{Replicas: &replicas}
+	}
+
+	hash, err := specHash(spec)
+	if err != nil {
+		return "", err
+	}
+	spec.Labels = merge(spec.Labels, map[string]string{LabelSpecHash: hash})
+
+	if !found {
+		fmt.Fprintf(stdout, "   Creating service %s\n", spec.Name)
+		if _, err := cli.ServiceCreate(ctx, spec, types.ServiceCreateOptions{EncodedRegistryAuth: auth}); err != nil {
+			return "", fmt.Errorf("create: %w", err)
+		}
+		return ActionCreated, nil
+	}
+
+	// Keep a forced redeploy from being undone
+	spec.TaskTemplate.ForceUpdate = current.Spec.TaskTemplate.ForceUpdate
+
+	action := ActionUpdated
+	if current.Spec.Labels[LabelSpecHash] == hash {
+		// Only rollwave metadata (release labels) may differ; the update does not restart tasks
+		action = ActionUnchanged
+	} else {
+		fmt.Fprintf(stdout, "   Updating service %s\n", spec.Name)
+	}
+
+	resp, err := cli.ServiceUpdate(ctx, current.ID, current.Version, spec, types.ServiceUpdateOptions{EncodedRegistryAuth: auth})
+	if err != nil {
+		return "", fmt.Errorf("update: %w", err)
+	}
+	for _, w := range resp.Warnings {
+		fmt.Fprintf(stdout, "   ⚠️  %s\n", w)
+	}
+	return action, nil
+}
+
+func applyNetworks(ctx context.Context, cli engine.Swarm, networks map[string]NetworkSpec, stdout io.Writer) error {
+	for _, name := range sortedKeys(networks) {
+		n := networks[name]
+		_, err := cli.NetworkInspect(ctx, name, types.NetworkInspectOptions{})
+		switch {
+		case err == nil:
+			continue
+		case !client.IsErrNotFound(err):
+			return fmt.Errorf("inspect network %s: %w", name, err)
+		case n.External:
+			return fmt.Errorf("external network '%s' not found", name)
+		}
+
+		fmt.Fprintf(stdout, "   Creating network %s\n", name)
+		if _, err := cli.NetworkCreate(ctx, name, n.Create); err != nil {
+			return fmt.Errorf("create network %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// applySecrets returns the IDs of the stack's secrets, creating missing ones from
+// their files. Secrets are immutable: a changed file needs a new secret name.
+func applySecrets(ctx context.Context, cli engine.Swarm, secrets map[string]ObjectSpec, stdout io.Writer) (map[string]string, error) {
+	ids := make(map[string]string, len(secrets))
+	for _, name := range sortedKeys(secrets) {
+		s := secrets[name]
+
+		var data []byte
+		var hash string
+		if !s.External {
+			var err error
+			if data, err = os.ReadFile(s.File); err != nil {
+				return nil, fmt.Errorf("secret %s: %w", name, err)
+			}
+			sum := sha256.Sum256(data)
+			hash = hex.EncodeToString(sum[:])
+		}
+
+		f := filters.NewArgs()
+		f.Add("name", name)
+		list, err := cli.SecretList(ctx, types.SecretListOptions{Filters: f})
+		if err != nil {
+			return nil, fmt.Errorf("list secrets: %w", err)
+		}
+		for _, existing := range list {
+			if existing.Spec.Name != name {
+				continue
+			}
+			if !s.External {
+				switch existing.Spec.Labels[LabelContentHash] {
+				case hash:
+				case "":
+					// Created before the label existed, or by 'docker stack deploy'
+					fmt.Fprintf(stdout, "   ⚠️  Secret %s has no content hash, reusing it without comparing its content\n", name)
+				default:
+					return nil, fmt.Errorf("secret '%s' changed but secrets are immutable; give it a new name", name)
+				}
+			}
+			ids[name] = existing.ID
+		}
+		if ids[name] != "" {
+			continue
+		}
+		if s.External {
+			return nil, fmt.Errorf("external secret '%s' not found", name)
+		}
+
+		fmt.Fprintf(stdout, "   Creating secret %s\n", name)
+		resp, err := cli.SecretCreate(ctx, dockerswarm.SecretSpec{
+			Annotations: dockerswarm.Annotations{Name: name, Labels: merge(s.Labels, map[string]string{LabelContentHash: hash})},
+			Data:        data,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("create secret %s: %w", name, err)
+		}
+		ids[name] = resp.ID
+	}
+	return ids, nil
+}
+
+// applyConfigs returns the IDs of the stack's configs, creating missing ones from
+// their files. Configs are immutable: a changed file needs a new config name.
+func applyConfigs(ctx context.Context, cli engine.Swarm, configs map[string]ObjectSpec, stdout io.Writer) (map[string]string, error) {
+	ids := make(map[string]string, len(configs))
+	for _, name := range sortedKeys(configs) {
+		c := configs[name]
+
+		var data []byte
+		if !c.External {
+			var err error
+			if data, err = os.ReadFile(c.File); err != nil {
+				return nil, fmt.Errorf("config %s: %w", name, err)
+			}
+		}
+
+		f := filters.NewArgs()
+		f.Add("name", name)
+		list, err := cli.ConfigList(ctx, types.ConfigListOptions{Filters: f})
+		if err != nil {
+			return nil, fmt.Errorf("list configs: %w", err)
+		}
+		for _, existing := range list {
+			if existing.Spec.Name != name {
+				continue
+			}
+			if !c.External && !bytes.Equal(existing.Spec.Data, data) {
+				return nil, fmt.Errorf("config '%s' changed but configs are immutable; give it a new name", name)
+			}
+			ids[name] = existing.ID
+		}
+		if ids[name] != "" {
+			continue
+		}
+		if c.External {
+			return nil, fmt.Errorf("external config '%s' not found", name)
+		}
+
+		fmt.Fprintf(stdout, "   Creating config %s\n", name)
+		resp, err := cli.ConfigCreate(ctx, dockerswarm.ConfigSpec{
+			Annotations: dockerswarm.Annotations{Name: name, Labels: c.Labels},
+			Data:        data,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("create config %s: %w", name, err)
+		}
+		ids[name] = resp.ID
+	}
+	return ids, nil
+}
+
+// specHash hashes the spec without rollwave's own labels, which change on every deploy.
+func specHash(spec dockerswarm.ServiceSpec) (string, error) {
+	labels := make(map[string]string, len(spec.Labels))
+	for k, v := range spec.Labels {
+		if !strings.HasPrefix(k, "io.rollwave.") {
+			labels[k] = v
+		}
+	}
+	spec.Labels = labels
+
+	raw, err := json.Marshal(spec)
+	if err != nil {
+		return "", fmt.Errorf("hash spec: %w", err)
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])[:16], nil
+}
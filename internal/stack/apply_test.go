@@ -0,0 +1,298 @@
+package stack
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/job"
+	"github.com/rollwave-dev/rollwave/internal/swarm"
+)
+
+func TestApply(t *testing.T) {
+	const deployed = `
+services:
+  web:
+    image: shop/web:2
+    deploy: {replicas: 2}
+  worker:
+    image: shop/worker:2
+`
+
+	tests := []struct {
+		name    string
+		before  string // compose file deployed first, if any
+		compose string
+		update  bool // Update instead of Apply
+		want    []ServiceResult
+	}{
+		{
+			name:    "first deploy",
+			compose: deployed,
+			want: []ServiceResult{
+				{Name: "web", Action: ActionCreated, Image: "shop/web:2"},
+				{Name: "worker", Action: ActionCreated, Image: "shop/worker:2"},
+			},
+		},
+		{
+			name:    "nothing changed",
+			before:  deployed,
+			compose: deployed,
+			want: []ServiceResult{
+				{Name: "web", Action: ActionUnchanged, Image: "shop/web:2"},
+				{Name: "worker", Action: ActionUnchanged, Image: "shop/worker:2"},
+			},
+		},
+		{
+			name:   "image changed",
+			before: deployed,
+			compose: `
+services:
+  web:
+    image: shop/web:3
+    deploy: {replicas: 2}
+  worker:
+    image: shop/worker:2
+`,
+			want: []ServiceResult{
+				{Name: "web", Action: ActionUpdated, Image: "shop/web:3"},
+				{Name: "worker", Action: ActionUnchanged, Image: "shop/worker:2"},
+			},
+		},
+		{
+			name:   "service removed",
+			before: deployed,
+			compose: `
+services:
+  web:
+    image: shop/web:2
+    deploy: {replicas: 2}
+`,
+			want: []ServiceResult{
+				{Name: "web", Action: ActionUnchanged, Image: "shop/web:2"},
+				{Name: "worker", Action: ActionRemoved, Image: "shop/worker:2"},
+			},
+		},
+		{
+			name:   "update leaves other services alone",
+			before: deployed,
+			compose: `
+services:
+  web:
+    image: shop/web:3
+    deploy: {replicas: 2}
+`,
+			update: true,
+			want: []ServiceResult{
+				{Name: "web", Action: ActionUpdated, Image: "shop/web:3"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cli := engine.NewFake()
+
+			if tt.before != "" {
+				if _, err := Apply(ctx, cli, "shop", convert(t, tt.before, ""), io.Discard); err != nil {
+					t.Fatal(err)
+				}
+			}
+			before := specHashes(t, cli)
+
+			run := Apply
+			if tt.update {
+				run = Update
+			}
+			result, err := run(ctx, cli, "shop", convert(t, tt.compose, ""), io.Discard)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result.Services, tt.want) {
+				t.Errorf("services = %+v, want %+v", result.Services, tt.want)
+			}
+
+			// The spec-hash label changes exactly when the service is updated
+			after := specHashes(t, cli)
+			for _, s := range tt.want {
+				old, now := before["shop_"+s.Name], after["shop_"+s.Name]
+				switch s.Action {
+				case ActionCreated:
+					if now == "" {
+						t.Errorf("%s: created without a spec hash", s.Name)
+					}
+				case ActionUpdated:
+					if now == "" || now == old {
+						t.Errorf("%s: spec hash %q after update, was %q", s.Name, now, old)
+					}
+				case ActionUnchanged:
+					if now != old {
+						t.Errorf("%s: spec hash changed from %q to %q", s.Name, old, now)
+					}
+				case ActionRemoved:
+					if now != "" {
+						t.Errorf("%s: still in the Swarm", s.Name)
+					}
+				}
+			}
+			if tt.update && after["shop_worker"] == "" {
+				t.Errorf("Update removed shop_worker")
+			}
+		})
+	}
+}
+
+func TestApplyPrepare(t *testing.T) {
+	ctx := context.Background()
+	workDir := t.TempDir()
+	for name, content := range map[string]string{"db.txt": "s3cret", "nginx.conf": "server {}"} {
+		if err := os.WriteFile(filepath.Join(workDir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	compose := `
+services:
+  web:
+    image: shop/web:2
+    networks: [backend]
+    secrets: [db_password]
+    configs: [nginx]
+networks:
+  backend: {}
+secrets:
+  db_password:
+    file: ./db.txt
+configs:
+  nginx:
+    file: ./nginx.conf
+`
+	cli := engine.NewFake()
+	if _, err := Apply(ctx, cli, "shop", convert(t, compose, workDir), io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cli.NetworkInspect(ctx, "shop_backend", types.NetworkInspectOptions{}); err != nil {
+		t.Errorf("network: %v", err)
+	}
+	secrets, err := cli.SecretList(ctx, types.SecretListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs, err := cli.ConfigList(ctx, types.ConfigListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 1 || len(configs) != 1 {
+		t.Fatalf("%d secrets and %d configs, want 1 each", len(secrets), len(configs))
+	}
+	if got, want := secrets[0].Spec.Labels[LabelContentHash], digest.FromString("s3cret").Encoded(); got != want {
+		t.Errorf("content hash = %q, want %q", got, want)
+	}
+
+	svc, _, err := cli.ServiceInspectWithRaw(ctx, "shop_web", types.ServiceInspectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := svc.Spec.TaskTemplate.ContainerSpec
+	if want := "shop/web:2@" + digest.FromString("shop/web:2").String(); cs.Image != want {
+		t.Errorf("image = %q, want it pinned to %q", cs.Image, want)
+	}
+	if cs.Secrets[0].SecretID != secrets[0].ID {
+		t.Errorf("secret ID = %q, want %q", cs.Secrets[0].SecretID, secrets[0].ID)
+	}
+	if cs.Configs[0].ConfigID != configs[0].ID {
+		t.Errorf("config ID = %q, want %q", cs.Configs[0].ConfigID, configs[0].ID)
+	}
+
+	// Secrets are immutable, a changed file must get a new name
+	if err := os.WriteFile(filepath.Join(workDir, "db.txt"), []byte("changed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Apply(ctx, cli, "shop", convert(t, compose, workDir), io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "secret 'shop_db_password' changed") {
+		t.Errorf("Apply with a changed secret: %v", err)
+	}
+}
+
+func TestApplyKeepsScaleAndJobs(t *testing.T) {
+	ctx := context.Background()
+	cli := engine.NewFake()
+	compose := "services:\n  web:\n    image: shop/web:2\n"
+
+	if _, err := Apply(ctx, cli, "shop", convert(t, compose, ""), io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	// Scaled by hand
+	svc, _, err := cli.ServiceInspectWithRaw(ctx, "shop_web", types.ServiceInspectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicas := uint64(5)
+	svc.Spec.Mode.Replicated.Replicas = &replicas
+	if _, err := cli.ServiceUpdate(ctx, svc.ID, svc.Version, svc.Spec, types.ServiceUpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// A migration job of the stack
+	if _, err := cli.ServiceCreate(ctx, dockerswarm.ServiceSpec{
+		Annotations: dockerswarm.Annotations{
+			Name:   "shop_migrate",
+			Labels: map[string]string{swarm.StackLabel: "shop", job.LabelJob: "migrate"},
+		},
+		TaskTemplate: dockerswarm.TaskSpec{ContainerSpec: &dockerswarm.ContainerSpec{Image: "shop/web:2"}},
+	}, types.ServiceCreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Apply(ctx, cli, "shop", convert(t, compose, ""), io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	if svc, _, err = cli.ServiceInspectWithRaw(ctx, "shop_web", types.ServiceInspectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := *svc.Spec.Mode.Replicated.Replicas; got != 5 {
+		t.Errorf("replicas = %d, want 5", got)
+	}
+	if _, _, err := cli.ServiceInspectWithRaw(ctx, "shop_migrate", types.ServiceInspectOptions{}); err != nil {
+		t.Errorf("job was pruned: %v", err)
+	}
+}
+
+// convert converts a compose file for the stack "shop".
+func convert(t *testing.T, compose, workDir string) *Specs {
+	t.Helper()
+	specs, err := Convert("shop", []byte(compose), workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return specs
+}
+
+// specHashes returns the spec-hash label of each service of the stack "shop".
+func specHashes(t *testing.T, cli engine.Swarm) map[string]string {
+	t.Helper()
+	f := filters.NewArgs()
+	f.Add("label", swarm.StackLabel+"=shop")
+	services, err := cli.ServiceList(context.Background(), types.ServiceListOptions{Filters: f})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := make(map[string]string, len(services))
+	for _, svc := range services {
+		hashes[svc.Spec.Name] = svc.Spec.Labels[LabelSpecHash]
+	}
+	return hashes
+}
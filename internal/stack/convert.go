@@ -0,0 +1,1006 @@
+package stack
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+	units "github.com/docker/go-units"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+
+	"github.com/rollwave-dev/rollwave/internal/swarm"
+)
+
+// ImageLabel records the image as written in the compose file, before digest pinning.
+const ImageLabel = "com.docker.stack.image"
+
+// Specs are the Swarm objects described by a compose file.
+type Specs struct {
+	Networks map[string]NetworkSpec // keyed by Swarm name
+	Secrets  map[string]ObjectSpec  // keyed by Swarm name
+	Configs  map[string]ObjectSpec  // keyed by Swarm name
+	Services map[string]dockerswarm.ServiceSpec
+
+	// Warnings lists compose options that are ignored in swarm mode.
+	Warnings []string
+}
+
+// NetworkSpec is a network used by the stack.
+type NetworkSpec struct {
+	Name     string
+	External bool
+	Create   types.NetworkCreate
+}
+
+// ObjectSpec is a secret or config used by the stack. External objects must exist;
+// others are created from File.
+type ObjectSpec struct {
+	Name     string
+	External bool
+	File     string
+	Labels   map[string]string
+}
+
+// --- Compose model (after interpolation) ---
+
+type composeFile struct {
+	Services map[string]serviceConfig `yaml:"services"`
+	Networks map[string]*struct {
+		Name       string            `yaml:"name"`
+		Driver     string            `yaml:"driver"`
+		DriverOpts map[string]string `yaml:"driver_opts"`
+		Attachable bool              `yaml:"attachable"`
+		Internal   bool              `yaml:"internal"`
+		External   interface{}       `yaml:"external"`
+		Labels     interface{}       `yaml:"labels"`
+		Ipam       *struct {
+			Driver string `yaml:"driver"`
+			Config []struct {
+				Subnet string `yaml:"subnet"`
+			} `yaml:"config"`
+		} `yaml:"ipam"`
+	} `yaml:"networks"`
+	Volumes map[string]*struct {
+		Name       string            `yaml:"name"`
+		Driver     string            `yaml:"driver"`
+		DriverOpts map[string]string `yaml:"driver_opts"`
+		External   interface{}       `yaml:"external"`
+		Labels     interface{}       `yaml:"labels"`
+	} `yaml:"volumes"`
+	Secrets map[string]objectConfig `yaml:"secrets"`
+	Configs map[string]objectConfig `yaml:"configs"`
+}
+
+type objectConfig struct {
+	Name     string      `yaml:"name"`
+	File     string      `yaml:"file"`
+	External interface{} `yaml:"external"`
+	Labels   interface{} `yaml:"labels"`
+}
+
+type serviceConfig struct {
+	Image           string                 `yaml:"image"`
+	Command         interface{}            `yaml:"command"`
+	Entrypoint      interface{}            `yaml:"entrypoint"`
+	Environment     interface{}            `yaml:"environment"`
+	EnvFile         interface{}            `yaml:"env_file"`
+	Labels          interface{}            `yaml:"labels"`
+	Deploy          deployConfig           `yaml:"deploy"`
+	Ports           []interface{}          `yaml:"ports"`
+	Networks        interface{}            `yaml:"networks"`
+	Secrets         []interface{}          `yaml:"secrets"`
+	Configs         []interface{}          `yaml:"configs"`
+	Volumes         []interface{}          `yaml:"volumes"`
+	Healthcheck     *healthcheck           `yaml:"healthcheck"`
+	Hostname        string                 `yaml:"hostname"`
+	User            string                 `yaml:"user"`
+	WorkingDir      string                 `yaml:"working_dir"`
+	StopSignal      string                 `yaml:"stop_signal"`
+	StopGracePeriod string                 `yaml:"stop_grace_period"`
+	TTY             bool                   `yaml:"tty"`
+	StdinOpen       bool                   `yaml:"stdin_open"`
+	ReadOnly        bool                   `yaml:"read_only"`
+	Init            *bool                  `yaml:"init"`
+	ExtraHosts      interface{}            `yaml:"extra_hosts"`
+	DNS             interface{}            `yaml:"dns"`
+	DNSSearch       interface{}            `yaml:"dns_search"`
+	CapAdd          []string               `yaml:"cap_add"`
+	CapDrop         []string               `yaml:"cap_drop"`
+	Sysctls         interface{}            `yaml:"sysctls"`
+	Ulimits         map[string]interface{} `yaml:"ulimits"`
+	Isolation       string                 `yaml:"isolation"`
+	Logging         *struct {
+		Driver  string            `yaml:"driver"`
+		Options map[string]string `yaml:"options"`
+	} `yaml:"logging"`
+
+	// Everything else, reported as unsupported
+	Other map[string]interface{} `yaml:",inline"`
+}
+
+type deployConfig struct {
+	Mode           string        `yaml:"mode"`
+	Replicas       *uint64       `yaml:"replicas"`
+	Labels         interface{}   `yaml:"labels"`
+	EndpointMode   string        `yaml:"endpoint_mode"`
+	UpdateConfig   *updateConfig `yaml:"update_config"`
+	RollbackConfig *updateConfig `yaml:"rollback_config"`
+	Resources      struct {
+		Limits       *resources `yaml:"limits"`
+		Reservations *resources `yaml:"reservations"`
+	} `yaml:"resources"`
+	RestartPolicy *struct {
+		Condition   string  `yaml:"condition"`
+		Delay       string  `yaml:"delay"`
+		MaxAttempts *uint64 `yaml:"max_attempts"`
+		Window      string  `yaml:"window"`
+	} `yaml:"restart_policy"`
+	Placement struct {
+		Constraints []string `yaml:"constraints"`
+		Preferences []struct {
+			Spread string `yaml:"spread"`
+		} `yaml:"preferences"`
+		MaxReplicas uint64 `yaml:"max_replicas_per_node"`
+	} `yaml:"placement"`
+}
+
+type updateConfig struct {
+	Parallelism     *uint64 `yaml:"parallelism"`
+	Delay           string  `yaml:"delay"`
+	FailureAction   string  `yaml:"failure_action"`
+	Monitor         string  `yaml:"monitor"`
+	MaxFailureRatio float32 `yaml:"max_failure_ratio"`
+	Order           string  `yaml:"order"`
+}
+
+type resources struct {
+	CPUs   string `yaml:"cpus"`
+	Memory string `yaml:"memory"`
+	Pids   int64  `yaml:"pids"`
+}
+
+type healthcheck struct {
+	Test        interface{} `yaml:"test"`
+	Interval    string      `yaml:"interval"`
+	Timeout     string      `yaml:"timeout"`
+	Retries     *int        `yaml:"retries"`
+	StartPeriod string      `yaml:"start_period"`
+	Disable     bool        `yaml:"disable"`
+}
+
+// unsupportedServiceOptions are ignored by 'docker stack deploy' as well.
+var unsupportedServiceOptions = map[string]bool{
+	"cgroup_parent": true, "container_name": true, "depends_on": true,
+	"devices": true, "domainname": true, "external_links": true, "ipc": true, "links": true,
+	"mac_address": true, "network_mode": true, "pid": true, "privileged": true,
+	"restart": true, "security_opt": true, "shm_size": true, "userns_mode": true,
+	"expose": true,
+}
+
+// Convert turns an interpolated compose file into Swarm specs for the stack.
+// Relative paths (secret and config files, bind mounts, env files) are resolved
+// against workDir.
+func Convert(stack string, data []byte, workDir string) (*Specs, error) {
+	var file composeFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse compose: %w", err)
+	}
+
+	specs := &Specs{
+		Networks: make(map[string]NetworkSpec),
+		Secrets:  make(map[string]ObjectSpec),
+		Configs:  make(map[string]ObjectSpec),
+		Services: make(map[string]dockerswarm.ServiceSpec),
+	}
+	stackLabels := map[string]string{swarm.StackLabel: stack}
+
+	// Networks: every network a service uses, plus the implicit "default"
+	networkName := func(name string) (string, error) {
+		def, declared := file.Networks[name]
+		if !declared && name != "default" {
+			return "", fmt.Errorf("network '%s' is not declared", name)
+		}
+
+		ns := NetworkSpec{Name: stack + "_" + name}
+		if def != nil {
+			if def.Name != "" {
+				ns.Name = def.Name
+			}
+			if isTrue(def.External) {
+				if def.Name == "" {
+					ns.Name = name
+				}
+				ns.External = true
+			}
+		}
+		if _, done := specs.Networks[ns.Name]; done {
+			return ns.Name, nil
+		}
+
+		ns.Create = types.NetworkCreate{
+			Driver: "overlay",
+			Scope:  "swarm",
+			Labels: merge(stackLabels, nil),
+		}
+		if def != nil {
+			if def.Driver != "" {
+				ns.Create.Driver = def.Driver
+			}
+			ns.Create.Options = def.DriverOpts
+			ns.Create.Attachable = def.Attachable
+			ns.Create.Internal = def.Internal
+			ns.Create.Labels = merge(stackLabels, toMap(def.Labels))
+			if def.Ipam != nil {
+				ipam := &network.IPAM{Driver: def.Ipam.Driver}
+				for _, c := range def.Ipam.Config {
+					ipam.Config = append(ipam.Config, network.IPAMConfig{Subnet: c.Subnet})
+				}
+				ns.Create.IPAM = ipam
+			}
+		}
+		specs.Networks[ns.Name] = ns
+		return ns.Name, nil
+	}
+
+	objectName := func(kind string, defs map[string]objectConfig, name string, out map[string]ObjectSpec) (string, error) {
+		def, ok := defs[name]
+		if !ok {
+			return "", fmt.Errorf("%s '%s' is not declared", kind, name)
+		}
+		obj := ObjectSpec{Name: stack + "_" + name, Labels: merge(stackLabels, toMap(def.Labels))}
+		switch {
+		case def.Name != "":
+			obj.Name = def.Name
+		case isTrue(def.External):
+			obj.Name = name
+		}
+		if isTrue(def.External) {
+			obj.External = true
+		} else {
+			if def.File == "" {
+				return "", fmt.Errorf("%s '%s' needs 'file' or 'external'", kind, name)
+			}
+			obj.File = absPath(workDir, def.File)
+		}
+		out[obj.Name] = obj
+		return obj.Name, nil
+	}
+
+	names := make([]string, 0, len(file.Services))
+	for name := range file.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		svc := file.Services[name]
+		fail := func(err error) (*Specs, error) {
+			return nil, fmt.Errorf("service %s: %w", name, err)
+		}
+
+		if svc.Image == "" {
+			return fail(fmt.Errorf("no image"))
+		}
+
+		var ignored []string
+		for key := range svc.Other {
+			if key == "build" {
+				continue // images are built by rollwave before the deploy
+			}
+			if unsupportedServiceOptions[key] {
+				ignored = append(ignored, key)
+			} else {
+				ignored = append(ignored, key+" (unknown)")
+			}
+		}
+		if len(ignored) > 0 {
+			sort.Strings(ignored)
+			specs.Warnings = append(specs.Warnings, fmt.Sprintf("service %s: ignoring options: %s", name, strings.Join(ignored, ", ")))
+		}
+
+		cs := &dockerswarm.ContainerSpec{
+			Image:          svc.Image,
+			Labels:         merge(stackLabels, toMap(svc.Labels)),
+			Hostname:       svc.Hostname,
+			Dir:            svc.WorkingDir,
+			User:           svc.User,
+			StopSignal:     svc.StopSignal,
+			TTY:            svc.TTY,
+			OpenStdin:      svc.StdinOpen,
+			ReadOnly:       svc.ReadOnly,
+			Init:           svc.Init,
+			Sysctls:        toMap(svc.Sysctls),
+			CapabilityAdd:  svc.CapAdd,
+			CapabilityDrop: svc.CapDrop,
+			Isolation:      container.Isolation(svc.Isolation),
+		}
+
+		var err error
+		if cs.Command, err = words(svc.Entrypoint); err != nil {
+			return fail(fmt.Errorf("entrypoint: %w", err))
+		}
+		if cs.Args, err = words(svc.Command); err != nil {
+			return fail(fmt.Errorf("command: %w", err))
+		}
+		if cs.Env, err = environment(svc, workDir); err != nil {
+			return fail(err)
+		}
+		if cs.StopGracePeriod, err = durationPtr(svc.StopGracePeriod); err != nil {
+			return fail(err)
+		}
+		if cs.Healthcheck, err = convertHealthcheck(svc.Healthcheck); err != nil {
+			return fail(err)
+		}
+		cs.Hosts = convertExtraHosts(svc.ExtraHosts)
+		if dns, search := toList(svc.DNS), toList(svc.DNSSearch); len(dns) > 0 || len(search) > 0 {
+			cs.DNSConfig = &dockerswarm.DNSConfig{Nameservers: dns, Search: search}
+		}
+		if cs.Ulimits, err = convertUlimits(svc.Ulimits); err != nil {
+			return fail(err)
+		}
+
+		// Secrets and configs
+		for _, ref := range svc.Secrets {
+			source, target, uid, gid, mode, err := fileReference(ref, 0444)
+			if err != nil {
+				return fail(err)
+			}
+			swarmName, err := objectName("secret", file.Secrets, source, specs.Secrets)
+			if err != nil {
+				return fail(err)
+			}
+			if target == "" {
+				target = source
+			}
+			cs.Secrets = append(cs.Secrets, &dockerswarm.SecretReference{
+				SecretName: swarmName,
+				File:       &dockerswarm.SecretReferenceFileTarget{Name: target, UID: uid, GID: gid, Mode: mode},
+			})
+		}
+		for _, ref := range svc.Configs {
+			source, target, uid, gid, mode, err := fileReference(ref, 0444)
+			if err != nil {
+				return fail(err)
+			}
+			swarmName, err := objectName("config", file.Configs, source, specs.Configs)
+			if err != nil {
+				return fail(err)
+			}
+			if target == "" {
+				target = "/" + source
+			}
+			cs.Configs = append(cs.Configs, &dockerswarm.ConfigReference{
+				ConfigName: swarmName,
+				File:       &dockerswarm.ConfigReferenceFileTarget{Name: target, UID: uid, GID: gid, Mode: mode},
+			})
+		}
+
+		// Volumes
+		for _, v := range svc.Volumes {
+			m, err := convertVolume(stack, v, workDir, file)
+			if err != nil {
+				return fail(err)
+			}
+			cs.Mounts = append(cs.Mounts, m)
+		}
+
+		// Networks, each with the service name as alias
+		var attachments []dockerswarm.NetworkAttachmentConfig
+		serviceNetworks := serviceNetworks(svc.Networks)
+		if len(serviceNetworks) == 0 {
+			serviceNetworks = map[string][]string{"default": nil}
+		}
+		for _, netName := range sortedKeys(serviceNetworks) {
+			target, err := networkName(netName)
+			if err != nil {
+				return fail(err)
+			}
+			attachments = append(attachments, dockerswarm.NetworkAttachmentConfig{
+				Target:  target,
+				Aliases: append([]string{name}, serviceNetworks[netName]...),
+			})
+		}
+
+		spec := dockerswarm.ServiceSpec{
+			Annotations: dockerswarm.Annotations{
+				Name:   stack + "_" + name,
+				Labels: merge(merge(stackLabels, toMap(svc.Deploy.Labels)), map[string]string{ImageLabel: svc.Image}),
+			},
+			TaskTemplate: dockerswarm.TaskSpec{
+				ContainerSpec: cs,
+				Networks:      attachments,
+			},
+		}
+
+		if spec.Mode, err = convertMode(svc.Deploy); err != nil {
+			return fail(err)
+		}
+		if spec.TaskTemplate.Resources, err = convertResources(svc.Deploy); err != nil {
+			return fail(err)
+		}
+		if spec.TaskTemplate.RestartPolicy, err = convertRestartPolicy(svc.Deploy); err != nil {
+			return fail(err)
+		}
+		if spec.UpdateConfig, err = convertUpdateConfig(svc.Deploy.UpdateConfig); err != nil {
+			return fail(fmt.Errorf("update_config: %w", err))
+		}
+		if spec.RollbackConfig, err = convertUpdateConfig(svc.Deploy.RollbackConfig); err != nil {
+			return fail(fmt.Errorf("rollback_config: %w", err))
+		}
+
+		placement := svc.Deploy.Placement
+		if len(placement.Constraints) > 0 || len(placement.Preferences) > 0 || placement.MaxReplicas > 0 {
+			p := &dockerswarm.Placement{Constraints: placement.Constraints, MaxReplicas: placement.MaxReplicas}
+			for _, pref := range placement.Preferences {
+				p.Preferences = append(p.Preferences, dockerswarm.PlacementPreference{
+					Spread: &dockerswarm.SpreadOver{SpreadDescriptor: pref.Spread},
+				})
+			}
+			spec.TaskTemplate.Placement = p
+		}
+
+		if svc.Logging != nil {
+			spec.TaskTemplate.LogDriver = &dockerswarm.Driver{Name: svc.Logging.Driver, Options: svc.Logging.Options}
+		}
+
+		ports, err := ConvertPorts(svc.Ports)
+		if err != nil {
+			return fail(err)
+		}
+		spec.EndpointSpec = &dockerswarm.EndpointSpec{
+			Mode:  dockerswarm.ResolutionMode(strings.ToLower(svc.Deploy.EndpointMode)),
+			Ports: ports,
+		}
+
+		specs.Services[name] = spec
+	}
+
+	return specs, nil
+}
+
+// --- Conversion helpers ---
+
+func convertMode(d deployConfig) (dockerswarm.ServiceMode, error) {
+	switch d.Mode {
+	case "", "replicated":
+		// Replicas stay nil when unset so an update keeps the current scale
+		return dockerswarm.ServiceMode{Replicated: &dockerswarm.ReplicatedService{Replicas: d.Replicas}}, nil
+	case "global":
+		if d.Replicas != nil {
+			return dockerswarm.ServiceMode{}, fmt.Errorf("replicas can only be used with replicated mode")
+		}
+		return dockerswarm.ServiceMode{Global: &dockerswarm.GlobalService{}}, nil
+	case "replicated-job":
+		return dockerswarm.ServiceMode{ReplicatedJob: &dockerswarm.ReplicatedJob{TotalCompletions: d.Replicas}}, nil
+	case "global-job":
+		return dockerswarm.ServiceMode{GlobalJob: &dockerswarm.GlobalJob{}}, nil
+	}
+	return dockerswarm.ServiceMode{}, fmt.Errorf("unknown deploy mode '%s'", d.Mode)
+}
+
+func convertResources(d deployConfig) (*dockerswarm.ResourceRequirements, error) {
+	convert := func(r *resources) (*dockerswarm.Limit, *dockerswarm.Resources, error) {
+		if r == nil {
+			return nil, nil, nil
+		}
+		var nanoCPUs, memory int64
+		if r.CPUs != "" {
+			cpus, err := strconv.ParseFloat(r.CPUs, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid cpus '%s'", r.CPUs)
+			}
+			nanoCPUs = int64(cpus * 1e9)
+		}
+		if r.Memory != "" {
+			m, err := units.RAMInBytes(r.Memory)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid memory '%s'", r.Memory)
+			}
+			memory = m
+		}
+		return &dockerswarm.Limit{NanoCPUs: nanoCPUs, MemoryBytes: memory, Pids: r.Pids},
+			&dockerswarm.Resources{NanoCPUs: nanoCPUs, MemoryBytes: memory}, nil
+	}
+
+	limits, _, err := convert(d.Resources.Limits)
+	if err != nil {
+		return nil, err
+	}
+	_, reservations, err := convert(d.Resources.Reservations)
+	if err != nil {
+		return nil, err
+	}
+	if limits == nil && reservations == nil {
+		return nil, nil
+	}
+	return &dockerswarm.ResourceRequirements{Limits: limits, Reservations: reservations}, nil
+}
+
+func convertRestartPolicy(d deployConfig) (*dockerswarm.RestartPolicy, error) {
+	rp := d.RestartPolicy
+	if rp == nil {
+		return nil, nil
+	}
+	out := &dockerswarm.RestartPolicy{MaxAttempts: rp.MaxAttempts}
+	switch rp.Condition {
+	case "":
+	case "none", "on-failure", "any":
+		out.Condition = dockerswarm.RestartPolicyCondition(rp.Condition)
+	default:
+		return nil, fmt.Errorf("unknown restart condition '%s'", rp.Condition)
+	}
+	var err error
+	if out.Delay, err = durationPtr(rp.Delay); err != nil {
+		return nil, err
+	}
+	if out.Window, err = durationPtr(rp.Window); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func convertUpdateConfig(u *updateConfig) (*dockerswarm.UpdateConfig, error) {
+	if u == nil {
+		return nil, nil
+	}
+	out := &dockerswarm.UpdateConfig{
+		Parallelism:     1,
+		FailureAction:   u.FailureAction,
+		MaxFailureRatio: u.MaxFailureRatio,
+		Order:           u.Order,
+	}
+	if u.Parallelism != nil {
+		out.Parallelism = *u.Parallelism
+	}
+	var err error
+	if out.Delay, err = duration(u.Delay); err != nil {
+		return nil, err
+	}
+	if out.Monitor, err = duration(u.Monitor); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func convertHealthcheck(h *healthcheck) (*container.HealthConfig, error) {
+	if h == nil {
+		return nil, nil
+	}
+	if h.Disable {
+		return &container.HealthConfig{Test: []string{"NONE"}}, nil
+	}
+
+	out := &container.HealthConfig{}
+	switch t := h.Test.(type) {
+	case nil:
+	case string:
+		out.Test = []string{"CMD-SHELL", t}
+	case []interface{}:
+		for _, part := range t {
+			out.Test = append(out.Test, fmt.Sprint(part))
+		}
+	default:
+		return nil, fmt.Errorf("invalid healthcheck test %v", h.Test)
+	}
+
+	var err error
+	if out.Interval, err = duration(h.Interval); err != nil {
+		return nil, err
+	}
+	if out.Timeout, err = duration(h.Timeout); err != nil {
+		return nil, err
+	}
+	if out.StartPeriod, err = duration(h.StartPeriod); err != nil {
+		return nil, err
+	}
+	if h.Retries != nil {
+		out.Retries = *h.Retries
+	}
+	return out, nil
+}
+
+// convertVolume parses "source:target[:ro]" or the long syntax into a mount.
+func convertVolume(stack string, v interface{}, workDir string, file composeFile) (mount.Mount, error) {
+	var m mount.Mount
+
+	switch e := v.(type) {
+	case string:
+		parts := strings.Split(e, ":")
+		switch len(parts) {
+		case 1:
+			m.Target = parts[0]
+		case 2, 3:
+			m.Source, m.Target = parts[0], parts[1]
+			if len(parts) == 3 {
+				for _, opt := range strings.Split(parts[2], ",") {
+					if opt == "ro" {
+						m.ReadOnly = true
+					}
+				}
+			}
+		default:
+			return m, fmt.Errorf("invalid volume '%s'", e)
+		}
+		m.Type = mount.TypeVolume
+		if isPath(m.Source) {
+			m.Type = mount.TypeBind
+		}
+
+	case map[string]interface{}:
+		str := func(key string) string {
+			if s, ok := e[key]; ok && s != nil {
+				return fmt.Sprint(s)
+			}
+			return ""
+		}
+		m.Type = mount.Type(str("type"))
+		if m.Type == "" {
+			m.Type = mount.TypeVolume
+		}
+		m.Source, m.Target = str("source"), str("target")
+		m.ReadOnly = str("read_only") == "true"
+		if tmpfs, ok := e["tmpfs"].(map[string]interface{}); ok {
+			if size, ok := tmpfs["size"]; ok {
+				bytes, err := units.RAMInBytes(fmt.Sprint(size))
+				if err != nil {
+					return m, fmt.Errorf("invalid tmpfs size '%v'", size)
+				}
+				m.TmpfsOptions = &mount.TmpfsOptions{SizeBytes: bytes}
+			}
+		}
+
+	default:
+		return m, fmt.Errorf("invalid volume %v", v)
+	}
+
+	if m.Target == "" {
+		return m, fmt.Errorf("volume %v has no target", v)
+	}
+
+	switch m.Type {
+	case mount.TypeBind:
+		m.Source = absPath(workDir, m.Source)
+	case mount.TypeVolume:
+		if m.Source == "" {
+			break // anonymous volume
+		}
+		def, ok := file.Volumes[m.Source]
+		if !ok {
+			return m, fmt.Errorf("volume '%s' is not declared", m.Source)
+		}
+		name := stack + "_" + m.Source
+		opts := &mount.VolumeOptions{Labels: map[string]string{swarm.StackLabel: stack}}
+		if def != nil {
+			switch {
+			case def.Name != "":
+				name = def.Name
+			case isTrue(def.External):
+				name = m.Source
+			}
+			if isTrue(def.External) {
+				opts = nil
+			} else {
+				opts.Labels = merge(opts.Labels, toMap(def.Labels))
+				if def.Driver != "" {
+					opts.DriverConfig = &mount.Driver{Name: def.Driver, Options: def.DriverOpts}
+				}
+			}
+		}
+		m.Source = name
+		m.VolumeOptions = opts
+	}
+	return m, nil
+}
+
+// fileReference parses a secret or config reference in short or long syntax.
+func fileReference(ref interface{}, defaultMode os.FileMode) (source, target, uid, gid string, mode os.FileMode, err error) {
+	uid, gid, mode = "0", "0", defaultMode
+	switch r := ref.(type) {
+	case string:
+		return r, "", uid, gid, mode, nil
+	case map[string]interface{}:
+		source, _ = r["source"].(string)
+		if source == "" {
+			return "", "", "", "", 0, fmt.Errorf("reference %v has no source", ref)
+		}
+		if t, ok := r["target"]; ok {
+			target = fmt.Sprint(t)
+		}
+		if u, ok := r["uid"]; ok {
+			uid = fmt.Sprint(u)
+		}
+		if g, ok := r["gid"]; ok {
+			gid = fmt.Sprint(g)
+		}
+		switch m := r["mode"].(type) {
+		case nil:
+		case int:
+			// 0440 is already read as octal by the YAML parser
+			mode = os.FileMode(m)
+		case string:
+			n, perr := strconv.ParseUint(m, 8, 32)
+			if perr != nil {
+				return "", "", "", "", 0, fmt.Errorf("invalid mode '%s'", m)
+			}
+			mode = os.FileMode(n)
+		default:
+			return "", "", "", "", 0, fmt.Errorf("invalid mode %v", m)
+		}
+		return source, target, uid, gid, mode, nil
+	}
+	return "", "", "", "", 0, fmt.Errorf("invalid reference %v", ref)
+}
+
+func environment(svc serviceConfig, workDir string) ([]string, error) {
+	env := make(map[string]string)
+	for _, f := range toList(svc.EnvFile) {
+		values, err := godotenv.Read(absPath(workDir, f))
+		if err != nil {
+			return nil, fmt.Errorf("env_file %s: %w", f, err)
+		}
+		for k, v := range values {
+			env[k] = v
+		}
+	}
+
+	switch e := svc.Environment.(type) {
+	case map[string]interface{}:
+		for k, v := range e {
+			if v == nil {
+				// "KEY:" without a value is taken from the local environment
+				if local, ok := os.LookupEnv(k); ok {
+					env[k] = local
+				}
+				continue
+			}
+			env[k] = fmt.Sprint(v)
+		}
+	case []interface{}:
+		for _, item := range e {
+			k, v, ok := strings.Cut(fmt.Sprint(item), "=")
+			if !ok {
+				if local, found := os.LookupEnv(k); found {
+					env[k] = local
+				}
+				continue
+			}
+			env[k] = v
+		}
+	}
+
+	out := make([]string, 0, len(env))
+	for _, k := range sortedKeys(env) {
+		out = append(out, k+"="+env[k])
+	}
+	return out, nil
+}
+
+func convertExtraHosts(v interface{}) []string {
+	var out []string
+	switch e := v.(type) {
+	case map[string]interface{}:
+		for _, host := range sortedKeys(e) {
+			out = append(out, fmt.Sprintf("%v %s", e[host], host))
+		}
+	case []interface{}:
+		// "host:ip" in compose, "ip host" in the Swarm API
+		for _, item := range e {
+			if host, ip, ok := strings.Cut(fmt.Sprint(item), ":"); ok {
+				out = append(out, ip+" "+host)
+			}
+		}
+	}
+	return out
+}
+
+func convertUlimits(in map[string]interface{}) ([]*units.Ulimit, error) {
+	var out []*units.Ulimit
+	for _, name := range sortedKeys(in) {
+		switch v := in[name].(type) {
+		case int:
+			out = append(out, &units.Ulimit{Name: name, Soft: int64(v), Hard: int64(v)})
+		case map[string]interface{}:
+			soft, _ := v["soft"].(int)
+			hard, _ := v["hard"].(int)
+			out = append(out, &units.Ulimit{Name: name, Soft: int64(soft), Hard: int64(hard)})
+		default:
+			return nil, fmt.Errorf("invalid ulimit %s", name)
+		}
+	}
+	return out, nil
+}
+
+// serviceNetworks returns the networks of a service with their extra aliases.
+func serviceNetworks(v interface{}) map[string][]string {
+	out := make(map[string][]string)
+	switch n := v.(type) {
+	case []interface{}:
+		for _, name := range n {
+			out[fmt.Sprint(name)] = nil
+		}
+	case map[string]interface{}:
+		for name, def := range n {
+			var aliases []string
+			if m, ok := def.(map[string]interface{}); ok {
+				aliases = toList(m["aliases"])
+			}
+			out[name] = aliases
+		}
+	}
+	return out
+}
+
+// words splits a command given as a list or as a shell-like string.
+func words(v interface{}) ([]string, error) {
+	switch c := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return splitWords(c)
+	case []interface{}:
+		out := make([]string, 0, len(c))
+		for _, part := range c {
+			out = append(out, fmt.Sprint(part))
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("invalid value %v", v)
+}
+
+// splitWords splits s like a POSIX shell would, honouring quotes and backslashes.
+func splitWords(s string) ([]string, error) {
+	var (
+		out     []string
+		cur     strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range s {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				out = append(out, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if inWord {
+		out = append(out, cur.String())
+	}
+	return out, nil
+}
+
+// toMap accepts both the map and the list ("key=value") syntax.
+func toMap(v interface{}) map[string]string {
+	out := make(map[string]string)
+	switch m := v.(type) {
+	case map[string]interface{}:
+		for k, val := range m {
+			if val == nil {
+				out[k] = ""
+			} else {
+				out[k] = fmt.Sprint(val)
+			}
+		}
+	case []interface{}:
+		for _, item := range m {
+			k, val, _ := strings.Cut(fmt.Sprint(item), "=")
+			out[k] = val
+		}
+	}
+	return out
+}
+
+// toList accepts a single string or a list.
+func toList(v interface{}) []string {
+	switch l := v.(type) {
+	case string:
+		return []string{l}
+	case []interface{}:
+		out := make([]string, 0, len(l))
+		for _, item := range l {
+			out = append(out, fmt.Sprint(item))
+		}
+		return out
+	}
+	return nil
+}
+
+func merge(base, extra map[string]string) map[string]string {
+	out := make(map[string]string, len(base)+len(extra))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range extra {
+		out[k] = v
+	}
+	return out
+}
+
+func isTrue(v interface{}) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case map[string]interface{}:
+		return true // legacy "external: {name: ...}"
+	}
+	return false
+}
+
+func isPath(s string) bool {
+	return strings.HasPrefix(s, "/") || strings.HasPrefix(s, ".") || strings.HasPrefix(s, "~")
+}
+
+func absPath(workDir, p string) string {
+	if strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, p[2:])
+		}
+	}
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(workDir, p)
+}
+
+func duration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration '%s'", s)
+	}
+	return d, nil
+}
+
+func durationPtr(s string) (*time.Duration, error) {
+	if s == "" {
+		return nil, nil
+	}
+	d, err := duration(s)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
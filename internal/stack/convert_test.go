@@ -0,0 +1,384 @@
+package stack
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+
+	"github.com/rollwave-dev/rollwave/internal/swarm"
+)
+
+func TestConvert(t *testing.T) {
+	stackLabels := map[string]string{swarm.StackLabel: "shop"}
+	uint64p := func(n uint64) *uint64 { return &n }
+	web := func(s *Specs) dockerswarm.ServiceSpec { return s.Services["web"] }
+
+	tests := []struct {
+		name    string
+		compose string
+		got     func(s *Specs) any // the part of the specs to compare
+		want    any
+		wantErr string
+	}{
+		{
+			name: "service",
+			compose: `
+services:
+  web:
+    image: shop/web:2
+    command: serve --port "8080"
+    environment:
+      LOG_LEVEL: info
+      REGION: eu
+    labels: [tier=front]
+    depends_on: [db]
+    build: .
+    deploy:
+      labels: {team: shop}
+`,
+			got: func(s *Specs) any {
+				spec := web(s)
+				cs := spec.TaskTemplate.ContainerSpec
+				return []any{spec.Name, spec.Labels, cs.Image, cs.Args, cs.Env, cs.Labels, s.Warnings}
+			},
+			want: []any{
+				"shop_web",
+				map[string]string{swarm.StackLabel: "shop", "team": "shop", ImageLabel: "shop/web:2"},
+				"shop/web:2",
+				[]string{"serve", "--port", "8080"},
+				[]string{"LOG_LEVEL=info", "REGION=eu"},
+				map[string]string{swarm.StackLabel: "shop", "tier": "front"},
+				[]string{"service web: ignoring options: depends_on"},
+			},
+		},
+		{
+			name: "ports",
+			compose: `
+services:
+  web:
+    image: shop/web
+    ports:
+      - "8080:80"
+      - 9000-9001:9000-9001/udp
+      - target: 443
+        published: 8443
+        mode: host
+    deploy:
+      endpoint_mode: VIP
+`,
+			got: func(s *Specs) any { return web(s).EndpointSpec },
+			want: &dockerswarm.EndpointSpec{
+				Mode: dockerswarm.ResolutionModeVIP,
+				Ports: []dockerswarm.PortConfig{
+					{Protocol: "tcp", PublishMode: "ingress", TargetPort: 80, PublishedPort: 8080},
+					{Protocol: "udp", PublishMode: "ingress", TargetPort: 9000, PublishedPort: 9000},
+					{Protocol: "udp", PublishMode: "ingress", TargetPort: 9001, PublishedPort: 9001},
+					{Protocol: "tcp", PublishMode: "host", TargetPort: 443, PublishedPort: 8443},
+				},
+			},
+		},
+		{
+			name: "mounts",
+			compose: `
+services:
+  web:
+    image: shop/web
+    volumes:
+      - uploads:/data
+      - ./conf:/etc/web:ro
+      - /var/log/shop:/logs
+      - media:/media
+      - /cache
+      - type: tmpfs
+        target: /tmp
+        tmpfs: {size: 64m}
+volumes:
+  uploads:
+    driver: local
+    driver_opts: {type: nfs}
+  media:
+    external: true
+`,
+			got: func(s *Specs) any { return web(s).TaskTemplate.ContainerSpec.Mounts },
+			want: []mount.Mount{
+				{
+					Type: mount.TypeVolume, Source: "shop_uploads", Target: "/data",
+					VolumeOptions: &mount.VolumeOptions{
+						Labels:       stackLabels,
+						DriverConfig: &mount.Driver{Name: "local", Options: map[string]string{"type": "nfs"}},
+					},
+				},
+				{Type: mount.TypeBind, Source: "/srv/shop/conf", Target: "/etc/web", ReadOnly: true},
+				{Type: mount.TypeBind, Source: "/var/log/shop", Target: "/logs"},
+				{Type: mount.TypeVolume, Source: "media", Target: "/media"},
+				{Type: mount.TypeVolume, Target: "/cache"},
+				{Type: mount.TypeTmpfs, Target: "/tmp", TmpfsOptions: &mount.TmpfsOptions{SizeBytes: 64 << 20}},
+			},
+		},
+		{
+			name: "networks",
+			compose: `
+services:
+  web:
+    image: shop/web
+    networks:
+      backend:
+        aliases: [api]
+      proxy: {}
+  worker:
+    image: shop/worker
+networks:
+  backend:
+    driver_opts: {encrypted: "true"}
+    attachable: true
+    labels: {team: shop}
+  proxy:
+    external: true
+`,
+			got: func(s *Specs) any {
+				return []any{s.Networks, web(s).TaskTemplate.Networks, s.Services["worker"].TaskTemplate.Networks}
+			},
+			want: []any{
+				map[string]NetworkSpec{
+					"shop_backend": {Name: "shop_backend", Create: types.NetworkCreate{
+						Driver:     "overlay",
+						Scope:      "swarm",
+						Options:    map[string]string{"encrypted": "true"},
+						Attachable: true,
+						Labels:     map[string]string{swarm.StackLabel: "shop", "team": "shop"},
+					}},
+					"proxy": {Name: "proxy", External: true, Create: types.NetworkCreate{
+						Driver: "overlay", Scope: "swarm", Labels: stackLabels,
+					}},
+					"shop_default": {Name: "shop_default", Create: types.NetworkCreate{
+						Driver: "overlay", Scope: "swarm", Labels: stackLabels,
+					}},
+				},
+				[]dockerswarm.NetworkAttachmentConfig{
+					{Target: "shop_backend", Aliases: []string{"web", "api"}},
+					{Target: "proxy", Aliases: []string{"web"}},
+				},
+				[]dockerswarm.NetworkAttachmentConfig{
+					{Target: "shop_default", Aliases: []string{"worker"}},
+				},
+			},
+		},
+		{
+			name: "secrets and configs",
+			compose: `
+services:
+  web:
+    image: shop/web
+    secrets:
+      - db_password
+      - source: tls
+        target: /run/tls.key
+        uid: "100"
+        mode: 0400
+    configs:
+      - nginx
+      - source: app
+        target: /etc/app.yml
+        mode: "0440"
+secrets:
+  db_password:
+    file: ./secrets/db.txt
+  tls:
+    external: true
+configs:
+  nginx:
+    file: nginx.conf
+    labels: {role: proxy}
+  app:
+    name: shop_app_v2
+    file: /etc/shop/app.yml
+`,
+			got: func(s *Specs) any {
+				cs := web(s).TaskTemplate.ContainerSpec
+				return []any{s.Secrets, s.Configs, cs.Secrets, cs.Configs}
+			},
+			want: []any{
+				map[string]ObjectSpec{
+					"shop_db_password": {Name: "shop_db_password", File: "/srv/shop/secrets/db.txt", Labels: stackLabels},
+					"tls":              {Name: "tls", External: true, Labels: stackLabels},
+				},
+				map[string]ObjectSpec{
+					"shop_nginx":  {Name: "shop_nginx", File: "/srv/shop/nginx.conf", Labels: map[string]string{swarm.StackLabel: "shop", "role": "proxy"}},
+					"shop_app_v2": {Name: "shop_app_v2", File: "/etc/shop/app.yml", Labels: stackLabels},
+				},
+				[]*dockerswarm.SecretReference{
+					{SecretName: "shop_db_password", File: &dockerswarm.SecretReferenceFileTarget{Name: "db_password", UID: "0", GID: "0", Mode: 0444}},
+					{SecretName: "tls", File: &dockerswarm.SecretReferenceFileTarget{Name: "/run/tls.key", UID: "100", GID: "0", Mode: 0400}},
+				},
+				[]*dockerswarm.ConfigReference{
+					{ConfigName: "shop_nginx", File: &dockerswarm.ConfigReferenceFileTarget{Name: "/nginx", UID: "0", GID: "0", Mode: 0444}},
+					{ConfigName: "shop_app_v2", File: &dockerswarm.ConfigReferenceFileTarget{Name: "/etc/app.yml", UID: "0", GID: "0", Mode: 0440}},
+				},
+			},
+		},
+		{
+			name: "deploy",
+			compose: `
+services:
+  web:
+    image: shop/web
+    deploy:
+      replicas: 3
+      update_config:
+        parallelism: 2
+        delay: 10s
+        failure_action: rollback
+        monitor: 30s
+        order: start-first
+      rollback_config:
+        parallelism: 0
+      resources:
+        limits: {cpus: "0.5", memory: 256M}
+        reservations: {memory: 128M}
+      restart_policy:
+        condition: on-failure
+        max_attempts: 3
+      placement:
+        constraints: [node.role == worker]
+`,
+			got: func(s *Specs) any {
+				spec := web(s)
+				return []any{
+					spec.Mode, spec.UpdateConfig, spec.RollbackConfig,
+					spec.TaskTemplate.Resources, spec.TaskTemplate.RestartPolicy, spec.TaskTemplate.Placement,
+				}
+			},
+			want: []any{
+				dockerswarm.ServiceMode{Replicated: &dockerswarm.ReplicatedService{Replicas: uint64p(3)}},
+				&dockerswarm.UpdateConfig{
+					Parallelism:   2,
+					Delay:         10 * time.Second,
+					FailureAction: "rollback",
+					Monitor:       30 * time.Second,
+					Order:         "start-first",
+				},
+				&dockerswarm.UpdateConfig{},
+				&dockerswarm.ResourceRequirements{
+					Limits:       &dockerswarm.Limit{NanoCPUs: 5e8, MemoryBytes: 256 << 20},
+					Reservations: &dockerswarm.Resources{MemoryBytes: 128 << 20},
+				},
+				&dockerswarm.RestartPolicy{Condition: "on-failure", MaxAttempts: uint64p(3)},
+				&dockerswarm.Placement{Constraints: []string{"node.role == worker"}},
+			},
+		},
+		{
+			name: "replicas not set",
+			compose: `
+services:
+  web:
+    image: shop/web
+  agent:
+    image: shop/agent
+    deploy:
+      mode: global
+`,
+			got: func(s *Specs) any {
+				return []any{web(s).Mode, web(s).UpdateConfig, s.Services["agent"].Mode}
+			},
+			want: []any{
+				dockerswarm.ServiceMode{Replicated: &dockerswarm.ReplicatedService{}},
+				(*dockerswarm.UpdateConfig)(nil),
+				dockerswarm.ServiceMode{Global: &dockerswarm.GlobalService{}},
+			},
+		},
+		{
+			name: "healthcheck",
+			compose: `
+services:
+  web:
+    image: shop/web
+    healthcheck:
+      test: curl -f http://localhost/health
+      interval: 10s
+      timeout: 2s
+      retries: 3
+      start_period: 5s
+  worker:
+    image: shop/worker
+    healthcheck:
+      test: [CMD, /bin/check]
+  cron:
+    image: shop/cron
+    healthcheck:
+      disable: true
+`,
+			got: func(s *Specs) any {
+				return []any{
+					web(s).TaskTemplate.ContainerSpec.Healthcheck,
+					s.Services["worker"].TaskTemplate.ContainerSpec.Healthcheck,
+					s.Services["cron"].TaskTemplate.ContainerSpec.Healthcheck,
+				}
+			},
+			want: []any{
+				&container.HealthConfig{
+					Test:        []string{"CMD-SHELL", "curl -f http://localhost/health"},
+					Interval:    10 * time.Second,
+					Timeout:     2 * time.Second,
+					StartPeriod: 5 * time.Second,
+					Retries:     3,
+				},
+				&container.HealthConfig{Test: []string{"CMD", "/bin/check"}},
+				&container.HealthConfig{Test: []string{"NONE"}},
+			},
+		},
+		{
+			name:    "no image",
+			compose: "services:\n  web:\n    command: serve\n",
+			wantErr: "service web: no image",
+		},
+		{
+			name:    "undeclared volume",
+			compose: "services:\n  web:\n    image: shop/web\n    volumes: [uploads:/data]\n",
+			wantErr: "service web: volume 'uploads' is not declared",
+		},
+		{
+			name:    "undeclared network",
+			compose: "services:\n  web:\n    image: shop/web\n    networks: [backend]\n",
+			wantErr: "service web: network 'backend' is not declared",
+		},
+		{
+			name:    "secret without a file",
+			compose: "services:\n  web:\n    image: shop/web\n    secrets: [tls]\nsecrets:\n  tls: {}\n",
+			wantErr: "service web: secret 'tls' needs 'file' or 'external'",
+		},
+		{
+			name:    "invalid update_config",
+			compose: "services:\n  web:\n    image: shop/web\n    deploy:\n      update_config: {delay: soon}\n",
+			wantErr: "service web: update_config: invalid duration 'soon'",
+		},
+		{
+			name:    "replicas of a global service",
+			compose: "services:\n  web:\n    image: shop/web\n    deploy: {mode: global, replicas: 2}\n",
+			wantErr: "replicas can only be used with replicated mode",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			specs, err := Convert("shop", []byte(tt.compose), "/srv/shop")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Convert error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.got(specs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package stack
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+)
+
+// ConvertPorts turns compose port entries (short "8080:80/udp" strings or long-syntax
+// maps) into Swarm port configs. The entries must already be interpolated.
+func ConvertPorts(entries []interface{}) ([]dockerswarm.PortConfig, error) {
+	var out []dockerswarm.PortConfig
+	for _, entry := range entries {
+		var (
+			pcs []dockerswarm.PortConfig
+			err error
+		)
+		switch e := entry.(type) {
+		case string:
+			pcs, err = parseShortPort(e)
+		case int:
+			pcs, err = parseShortPort(strconv.Itoa(e))
+		case map[string]interface{}:
+			var pc dockerswarm.PortConfig
+			pc, err = parseLongPort(e)
+			pcs = []dockerswarm.PortConfig{pc}
+		default:
+			err = fmt.Errorf("unsupported port entry %v", entry)
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, pcs...)
+	}
+	return out, nil
+}
+
+// parseShortPort parses "[published:]target[/protocol]", where both ports may be ranges.
+func parseShortPort(s string) ([]dockerswarm.PortConfig, error) {
+	protocol := dockerswarm.PortConfigProtocolTCP
+	if spec, proto, ok := strings.Cut(s, "/"); ok {
+		s = spec
+		protocol = dockerswarm.PortConfigProtocol(proto)
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) > 2 {
+		return nil, fmt.Errorf("unsupported port '%s' (host IP bindings are not supported in swarm mode)", s)
+	}
+
+	targets, err := portRange(parts[len(parts)-1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid port '%s'", s)
+	}
+	var published []uint32
+	if len(parts) == 2 {
+		if published, err = portRange(parts[0]); err != nil {
+			return nil, fmt.Errorf("invalid port '%s'", s)
+		}
+		if len(published) != len(targets) {
+			return nil, fmt.Errorf("invalid port '%s': ranges differ in size", s)
+		}
+	}
+
+	out := make([]dockerswarm.PortConfig, 0, len(targets))
+	for i, target := range targets {
+		pc := dockerswarm.PortConfig{
+			Protocol:    protocol,
+			PublishMode: dockerswarm.PortConfigPublishModeIngress,
+			TargetPort:  target,
+		}
+		if published != nil {
+			pc.PublishedPort = published[i]
+		}
+		out = append(out, pc)
+	}
+	return out, nil
+}
+
+// parseLongPort parses the long port syntax (target, published, protocol, mode).
+func parseLongPort(m map[string]interface{}) (dockerswarm.PortConfig, error) {
+	pc := dockerswarm.PortConfig{
+		Protocol:    dockerswarm.PortConfigProtocolTCP,
+		PublishMode: dockerswarm.PortConfigPublishModeIngress,
+	}
+
+	num := func(key string) (uint32, error) {
+		v, ok := m[key]
+		if !ok {
+			return 0, nil
+		}
+		n, err := strconv.ParseUint(fmt.Sprint(v), 10, 16)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s port '%v'", key, v)
+		}
+		return uint32(n), nil
+	}
+
+	var err error
+	if pc.TargetPort, err = num("target"); err != nil {
+		return pc, err
+	}
+	if pc.PublishedPort, err = num("published"); err != nil {
+		return pc, err
+	}
+	if proto, ok := m["protocol"].(string); ok {
+		pc.Protocol = dockerswarm.PortConfigProtocol(proto)
+	}
+	if mode, ok := m["mode"].(string); ok {
+		pc.PublishMode = dockerswarm.PortConfigPublishMode(mode)
+	}
+	return pc, nil
+}
+
+// portRange parses "80" or "8000-8010".
+func portRange(s string) ([]uint32, error) {
+	start, end, isRange := strings.Cut(s, "-")
+	from, err := strconv.ParseUint(start, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	to := from
+	if isRange {
+		if to, err = strconv.ParseUint(end, 10, 16); err != nil {
+			return nil, err
+		}
+		if to < from {
+			return nil, fmt.Errorf("invalid range '%s'", s)
+		}
+	}
+
+	out := make([]uint32, 0, to-from+1)
+	for p := from; p <= to; p++ {
+		out = append(out, uint32(p))
+	}
+	return out, nil
+}
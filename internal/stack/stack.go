@@ -0,0 +1,159 @@
+package stack
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/rollwave-dev/rollwave/internal/compose"
+	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+)
+
+// DeployOptions defines the parameters for deploying a rendered compose file.
+type DeployOptions struct {
+	Name    string
+	Compose []byte // rendered compose file, variables already interpolated
+	DryRun  bool   // print the command and compose file instead of deploying
+
+	// Services limits the deploy to these compose services. The other services
+	// of the stack are left as they are and none are removed.
+	Services []string
+
+	// Driver is config.DriverNative (default) or config.DriverCLI.
+	Driver string
+	// Engine may be nil for dry runs.
+	Engine engine.Engine
+
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Deploy deploys the compose file as a stack. The native driver converts it to
+// Swarm specs and applies them through the Docker API; the CLI driver runs
+// 'docker stack deploy' and returns no result.
+func Deploy(ctx context.Context, opt DeployOptions) (*Result, error) {
+	if opt.Stdout == nil {
+		opt.Stdout = os.Stdout
+	}
+	if opt.Stderr == nil {
+		opt.Stderr = os.Stderr
+	}
+
+	if opt.Driver == config.DriverCLI {
+		return nil, deployCLI(ctx, opt)
+	}
+	return deployNative(ctx, opt)
+}
+
+// deployNative converts the compose file and applies the specs.
+func deployNative(ctx context.Context, opt DeployOptions) (*Result, error) {
+	// Relative paths are resolved like they were for the generated compose file
+	workDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	specs, err := Convert(opt.Name, opt.Compose, workDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range specs.Warnings {
+		fmt.Fprintf(opt.Stderr, "⚠️  %s\n", w)
+	}
+	if len(opt.Services) > 0 {
+		for name := range specs.Services {
+			if !slices.Contains(opt.Services, name) {
+				delete(specs.Services, name)
+			}
+		}
+	}
+
+	if opt.DryRun {
+		fmt.Fprintf(opt.Stdout, "[dry-run] native deploy of stack '%s':\n", opt.Name)
+		for _, name := range sortedKeys(specs.Services) {
+			fmt.Fprintf(opt.Stdout, "[dry-run]   service %s (%s)\n", specs.Services[name].Name, specs.Services[name].TaskTemplate.ContainerSpec.Image)
+		}
+		fmt.Fprintf(opt.Stdout, "[dry-run] rendered compose:\n---\n%s", opt.Compose)
+		return nil, nil
+	}
+	if opt.Engine == nil {
+		return nil, fmt.Errorf("deploy needs a Docker engine")
+	}
+
+	fmt.Fprintf(opt.Stdout, "🚀 Deploying stack '%s'...\n", opt.Name)
+	apply := Apply
+	if len(opt.Services) > 0 {
+		apply = Update
+	}
+	res, err := apply(ctx, opt.Engine, opt.Name, specs, opt.Stdout)
+	if err != nil {
+		return res, err
+	}
+	fmt.Fprintf(opt.Stdout, "📋 Services:\n%s", res.Summary())
+	return res, nil
+}
+
+// deployCLI runs 'docker stack deploy' through the engine.
+func deployCLI(ctx context.Context, opt DeployOptions) error {
+	// Without --prune, 'docker stack deploy' leaves the services missing from
+	// the file alone
+	prune := len(opt.Services) == 0
+	if !prune {
+		var err error
+		if opt.Compose, err = compose.SelectServices(opt.Compose, opt.Services); err != nil {
+			return err
+		}
+	}
+
+	if opt.DryRun {
+		flags := "--with-registry-auth"
+		if prune {
+			flags += " --prune"
+		}
+		fmt.Fprintf(opt.Stdout, "[dry-run] docker stack deploy --compose-file %s %s %s\n", engine.GeneratedComposeFile, flags, opt.Name)
+		fmt.Fprintf(opt.Stdout, "[dry-run] rendered %s:\n---\n%s", engine.GeneratedComposeFile, opt.Compose)
+		return nil
+	}
+	if opt.Engine == nil {
+		return fmt.Errorf("deploy needs a Docker engine")
+	}
+
+	// The file is handed over from a temporary directory, so relative paths must
+	// point at the working directory explicitly
+	workDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	data, err := compose.ResolvePaths(opt.Compose, workDir)
+	if err != nil {
+		return err
+	}
+	// The file is already interpolated; keep 'docker stack deploy' from doing it again
+	if data, err = compose.EscapeYAML(data); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(opt.Stdout, "🚀 Deploying stack '%s'...\n", opt.Name)
+	return opt.Engine.StackDeploy(ctx, engine.StackDeployOptions{
+		Name:    opt.Name,
+		Compose: data,
+		Prune:   prune,
+		Stdout:  opt.Stdout,
+		Stderr:  opt.Stderr,
+	})
+}
+
+// Remove runs 'docker stack rm' for the given stack.
+func Remove(ctx context.Context, eng engine.Stacks, name string, stdout, stderr io.Writer) error {
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+
+	fmt.Fprintf(stdout, "🗑️  Removing stack '%s'...\n", name)
+	return eng.StackRemove(ctx, name, stdout, stderr)
+}
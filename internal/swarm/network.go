@@ -0,0 +1,25 @@
+package swarm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+
+	"github.com/rollwave-dev/rollwave/internal/engine"
+)
+
+// NetworkName resolves a network name as written in the compose file. Networks
+// created by the stack are namespaced ("default" becomes "<stack>_default");
+// other names are used as they are, e.g. for external networks.
+func NetworkName(ctx context.Context, cli engine.Swarm, stack, name string) (string, error) {
+	for _, candidate := range []string{stack + "_" + name, name} {
+		if _, err := cli.NetworkInspect(ctx, candidate, types.NetworkInspectOptions{}); err == nil {
+			return candidate, nil
+		} else if !client.IsErrNotFound(err) {
+			return "", fmt.Errorf("inspect network %s: %w", candidate, err)
+		}
+	}
+	return "", fmt.Errorf("network '%s' not found (is stack '%s' deployed?)", name, stack)
+}
@@ -0,0 +1,28 @@
+package swarm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+
+	"github.com/rollwave-dev/rollwave/internal/engine"
+)
+
+// SecretID returns the ID of the Swarm secret with exactly the given name.
+func SecretID(ctx context.Context, cli engine.Swarm, name string) (string, error) {
+	f := filters.NewArgs()
+	f.Add("name", name)
+	list, err := cli.SecretList(ctx, types.SecretListOptions{Filters: f})
+	if err != nil {
+		return "", fmt.Errorf("list secrets: %w", err)
+	}
+	// The name filter matches prefixes, so check for an exact match
+	for _, s := range list {
+		if s.Spec.Name == name {
+			return s.ID, nil
+		}
+	}
+	return "", fmt.Errorf("secret '%s' not found", name)
+}
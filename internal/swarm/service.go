@@ -0,0 +1,31 @@
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+
+	"github.com/rollwave-dev/rollwave/internal/engine"
+)
+
+// ServiceImages returns the image (including its pinned digest, if any) that each
+// service of the stack runs, keyed by the service name without the stack prefix.
+func ServiceImages(ctx context.Context, cli engine.Swarm, stack string) (map[string]string, error) {
+	f := filters.NewArgs()
+	f.Add("label", StackLabel+"="+stack)
+	services, err := cli.ServiceList(ctx, types.ServiceListOptions{Filters: f})
+	if err != nil {
+		return nil, fmt.Errorf("list services: %w", err)
+	}
+
+	images := make(map[string]string, len(services))
+	for _, svc := range services {
+		if cs := svc.Spec.TaskTemplate.ContainerSpec; cs != nil && cs.Image != "" {
+			images[strings.TrimPrefix(svc.Spec.Name, stack+"_")] = cs.Image
+		}
+	}
+	return images, nil
+}
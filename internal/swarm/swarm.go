@@ -0,0 +1,7 @@
+package swarm
+
+// StackLabel is the label Docker stamps on every object belonging to a stack.
+const StackLabel = "com.docker.stack.namespace"
+
+// CanaryLabel marks canary services with the name of the service they shadow.
+const CanaryLabel = "io.rollwave.canary"
@@ -0,0 +1,242 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rollwave-dev/rollwave/internal/config"
+)
+
+// Defaults for the settings of config.VaultConfig.
+const (
+	DefaultMount        = "secret"
+	DefaultAppRoleMount = "approle"
+	RequestTimeout      = 30 * time.Second
+)
+
+var errNotFound = errors.New("not found")
+
+// Client reads secrets from the KV version 2 engine of a Vault server. It logs
+// in on the first read and caches every secret it reads.
+type Client struct {
+	address      string
+	namespace    string
+	mount        string
+	appRoleMount string
+	http         *http.Client
+
+	token string
+	cache map[string]map[string]any // path -> data
+}
+
+// New returns a client for cfg; empty settings are taken from the standard
+// VAULT_* environment variables.
+func New(cfg config.VaultConfig) (*Client, error) {
+	c := &Client{
+		address:      firstNonEmpty(cfg.Address, os.Getenv("VAULT_ADDR")),
+		namespace:    firstNonEmpty(cfg.Namespace, os.Getenv("VAULT_NAMESPACE")),
+		mount:        strings.Trim(firstNonEmpty(cfg.Mount, DefaultMount), "/"),
+		appRoleMount: strings.Trim(firstNonEmpty(cfg.AppRoleMount, DefaultAppRoleMount), "/"),
+		http:         &http.Client{Timeout: RequestTimeout},
+		cache:        make(map[string]map[string]any),
+	}
+	if c.address == "" {
+		return nil, fmt.Errorf("vault address is missing: set secrets.vault.address or VAULT_ADDR")
+	}
+	c.address = strings.TrimRight(c.address, "/")
+
+	if caCert := firstNonEmpty(cfg.CACert, os.Getenv("VAULT_CACERT")); caCert != "" {
+		pem, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("vault CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("vault CA certificate %s: no certificates found", caCert)
+		}
+		c.http.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+	return c, nil
+}
+
+// Field returns a single field of the secret at path. Without a field the
+// secret must have exactly one.
+func (c *Client) Field(ctx context.Context, path, field string) (string, error) {
+	data, err := c.Read(ctx, path)
+	if err != nil {
+		return "", err
+	}
+
+	if field == "" {
+		if len(data) != 1 {
+			return "", fmt.Errorf("vault secret %s has %d fields; select one with '%s#<field>'", path, len(data), path)
+		}
+		for f := range data {
+			field = f
+		}
+	}
+	v, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no field '%s'", path, field)
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", nil
+	default:
+		// Numbers, booleans and nested objects are passed on as JSON
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
+
+// Read returns the latest version of the secret at path.
+func (c *Client) Read(ctx context.Context, path string) (map[string]any, error) {
+	path = strings.Trim(path, "/")
+	if data, ok := c.cache[path]; ok {
+		return data, nil
+	}
+	if err := c.login(ctx); err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/"+c.mount+"/data/"+escapePath(path), nil, &resp); err != nil {
+		return nil, fmt.Errorf("read vault secret %s: %w", path, err)
+	}
+	if resp.Data.Data == nil {
+		// The latest version was deleted
+		return nil, fmt.Errorf("read vault secret %s: %w", path, errNotFound)
+	}
+
+	c.cache[path] = resp.Data.Data
+	return resp.Data.Data, nil
+}
+
+// login picks the token from VAULT_TOKEN or ~/.vault-token, or logs in with
+// AppRole when VAULT_ROLE_ID and VAULT_SECRET_ID are set.
+func (c *Client) login(ctx context.Context) error {
+	if c.token != "" {
+		return nil
+	}
+
+	roleID, secretID := os.Getenv("VAULT_ROLE_ID"), os.Getenv("VAULT_SECRET_ID")
+	switch {
+	case os.Getenv("VAULT_TOKEN") != "":
+		c.token = os.Getenv("VAULT_TOKEN")
+	case roleID != "" && secretID != "":
+		var resp struct {
+			Auth struct {
+				ClientToken string `json:"client_token"`
+			} `json:"auth"`
+		}
+		body := map[string]string{"role_id": roleID, "secret_id": secretID}
+		if err := c.do(ctx, http.MethodPost, "/v1/auth/"+c.appRoleMount+"/login", body, &resp); err != nil {
+			return fmt.Errorf("vault AppRole login: %w", err)
+		}
+		if resp.Auth.ClientToken == "" {
+			return fmt.Errorf("vault AppRole login: no token in response")
+		}
+		c.token = resp.Auth.ClientToken
+	default:
+		if home, err := os.UserHomeDir(); err == nil {
+			if b, err := os.ReadFile(filepath.Join(home, ".vault-token")); err == nil {
+				c.token = strings.TrimSpace(string(b))
+			}
+		}
+		if c.token == "" {
+			return fmt.Errorf("no vault credentials: set VAULT_TOKEN, or VAULT_ROLE_ID and VAULT_SECRET_ID")
+		}
+	}
+	return nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.address+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("X-Vault-Token", c.token)
+	}
+	if c.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errNotFound
+	case resp.StatusCode >= 300:
+		// Vault reports failures as {"errors": ["..."]}
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(data, &e) == nil && len(e.Errors) > 0 {
+			return fmt.Errorf("status %d: %s", resp.StatusCode, strings.Join(e.Errors, "; "))
+		}
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("parse response: %w", err)
+	}
+	return nil
+}
+
+func escapePath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
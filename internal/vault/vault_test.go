@@ -0,0 +1,168 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rollwave-dev/rollwave/internal/config"
+)
+
+func TestField(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string // VAULT_TOKEN
+		roleID  string // VAULT_ROLE_ID, with secret ID "s3cret"
+		path    string
+		field   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "token auth",
+			token: "root-token",
+			path:  "shop/api-key",
+			want:  "abc123",
+		},
+		{
+			name:  "field selection",
+			token: "root-token",
+			path:  "shop/db",
+			field: "password",
+			want:  "hunter2",
+		},
+		{
+			name:  "non-string field",
+			token: "root-token",
+			path:  "shop/db",
+			field: "port",
+			want:  "5432",
+		},
+		{
+			name:   "AppRole login",
+			roleID: "shop-deployer",
+			path:   "shop/db",
+			field:  "password",
+			want:   "hunter2",
+		},
+		{
+			name:    "failed AppRole login",
+			roleID:  "someone-else",
+			path:    "shop/db",
+			field:   "password",
+			wantErr: "vault AppRole login: status 400: invalid role or secret ID",
+		},
+		{
+			name:    "permission denied",
+			token:   "expired-token",
+			path:    "shop/db",
+			field:   "password",
+			wantErr: "status 403: permission denied",
+		},
+		{
+			name:    "secret not found",
+			token:   "root-token",
+			path:    "shop/missing",
+			wantErr: "read vault secret shop/missing: not found",
+		},
+		{
+			name:    "missing field",
+			token:   "root-token",
+			path:    "shop/db",
+			field:   "username",
+			wantErr: "vault secret shop/db has no field 'username'",
+		},
+		{
+			name:    "several fields without selection",
+			token:   "root-token",
+			path:    "shop/db",
+			wantErr: "has 3 fields",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(t)
+			t.Setenv("HOME", t.TempDir()) // no ~/.vault-token
+			t.Setenv("VAULT_TOKEN", tt.token)
+			t.Setenv("VAULT_ROLE_ID", tt.roleID)
+			t.Setenv("VAULT_SECRET_ID", "s3cret")
+
+			c, err := New(config.VaultConfig{Address: srv.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.Field(context.Background(), tt.path, tt.field)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Field = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// newServer starts a Vault server with a KV version 2 engine at "secret", a
+// root token and an AppRole "shop-deployer".
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	secrets := map[string]map[string]any{
+		"shop/api-key": {"value": "abc123"},
+		"shop/db":      {"user": "shop", "password": "hunter2", "port": 5432},
+	}
+	tokens := map[string]bool{"root-token": true, "approle-token": true}
+
+	reply := func(w http.ResponseWriter, status int, body any) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+	fail := func(w http.ResponseWriter, status int, errs ...string) {
+		reply(w, status, map[string][]string{"errors": errs})
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/auth/approle/login", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			RoleID   string `json:"role_id"`
+			SecretID string `json:"secret_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			fail(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if body.RoleID != "shop-deployer" || body.SecretID != "s3cret" {
+			fail(w, http.StatusBadRequest, "invalid role or secret ID")
+			return
+		}
+		reply(w, http.StatusOK, map[string]any{"auth": map[string]string{"client_token": "approle-token"}})
+	})
+	mux.HandleFunc("GET /v1/secret/data/", func(w http.ResponseWriter, r *http.Request) {
+		if !tokens[r.Header.Get("X-Vault-Token")] {
+			fail(w, http.StatusForbidden, "permission denied")
+			return
+		}
+		data, ok := secrets[strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")]
+		if !ok {
+			fail(w, http.StatusNotFound)
+			return
+		}
+		reply(w, http.StatusOK, map[string]any{
+			"data": map[string]any{"data": data, "metadata": map[string]any{"version": 1}},
+		})
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
@@ -0,0 +1,201 @@
+package verify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	dockerswarm "github.com/docker/docker/api/types/swarm"
+
+	"github.com/rollwave-dev/rollwave/internal/compose"
+	"github.com/rollwave-dev/rollwave/internal/config"
+	"github.com/rollwave-dev/rollwave/internal/engine"
+	"github.com/rollwave-dev/rollwave/internal/job"
+	"github.com/rollwave-dev/rollwave/internal/probe"
+	"github.com/rollwave-dev/rollwave/internal/swarm"
+)
+
+// DefaultInterval is the delay between attempts of an HTTP check.
+const DefaultInterval = 5 * time.Second
+
+// Options defines the parameters for verifying a deployed stack.
+type Options struct {
+	Stack     string
+	Variables map[string]string // used to interpolate URLs, images and env values
+
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Result is the outcome of a single check.
+type Result struct {
+	Name string
+	Err  error
+}
+
+// Report collects the results of all checks.
+type Report []Result
+
+// Failed returns the results of the checks that did not pass.
+func (r Report) Failed() []Result {
+	var failed []Result
+	for _, res := range r {
+		if res.Err != nil {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// Print writes one line per check.
+func (r Report) Print(w io.Writer) {
+	for _, res := range r {
+		if res.Err != nil {
+			fmt.Fprintf(w, "   ❌ %s: %v\n", res.Name, res.Err)
+		} else {
+			fmt.Fprintf(w, "   ✅ %s\n", res.Name)
+		}
+	}
+}
+
+// Run executes every HTTP check and test container and returns an error listing
+// the checks that failed.
+func Run(ctx context.Context, cli engine.Swarm, v config.VerifyConfig, opt Options) (Report, error) {
+	if opt.Stdout == nil {
+		opt.Stdout = os.Stdout
+	}
+	if opt.Stderr == nil {
+		opt.Stderr = os.Stderr
+	}
+	lookup := compose.LookupVariables(opt.Variables)
+
+	fmt.Fprintln(opt.Stdout, "🔎 Verifying deployment...")
+
+	var report Report
+	for i, c := range v.HTTP {
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprintf("http[%d]", i)
+		}
+		report = append(report, Result{Name: name, Err: runHTTP(ctx, c, lookup, opt.Stdout)})
+	}
+	for i, c := range v.Containers {
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprintf("container[%d]", i)
+		}
+		report = append(report, Result{Name: name, Err: runContainer(ctx, cli, name, c, lookup, opt)})
+	}
+
+	fmt.Fprintln(opt.Stdout, "📋 Verification report:")
+	report.Print(opt.Stdout)
+
+	if failed := report.Failed(); len(failed) > 0 {
+		names := make([]string, 0, len(failed))
+		for _, res := range failed {
+			names = append(names, res.Name)
+		}
+		return report, fmt.Errorf("verification failed: %s", strings.Join(names, ", "))
+	}
+	return report, nil
+}
+
+func runHTTP(ctx context.Context, c config.HTTPCheck, lookup compose.LookupFunc, stdout io.Writer) error {
+	url, err := compose.Interpolate(c.URL, lookup)
+	if err != nil {
+		return err
+	}
+	interval := DefaultInterval
+	if c.Interval != "" {
+		if interval, err = time.ParseDuration(c.Interval); err != nil {
+			return fmt.Errorf("invalid interval '%s': %w", c.Interval, err)
+		}
+	}
+
+	check := probe.HTTP{URL: url, ExpectStatus: c.ExpectStatus, BodyContains: c.BodyContains}
+	for attempt := 0; ; attempt++ {
+		err = check.Check(ctx)
+		if err == nil || attempt >= c.Retries {
+			return err
+		}
+		fmt.Fprintf(stdout, "   ↻ %v (retry %d/%d in %s)\n", err, attempt+1, c.Retries, interval)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+func runContainer(ctx context.Context, cli engine.Swarm, name string, c config.TestContainer, lookup compose.LookupFunc, opt Options) error {
+	image, err := compose.Interpolate(c.Image, lookup)
+	if err != nil {
+		return err
+	}
+	var timeout time.Duration
+	if c.Timeout != "" {
+		if timeout, err = time.ParseDuration(c.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout '%s': %w", c.Timeout, err)
+		}
+	}
+
+	env := make([]string, 0, len(c.Env))
+	for k, v := range c.Env {
+		val, err := compose.Interpolate(v, lookup)
+		if err != nil {
+			return err
+		}
+		env = append(env, k+"="+val)
+	}
+
+	networks := make([]dockerswarm.NetworkAttachmentConfig, 0, len(c.Networks))
+	for _, n := range c.Networks {
+		target, err := swarm.NetworkName(ctx, cli, opt.Stack, n)
+		if err != nil {
+			return err
+		}
+		networks = append(networks, dockerswarm.NetworkAttachmentConfig{Target: target})
+	}
+
+	fmt.Fprintf(opt.Stdout, "🧪 Running test container %s (%s)\n", name, image)
+	code, err := job.Run(ctx, cli, job.Options{
+		Spec: dockerswarm.ServiceSpec{
+			Annotations: dockerswarm.Annotations{Name: fmt.Sprintf("%s_verify_%s_%d", opt.Stack, jobName(name), time.Now().Unix())},
+			TaskTemplate: dockerswarm.TaskSpec{
+				ContainerSpec: &dockerswarm.ContainerSpec{
+					Image:   image,
+					Command: c.Command,
+					Env:     env,
+				},
+				Networks: networks,
+			},
+		},
+		Kind:    "verify",
+		Timeout: timeout,
+		Stdout:  opt.Stdout,
+		Stderr:  opt.Stderr,
+	})
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("exited with code %d", code)
+	}
+	return nil
+}
+
+// --- Helpers ---
+
+// jobName makes a check name safe for use in a Swarm service name.
+func jobName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '-'
+	}, name)
+}